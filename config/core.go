@@ -1,11 +1,17 @@
 package config
 
-import "github.com/jmoiron/sqlx"
+import (
+	"github.com/hexcraft-biz/base-accounts-service/misc"
+	"github.com/jmoiron/sqlx"
+)
 
 type ConfigInterface interface {
 	GetDB() *sqlx.DB
 	GetTrustProxy() string
-	GetJWTSecret() []byte
+	GetJWTKeyset() *misc.JWTKeyset
+	GetMailer() misc.Mailer
+	GetEmailOutboxPolicy() *misc.EmailOutboxPolicy
+	GetEmailListUnsubscribe() string
 	GetSMTPHost() string
 	GetSMTPPort() string
 	GetSMTPUsername() string
@@ -18,4 +24,36 @@ type ConfigInterface interface {
 	GetForgetPwdEmailSubject() string
 	GetForgetPwdEmailContent() string
 	GetForgetPwdEmailLinkText() string
+	GetMagicLinkEmailSubject() string
+	GetMagicLinkEmailContent() string
+	GetMagicLinkEmailLinkText() string
+	GetUnlockPageURL() string
+	GetUnlockEmailSubject() string
+	GetUnlockEmailContent() string
+	GetUnlockEmailLinkText() string
+	GetPrivacyMode() bool
+	GetAccountExistsEmailSubject() string
+	GetAccountExistsEmailContent() string
+	GetNoAccountEmailSubject() string
+	GetNoAccountEmailContent() string
+	GetAccountDisabledEmailSubject() string
+	GetAccountDisabledEmailContent() string
+	GetAccessTokenExpireSecs() int
+	GetRefreshTokenExpireSecs() int
+	GetPasswordPolicy() *misc.PasswordPolicy
+	GetPasswordHistorySize() int
+	GetPasswordHasher() misc.PasswordHasher
+	GetAdminAPIKey() string
+	IsAdminIdentity(identity string) bool
+	GetTOTPIssuer() string
+	GetWebAuthnConfig() *misc.WebAuthnConfig
+	GetOIDCConfig() *misc.OIDCConfig
+	GetRequireClientToken() bool
+	GetLockoutPolicy() *misc.LockoutPolicy
+	GetRateLimitRules() []*misc.RateLimitRule
+	GetRateLimitStore() misc.RateLimitStore
+	GetURLAllowlist() *misc.URLAllowlist
+	GetLocalizer() *misc.Localizer
+	GetProductName() string
+	GetEmailTemplates() *misc.EmailTemplates
 }
@@ -0,0 +1,658 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hexcraft-biz/base-accounts-service/config"
+	"github.com/hexcraft-biz/base-accounts-service/misc"
+	"github.com/hexcraft-biz/base-accounts-service/models"
+	"github.com/hexcraft-biz/controller"
+	"github.com/hexcraft-biz/model"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	USER_IMPORT_MAX_BYTES     = 32 << 20
+	OAUTH_CLIENT_SECRET_BYTES = 32
+)
+
+type Admin struct {
+	*controller.Prototype
+	Config config.ConfigInterface
+}
+
+func NewAdmin(cfg config.ConfigInterface) *Admin {
+	return &Admin{
+		Prototype: controller.New("admin", cfg.GetDB()),
+		Config:    cfg,
+	}
+}
+
+// ================================================================
+// Users Import
+// ================================================================
+type importUsersParams struct {
+	Format string `form:"format" binding:"omitempty,oneof=jsonl csv"`
+}
+
+type importUsersResp struct {
+	Total   int                      `json:"total"`
+	Created int                      `json:"created"`
+	Failed  int                      `json:"failed"`
+	Results []*misc.UserImportResult `json:"results"`
+}
+
+func (ctrl *Admin) ImportUsers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var params importUsersParams
+		if err := c.ShouldBindQuery(&params); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+
+		if params.Format == "" {
+			mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+			switch mediaType {
+			case "text/csv":
+				params.Format = misc.USER_IMPORT_FORMAT_CSV
+			case "application/x-ndjson", "application/jsonl":
+				params.Format = misc.USER_IMPORT_FORMAT_JSONL
+			default:
+				c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, gin.H{"message": misc.ErrUnknownImportFormat.Error()})
+				return
+			}
+		}
+
+		records, err := misc.ParseUserImport(http.MaxBytesReader(c.Writer, c.Request.Body, USER_IMPORT_MAX_BYTES), params.Format)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+
+		resp := importUsersResp{
+			Total:   len(records),
+			Results: models.NewUsersTableEngine(ctrl.DB).Import(records),
+		}
+		for _, r := range resp.Results {
+			if r.Result == misc.USER_IMPORT_RESULT_CREATED {
+				resp.Created += 1
+			} else {
+				resp.Failed += 1
+			}
+		}
+
+		c.AbortWithStatusJSON(http.StatusOK, resp)
+		return
+	}
+}
+
+// ================================================================
+// Users
+// ================================================================
+type listUsersParams struct {
+	Status   string `form:"status" binding:"omitempty,oneof=enabled disabled suspended"`
+	Identity string `form:"identity" binding:"omitempty,max=128"`
+	Sort     string `form:"sort" binding:"omitempty,oneof=asc desc"`
+	Offset   int    `form:"offset" binding:"omitempty,min=0"`
+	Length   int    `form:"length" binding:"omitempty,min=1,max=100"`
+}
+
+type listUsersResp struct {
+	Total   int                 `json:"total"`
+	Results []*models.AdminUser `json:"results"`
+}
+
+func (ctrl *Admin) ListUsers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		params := listUsersParams{
+			Sort:   models.USERS_ORDER_DESC,
+			Offset: model.DefaultOffset,
+			Length: model.DefaultLength,
+		}
+		if err := c.ShouldBindQuery(&params); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+
+		if rows, total, err := models.NewUsersTableEngine(ctrl.DB).List(&models.UsersListFilter{
+			Status:   params.Status,
+			Identity: params.Identity,
+			Order:    params.Sort,
+			Offset:   params.Offset,
+			Length:   params.Length,
+		}); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else {
+			resp := listUsersResp{Total: total, Results: make([]*models.AdminUser, len(rows))}
+			for i, u := range rows {
+				resp.Results[i] = u.GetAdminUser()
+			}
+
+			c.AbortWithStatusJSON(http.StatusOK, resp)
+			return
+		}
+	}
+}
+
+func (ctrl *Admin) GetUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if entityRes, err := ctrl.getUser(c.Param("id")); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if entityRes == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
+			return
+		} else {
+			c.AbortWithStatusJSON(http.StatusOK, entityRes.GetAdminUser())
+			return
+		}
+	}
+}
+
+type updateUserStatusParams struct {
+	Status string `json:"status" binding:"required,oneof=enabled disabled suspended"`
+	Reason string `json:"reason" binding:"omitempty,max=255"`
+}
+
+// UpdateUserStatus also signs out every session of a user that is not enabled anymore, in the transaction of the
+// status.
+func (ctrl *Admin) UpdateUserStatus() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var params updateUserStatusParams
+		if err := c.ShouldBindJSON(&params); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+
+		entityRes, err := ctrl.getUser(c.Param("id"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if entityRes == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
+			return
+		}
+
+		usersEngine := models.NewUsersTableEngine(ctrl.DB)
+		if err := models.WithTx(ctrl.DB, func(tx *sqlx.Tx) error {
+			if _, err := usersEngine.UpdateStatus(tx, entityRes.ID, params.Status, params.Reason); err != nil {
+				return err
+			} else if params.Status != models.USER_STATUS_ENABLED {
+				return NewAuth(ctrl.Config).endSessions(tx, entityRes.ID, nil)
+			}
+			return nil
+		}); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+
+		if entityRes, err := usersEngine.GetByID(entityRes.ID.String()); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if entityRes == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
+			return
+		} else {
+			c.AbortWithStatusJSON(http.StatusOK, entityRes.GetAdminUser())
+			return
+		}
+	}
+}
+
+type resetUserPasswordParams struct {
+	VerifyPageUrl string `json:"verifyPageURL" binding:"omitempty,url"`
+	Continue      string `json:"continue" binding:"omitempty,url"`
+	Locale        string `json:"locale" binding:"omitempty,max=35"`
+}
+
+// ResetUserPassword refuses password logins until the user sets a new password and revokes the refresh tokens.
+// With verifyPageURL the user is emailed the same link as by /auth/v1/forgetpassword/confirmation.
+func (ctrl *Admin) ResetUserPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var (
+			params resetUserPasswordParams
+			uri    *url.URL
+		)
+
+		auth := NewAuth(ctrl.Config)
+
+		if err := c.ShouldBindJSON(&params); err != nil && !errors.Is(err, io.EOF) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		} else if params.VerifyPageUrl == "" && params.Continue != "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "continue requires verifyPageURL."})
+			return
+		} else if params.VerifyPageUrl != "" {
+			if uri, err = url.ParseRequestURI(params.VerifyPageUrl); err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+				return
+			} else if err := auth.checkEmailLinkURLs(params.VerifyPageUrl, params.Continue); err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+				return
+			}
+		}
+
+		entityRes, err := ctrl.getUser(c.Param("id"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if entityRes == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
+			return
+		}
+
+		// The flag, the end of the sessions and the link are committed together, a link is never sent for a reset that
+		// did not happen.
+		if err := models.WithTx(ctrl.DB, func(tx *sqlx.Tx) error {
+			if _, err := models.NewUsersTableEngine(ctrl.DB).RequirePasswordReset(tx, entityRes.ID); err != nil {
+				return err
+			} else if err := auth.endSessions(tx, entityRes.ID, nil); err != nil {
+				return err
+			} else if uri == nil {
+				return nil
+			}
+
+			locale := auth.emailLocale(nil, params.Locale, entityRes)
+			tokenString, err := auth.genEmailToken(tx, entityRes.Identity, JWT_TYPE_FORGET_PWD, params.Continue, locale)
+			if err != nil {
+				return err
+			}
+			return auth.sendLinkEmail(tx, entityRes.Identity, JWT_TYPE_FORGET_PWD, locale, entityRes, uri, tokenString)
+		}); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusNoContent, gin.H{"message": http.StatusText(http.StatusNoContent)})
+		return
+	}
+}
+
+// DeleteUser removes the user with everything referencing it, and invalidates the links already emailed to it. All of
+// it happens in one transaction, a user is never gone while its links still work.
+func (ctrl *Admin) DeleteUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		entityRes, err := ctrl.getUser(c.Param("id"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if entityRes == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
+			return
+		}
+
+		var affected int64
+		emailTokensEngine := models.NewEmailTokensTableEngine(ctrl.DB)
+		if err := models.WithTx(ctrl.DB, func(tx *sqlx.Tx) (err error) {
+			if affected, err = models.NewUsersTableEngine(ctrl.DB).Delete(tx, entityRes.ID); err != nil || affected == 0 {
+				return err
+			}
+
+			for _, typ := range []string{JWT_TYPE_FORGET_PWD, JWT_TYPE_MAGIC_LOGIN, JWT_TYPE_UNLOCK} {
+				if _, err := emailTokensEngine.RevokePending(tx, entityRes.Identity, typ); err != nil {
+					return err
+				}
+			}
+
+			_, err = models.NewLoginAttemptsTableEngine(ctrl.DB).Clear(tx, entityRes.Identity)
+			return err
+		}); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if affected == 0 {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusNoContent, gin.H{"message": http.StatusText(http.StatusNoContent)})
+		return
+	}
+}
+
+// getUser answers nil for an id that is not a UUID, like for an unknown user.
+func (ctrl *Admin) getUser(id string) (*models.EntityUser, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, nil
+	}
+
+	return models.NewUsersTableEngine(ctrl.DB).GetByID(id)
+}
+
+// ================================================================
+// OAuth Clients
+// ================================================================
+type oauthClientParams struct {
+	Name         string   `json:"name" binding:"required,min=1,max=128"`
+	RedirectURIs []string `json:"redirectUris" binding:"omitempty,max=10,dive,url,max=2048"`
+	Scopes       []string `json:"scopes" binding:"required,min=1,dive,oneof=openid email user.prototype"`
+}
+
+type createOAuthClientParams struct {
+	oauthClientParams
+	Public bool `json:"public"`
+}
+
+type oauthClientSecretResp struct {
+	*models.AbsOAuthClient
+	ClientSecret string `json:"clientSecret,omitempty"`
+}
+
+// check rejects what the bindings can not tell: redirect URIs the authorization endpoint could not compare, a
+// client of the OpenID Connect scopes without redirect URI and a public client asking for client scopes.
+func (p *oauthClientParams) check(public bool) error {
+	for _, uri := range p.RedirectURIs {
+		if u, err := url.Parse(uri); err != nil || !u.IsAbs() || u.Fragment != "" || strings.ContainsAny(uri, " \t\r\n") {
+			return errors.New("Invalid redirect URI : " + uri)
+		}
+	}
+
+	scopes := strings.Join(p.Scopes, " ")
+	for _, scope := range misc.OIDCScopes {
+		if misc.HasScope(scopes, scope) && len(p.RedirectURIs) == 0 {
+			return errors.New("The " + scope + " scope requires redirectUris.")
+		}
+	}
+	for _, scope := range misc.ClientScopes {
+		if misc.HasScope(scopes, scope) && public {
+			return errors.New("The " + scope + " scope requires a confidential client.")
+		}
+	}
+
+	return nil
+}
+
+type listOAuthClientsParams struct {
+	Offset int `form:"offset" binding:"omitempty,min=0"`
+	Length int `form:"length" binding:"omitempty,min=1,max=100"`
+}
+
+func (ctrl *Admin) ListOAuthClients() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		params := listOAuthClientsParams{
+			Offset: model.DefaultOffset,
+			Length: model.DefaultLength,
+		}
+		if err := c.ShouldBindQuery(&params); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+
+		if rows, err := models.NewOAuthClientsTableEngine(ctrl.DB).List(params.Offset, params.Length); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else {
+			results := make([]*models.AbsOAuthClient, len(rows))
+			for i, o := range rows {
+				results[i] = o.GetAbsOAuthClient()
+			}
+
+			c.AbortWithStatusJSON(http.StatusOK, results)
+			return
+		}
+	}
+}
+
+func (ctrl *Admin) GetOAuthClient() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if entityRes, err := models.NewOAuthClientsTableEngine(ctrl.DB).GetByID(c.Param("id")); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if entityRes == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
+			return
+		} else {
+			c.AbortWithStatusJSON(http.StatusOK, entityRes.GetAbsOAuthClient())
+			return
+		}
+	}
+}
+
+// CreateOAuthClient registers a client. The secret of a confidential client is only ever shown in this response.
+func (ctrl *Admin) CreateOAuthClient() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var params createOAuthClientParams
+		if err := c.ShouldBindJSON(&params); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		} else if err := params.check(params.Public); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+
+		var (
+			secret     string
+			secretHash []byte
+			err        error
+		)
+		if !params.Public {
+			if secret, err = misc.GenOpaqueToken(OAUTH_CLIENT_SECRET_BYTES); err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+				return
+			}
+			secretHash = misc.HashToken(secret)
+		}
+
+		if entityRes, err := models.NewOAuthClientsTableEngine(ctrl.DB).Insert(params.Name, secretHash, params.RedirectURIs, params.Scopes); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else {
+			c.AbortWithStatusJSON(http.StatusCreated, oauthClientSecretResp{AbsOAuthClient: entityRes.GetAbsOAuthClient(), ClientSecret: secret})
+			return
+		}
+	}
+}
+
+// UpdateOAuthClient replaces the name, redirect URIs and scopes. Tokens already issued keep their scope until they
+// expire.
+func (ctrl *Admin) UpdateOAuthClient() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var params oauthClientParams
+		if err := c.ShouldBindJSON(&params); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+
+		clientsEngine := models.NewOAuthClientsTableEngine(ctrl.DB)
+
+		entityRes, err := clientsEngine.GetByID(c.Param("id"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if entityRes == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
+			return
+		} else if err := params.check(entityRes.IsPublic()); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+
+		if _, err := clientsEngine.Update(entityRes.ID, params.Name, params.RedirectURIs, params.Scopes); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+
+		if entityRes, err := clientsEngine.GetByID(entityRes.ID.String()); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if entityRes == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
+			return
+		} else {
+			c.AbortWithStatusJSON(http.StatusOK, entityRes.GetAbsOAuthClient())
+			return
+		}
+	}
+}
+
+// RotateOAuthClientSecret hands out a new secret, a public client answers 409.
+func (ctrl *Admin) RotateOAuthClientSecret() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientsEngine := models.NewOAuthClientsTableEngine(ctrl.DB)
+
+		entityRes, err := clientsEngine.GetByID(c.Param("id"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if entityRes == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
+			return
+		} else if entityRes.IsPublic() {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "A public client has no secret."})
+			return
+		}
+
+		secret, err := misc.GenOpaqueToken(OAUTH_CLIENT_SECRET_BYTES)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+
+		if affected, err := clientsEngine.UpdateSecret(entityRes.ID, misc.HashToken(secret)); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if affected == 0 {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusOK, oauthClientSecretResp{AbsOAuthClient: entityRes.GetAbsOAuthClient(), ClientSecret: secret})
+		return
+	}
+}
+
+// DeleteOAuthClient removes the client with its codes and refresh tokens. Its access tokens stay valid until they
+// expire.
+func (ctrl *Admin) DeleteOAuthClient() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientsEngine := models.NewOAuthClientsTableEngine(ctrl.DB)
+
+		entityRes, err := clientsEngine.GetByID(c.Param("id"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if entityRes == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
+			return
+		}
+
+		if affected, err := clientsEngine.Delete(entityRes.ID); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if affected == 0 {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusNoContent, gin.H{"message": http.StatusText(http.StatusNoContent)})
+		return
+	}
+}
+
+// ================================================================
+// Lockouts
+// ================================================================
+func (ctrl *Admin) ClearLockout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var affected int64
+		if err := models.WithTx(ctrl.DB, func(tx *sqlx.Tx) (err error) {
+			affected, err = models.NewLoginAttemptsTableEngine(ctrl.DB).Clear(tx, c.Param("identity"))
+			return err
+		}); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if affected == 0 {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusNoContent, gin.H{"message": http.StatusText(http.StatusNoContent)})
+		return
+	}
+}
+
+// ================================================================
+// Email Outbox
+// ================================================================
+type listEmailOutboxParams struct {
+	Status string `form:"status" binding:"omitempty,oneof=pending sent dead"`
+	Offset int    `form:"offset" binding:"omitempty,min=0"`
+	Length int    `form:"length" binding:"omitempty,min=1,max=100"`
+}
+
+func (ctrl *Admin) ListEmailOutbox() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		params := listEmailOutboxParams{
+			Offset: model.DefaultOffset,
+			Length: model.DefaultLength,
+		}
+		if err := c.ShouldBindQuery(&params); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+
+		if rows, err := models.NewEmailOutboxTableEngine(ctrl.DB).List(params.Status, params.Offset, params.Length); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else {
+			results := make([]*models.AbsEmailOutbox, len(rows))
+			for i, o := range rows {
+				results[i] = o.GetAbsEmailOutbox()
+			}
+
+			c.AbortWithStatusJSON(http.StatusOK, results)
+			return
+		}
+	}
+}
+
+func (ctrl *Admin) GetEmailOutbox() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if entityRes, err := models.NewEmailOutboxTableEngine(ctrl.DB).GetByID(c.Param("id")); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if entityRes == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
+			return
+		} else {
+			c.AbortWithStatusJSON(http.StatusOK, entityRes.GetAbsEmailOutbox())
+			return
+		}
+	}
+}
+
+// RetryEmailOutbox queues a dead message again, a message that is not dead answers 409.
+func (ctrl *Admin) RetryEmailOutbox() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		outboxEngine := models.NewEmailOutboxTableEngine(ctrl.DB)
+
+		if entityRes, err := outboxEngine.GetByID(c.Param("id")); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if entityRes == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
+			return
+		} else if entityRes.Status != models.EMAIL_OUTBOX_STATUS_DEAD {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "Only dead messages can be retried."})
+			return
+		}
+
+		if affected, err := outboxEngine.Retry(c.Param("id")); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if affected == 0 {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "Only dead messages can be retried."})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusNoContent, gin.H{"message": http.StatusText(http.StatusNoContent)})
+		return
+	}
+}
@@ -1,9 +1,8 @@
 package controllers
 
 import (
-	"bytes"
+	"errors"
 	"net/http"
-	"text/template"
 	"time"
 
 	"net/url"
@@ -11,18 +10,37 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/go-sql-driver/mysql"
 	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
 	"github.com/hexcraft-biz/base-accounts-service/config"
+	"github.com/hexcraft-biz/base-accounts-service/middlewares"
 	"github.com/hexcraft-biz/base-accounts-service/misc"
 	"github.com/hexcraft-biz/base-accounts-service/models"
 	"github.com/hexcraft-biz/controller"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/jmoiron/sqlx"
 )
 
 const (
-	USER_STATUS_ENABLED            = "enabled"
+	USER_STATUS_ENABLED            = models.USER_STATUS_ENABLED
 	EMAIL_CONFIRMATION_EXPIRE_MINS = 10
-	JWT_TYPE_SIGN_UP               = "signup"
-	JWT_TYPE_FORGET_PWD            = "forgetpwd"
+	JWT_TYPE_SIGN_UP               = misc.EMAIL_TYPE_SIGN_UP
+	JWT_TYPE_FORGET_PWD            = misc.EMAIL_TYPE_FORGET_PWD
+	JWT_TYPE_MAGIC_LOGIN           = misc.EMAIL_TYPE_MAGIC_LOGIN
+	JWT_TYPE_UNLOCK                = misc.EMAIL_TYPE_UNLOCK
+	JWT_TYPE_ACCESS                = misc.JWT_TYPE_ACCESS
+	JWT_TYPE_MFA                   = misc.JWT_TYPE_MFA
+	MFA_TOKEN_EXPIRE_MINS          = 5
+	TOKEN_TYPE_BEARER              = "Bearer"
+	REFRESH_TOKEN_BYTES            = 32
+)
+
+var (
+	ErrVerifyPageURLNotAllowed = errors.New("verifyPageURL is not allowed.")
+	ErrContinueURLNotAllowed   = errors.New("continue is not allowed.")
+	ErrRefreshTokenInvalid     = errors.New("The refresh token is invalid.")
+	ErrAccountNotEnabled       = errors.New("This account is not enabled.")
+	ErrEmailTokenInvalid       = errors.New("The email token is invalid.")
+	ErrPasswordResetRequired   = errors.New("Password reset is required.")
+	ErrMfaTokenInvalid         = errors.New("The MFA token is invalid.")
 )
 
 type Auth struct {
@@ -41,8 +59,20 @@ func NewAuth(cfg config.ConfigInterface) *Auth {
 // Auth Login
 // ================================================================
 type genTokenParams struct {
-	Identity string `json:"identity" binding:"required,email,min=1,max=128"`
-	Password string `json:"password" binding:"required,min=5,max=128"`
+	Identity string `json:"identity" binding:"required,email,min=1,max=127"`
+	Password string `json:"password" binding:"required,max=128"`
+}
+
+type tokenResp struct {
+	AccessToken  string `json:"accessToken"`
+	TokenType    string `json:"tokenType"`
+	ExpiresIn    int    `json:"expiresIn"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+type loginResp struct {
+	*models.AbsUser
+	*tokenResp
 }
 
 func (ctrl *Auth) Login() gin.HandlerFunc {
@@ -53,45 +83,376 @@ func (ctrl *Auth) Login() gin.HandlerFunc {
 			return
 		}
 
-		if entityRes, err := models.NewUsersTableEngine(ctrl.DB).GetByIdentity(params.Identity); err != nil {
+		privacyMode := ctrl.Config.GetPrivacyMode()
+
+		entityRes, err := models.NewUsersTableEngine(ctrl.DB).GetByIdentity(params.Identity)
+		if err != nil {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
 			return
-		} else {
-			if entityRes == nil {
-				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
+		} else if entityRes == nil && !privacyMode {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
+			return
+		}
+
+		// From here on entityRes is only nil in privacy mode, where an unknown identity must look like a wrong password.
+		lockoutPolicy := ctrl.Config.GetLockoutPolicy()
+		attemptsEngine := models.NewLoginAttemptsTableEngine(ctrl.DB)
+
+		if lockoutPolicy.Enabled() {
+			if attemptRes, err := attemptsEngine.GetByIdentity(params.Identity); err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
 				return
-			} else {
-				saltedPwd := append([]byte(params.Password), entityRes.Salt...)
-				compareErr := bcrypt.CompareHashAndPassword(entityRes.Password, saltedPwd)
-				if compareErr != nil {
-					c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Password is wrong."})
-					return
-				}
+			} else if attemptRes != nil && attemptRes.IsLocked() {
+				respondLocked(c, attemptRes)
+				return
+			}
+		}
+
+		matched := false
+		if entityRes == nil {
+			if err := misc.DummyVerifyPassword(ctrl.Config.GetPasswordHasher(), params.Password); err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+				return
+			}
+		} else if matched, err = entityRes.VerifyPassword(params.Password); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
 
-				if entityRes.Status != USER_STATUS_ENABLED {
-					c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "This account is not enabled."})
+		if !matched {
+			if lockoutPolicy.Enabled() {
+				if attemptRes, locked, err := attemptsEngine.RecordFailure(params.Identity, lockoutPolicy); err != nil {
+					c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+					return
+				} else if locked {
+					if entityRes != nil {
+						if err := ctrl.sendUnlockEmail(entityRes, ctrl.emailLocale(c, "", entityRes)); err != nil {
+							c.Error(err)
+						}
+					}
+					respondLocked(c, attemptRes)
 					return
 				}
+			}
 
-				if absRes, absErr := entityRes.GetAbsUser(); absErr != nil {
-					c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": absErr.Error()})
-					return
-				} else {
-					c.AbortWithStatusJSON(http.StatusOK, absRes)
+			if privacyMode {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Identity or password is wrong."})
+				return
+			} else {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Password is wrong."})
+				return
+			}
+		}
+
+		if entityRes.Status != USER_STATUS_ENABLED {
+			ctrl.respondNotEnabled(c, entityRes, "Identity or password is wrong.")
+			return
+		}
+
+		if lockoutPolicy.Enabled() {
+			if err := models.WithTx(ctrl.DB, func(tx *sqlx.Tx) error {
+				_, err := attemptsEngine.Clear(tx, params.Identity)
+				return err
+			}); err != nil {
+				c.Error(err)
+			}
+		}
+
+		// The plaintext is only known here, so this is the moment to move the user onto the preferred hasher.
+		if hasher := ctrl.Config.GetPasswordHasher(); entityRes.NeedsRehash(hasher) {
+			if _, err := models.NewUsersTableEngine(ctrl.DB).Rehash(hasher, entityRes.ID, params.Password, entityRes.Salt); err != nil {
+				c.Error(err)
+			}
+		}
+
+		ctrl.completeLogin(c, entityRes)
+		return
+	}
+}
+
+// completeLogin is shared by every first factor, it hands out an MFA challenge when the user enrolled a second
+// factor and tokens otherwise.
+func (ctrl *Auth) completeLogin(c *gin.Context, entityRes *models.EntityUser) {
+	// Checked before the MFA challenge too, so the user is not asked for a second factor that can't get tokens.
+	if entityRes.PasswordResetRequired {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": ErrPasswordResetRequired.Error()})
+		return
+	}
+
+	methods, err := ctrl.mfaMethods(entityRes.ID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	if len(methods) > 0 {
+		if mfaToken, err := ctrl.genMfaToken(entityRes.ID); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else {
+			c.AbortWithStatusJSON(http.StatusOK, mfaChallengeResp{
+				Status:   MFA_STATUS_REQUIRED,
+				MfaToken: mfaToken,
+				Methods:  methods,
+			})
+			return
+		}
+	}
+
+	ctrl.respondTokens(c, entityRes)
+}
+
+func (ctrl *Auth) respondTokens(c *gin.Context, entityRes *models.EntityUser) {
+	absRes, absErr := entityRes.GetAbsUser()
+	if absErr != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": absErr.Error()})
+		return
+	}
+
+	if tokenRes, err := ctrl.issueTokens(c, entityRes, nil); err == ErrPasswordResetRequired {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": err.Error()})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	} else {
+		c.AbortWithStatusJSON(http.StatusOK, loginResp{AbsUser: absRes, tokenResp: tokenRes})
+		return
+	}
+}
+
+// ================================================================
+// Token
+// ================================================================
+type refreshTokenParams struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+func (ctrl *Auth) RefreshToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var params refreshTokenParams
+		if err := c.ShouldBindJSON(&params); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+
+		refreshRes, userRes, err := ctrl.rotateRefreshToken(params.RefreshToken, nil)
+		if err == ErrAccountNotEnabled {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+			return
+		} else if err == ErrRefreshTokenInvalid {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": http.StatusText(http.StatusUnauthorized)})
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+
+		if tokenRes, err := ctrl.issueTokens(c, userRes, refreshRes.SessionID); err == ErrPasswordResetRequired {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": err.Error()})
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else {
+			c.AbortWithStatusJSON(http.StatusOK, tokenRes)
+			return
+		}
+	}
+}
+
+// rotateRefreshToken revokes the presented refresh token and returns it with its user, the caller issues the
+// next pair. The token has to belong to clientID, nil being /auth/v1.
+func (ctrl *Auth) rotateRefreshToken(refreshToken string, clientID *uuid.UUID) (*models.EntityRefreshToken, *models.EntityUser, error) {
+	refreshTokensEngine := models.NewRefreshTokensTableEngine(ctrl.DB)
+
+	entityRes, err := refreshTokensEngine.GetByTokenHash(misc.HashToken(refreshToken))
+	if err != nil {
+		return nil, nil, err
+	} else if entityRes == nil || entityRes.IsExpired() || !entityRes.IsIssuedTo(clientID) {
+		return nil, nil, ErrRefreshTokenInvalid
+	}
+
+	// A revoked token being presented again means it was leaked, so every session of the user is signed out.
+	if entityRes.IsRevoked() {
+		if err := models.WithTx(ctrl.DB, func(tx *sqlx.Tx) error {
+			return ctrl.endSessions(tx, entityRes.UserID, nil)
+		}); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrRefreshTokenInvalid
+	}
+
+	if affected, err := refreshTokensEngine.Revoke(entityRes.ID); err != nil {
+		return nil, nil, err
+	} else if affected == 0 {
+		return nil, nil, ErrRefreshTokenInvalid
+	}
+
+	if userRes, err := models.NewUsersTableEngine(ctrl.DB).GetByID(entityRes.UserID.String()); err != nil {
+		return nil, nil, err
+	} else if userRes == nil || userRes.Status != USER_STATUS_ENABLED {
+		return nil, nil, ErrAccountNotEnabled
+	} else {
+		return entityRes, userRes, nil
+	}
+}
+
+type logoutParams struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+func (ctrl *Auth) Logout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var params logoutParams
+		if err := c.ShouldBindJSON(&params); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+
+		if claims, err := middlewares.ParseAccessToken(ctrl.Config, middlewares.BearerToken(c)); err != nil && err != middlewares.ErrInvalidAccessToken {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if claims != nil {
+			if jti, err := uuid.Parse(claims.Id); err == nil {
+				if _, err := models.NewRevokedTokensTableEngine(ctrl.DB).Insert(&jti, time.Unix(claims.ExpiresAt, 0)); err != nil {
+					c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
 					return
 				}
 			}
 		}
+
+		refreshTokensEngine := models.NewRefreshTokensTableEngine(ctrl.DB)
+
+		if entityRes, err := refreshTokensEngine.GetByTokenHash(misc.HashToken(params.RefreshToken)); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if entityRes != nil && entityRes.SessionID != nil {
+			if _, err := models.NewSessionsTableEngine(ctrl.DB).Delete(entityRes.SessionID); err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+				return
+			}
+		} else if entityRes != nil {
+			if _, err := refreshTokensEngine.Revoke(entityRes.ID); err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+				return
+			}
+		}
+
+		c.AbortWithStatusJSON(http.StatusNoContent, gin.H{"message": http.StatusText(http.StatusNoContent)})
+		return
+	}
+}
+
+// issueTokens signs a short-lived access token and persists a new refresh token for the user. A login starts a
+// session on the device of the request, a refresh passes the sessionID of its refresh token along. The access token
+// of an identity listed in ADMIN_IDENTITIES carries the admin scope. Every way to tokens goes through here or
+// issueClientTokens, so a user whose password has to be reset gets none, whatever the login method. The session and
+// its refresh token are written in one transaction, a failure leaves neither a session without a token behind nor a
+// token of a session that was never touched.
+func (ctrl *Auth) issueTokens(c *gin.Context, user *models.EntityUser, sessionID *uuid.UUID) (*tokenResp, error) {
+	if user.PasswordResetRequired {
+		return nil, ErrPasswordResetRequired
+	}
+
+	scope := ""
+	if ctrl.Config.IsAdminIdentity(user.Identity) {
+		scope = misc.SCOPE_ADMIN
+	}
+
+	var resp *tokenResp
+	sessionsEngine := models.NewSessionsTableEngine(ctrl.DB)
+	if err := models.WithTx(ctrl.DB, func(tx *sqlx.Tx) (err error) {
+		if sessionID == nil {
+			userAgent := c.GetHeader("User-Agent")
+			if sessionRes, err := sessionsEngine.Insert(tx, user.ID, userAgent, c.ClientIP(), misc.DeviceLabel(userAgent)); err != nil {
+				return err
+			} else {
+				sessionID = sessionRes.ID
+			}
+		} else if _, err := sessionsEngine.Touch(tx, sessionID); err != nil {
+			return err
+		}
+
+		resp, err = ctrl.issueTokenPair(tx, user.ID, nil, sessionID, scope)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// issueClientTokens is issueTokens for an OAuth client, the tokens are bound to the client and the granted scope.
+func (ctrl *Auth) issueClientTokens(user *models.EntityUser, clientID *uuid.UUID, scope string) (*tokenResp, error) {
+	if user.PasswordResetRequired {
+		return nil, ErrPasswordResetRequired
+	}
+
+	var resp *tokenResp
+	if err := models.WithTx(ctrl.DB, func(tx *sqlx.Tx) (err error) {
+		resp, err = ctrl.issueTokenPair(tx, user.ID, clientID, nil, scope)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (ctrl *Auth) issueTokenPair(tx *sqlx.Tx, userID *uuid.UUID, clientID *uuid.UUID, sessionID *uuid.UUID, scope string) (*tokenResp, error) {
+	nowTime := time.Now()
+	accessExpireSecs := ctrl.Config.GetAccessTokenExpireSecs()
+
+	claims := misc.AccessJwtClaims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.New().String(),
+			Subject:   userID.String(),
+			ExpiresAt: nowTime.Add(time.Duration(accessExpireSecs) * time.Second).Unix(),
+			IssuedAt:  nowTime.Unix(),
+		},
+		Type:  JWT_TYPE_ACCESS,
+		Scope: scope,
+	}
+	if clientID != nil {
+		claims.ClientID = clientID.String()
 	}
+	if sessionID != nil {
+		claims.SessionID = sessionID.String()
+	}
+
+	miscJWT := misc.NewJWT(ctrl.Config.GetJWTKeyset())
+	accessToken, err := miscJWT.GenToken(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := misc.GenOpaqueToken(REFRESH_TOKEN_BYTES)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshExpiresAt := nowTime.Add(time.Duration(ctrl.Config.GetRefreshTokenExpireSecs()) * time.Second)
+	if _, err := models.NewRefreshTokensTableEngine(ctrl.DB).Insert(tx, userID, clientID, sessionID, scope, misc.HashToken(refreshToken), refreshExpiresAt); err != nil {
+		return nil, err
+	}
+
+	return &tokenResp{
+		AccessToken:  accessToken,
+		TokenType:    TOKEN_TYPE_BEARER,
+		ExpiresIn:    accessExpireSecs,
+		RefreshToken: refreshToken,
+	}, nil
 }
 
 // ================================================================
 // SignUp
 // ================================================================
 type signUpEmailConfirmParams struct {
-	Email         string `json:"email" binding:"required,email,min=1,max=128"`
+	Email         string `json:"email" binding:"required,email,min=1,max=127"`
 	VerifyPageUrl string `json:"verifyPageURL" binding:"required,url"`
 	Continue      string `json:"continue" binding:"omitempty,url"`
+	Locale        string `json:"locale" binding:"omitempty,max=35"`
 }
 
 type signUpEmailConfirmResp struct {
@@ -110,66 +471,36 @@ func (ctrl *Auth) SignUpEmailConfirm() gin.HandlerFunc {
 		} else if uri, err = url.ParseRequestURI(params.VerifyPageUrl); err != nil {
 			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
 			return
+		} else if err := ctrl.checkEmailLinkURLs(params.VerifyPageUrl, params.Continue); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
 		}
 
 		if entityRes, err := models.NewUsersTableEngine(ctrl.DB).GetByIdentity(params.Email); err != nil {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": http.StatusText(http.StatusInternalServerError), "results": err.Error()})
 			return
 		} else if entityRes != nil {
+			if ctrl.Config.GetPrivacyMode() {
+				ctrl.sendNoticeEmail(c, params.Email, misc.EMAIL_TYPE_ACCOUNT_EXISTS, JWT_TYPE_SIGN_UP, ctrl.emailLocale(c, params.Locale, entityRes), entityRes)
+				return
+			}
 			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "This Email is already exist."})
 			return
 		}
 
-		nowTime := time.Now()
-		expiresAt := nowTime.Add(EMAIL_CONFIRMATION_EXPIRE_MINS * time.Minute).Unix()
-		issuedAt := nowTime.Unix()
+		// The locale travels in the token and is stored on the user at sign up.
+		locale := ctrl.emailLocale(c, params.Locale, nil)
 
-		miscJWT := misc.NewJWT(ctrl.Config.GetJWTSecret())
-		tokenString, err := miscJWT.GenToken(jwt.SigningMethodHS512, misc.EmailJwtClaims{
-			StandardClaims: jwt.StandardClaims{
-				Subject:   params.Email,
-				ExpiresAt: expiresAt,
-				IssuedAt:  issuedAt,
-			},
-			Email:    params.Email,
-			Type:     JWT_TYPE_SIGN_UP,
-			Continue: params.Continue,
-		})
-		if err != nil {
+		if err := models.WithTx(ctrl.DB, func(tx *sqlx.Tx) error {
+			tokenString, err := ctrl.genEmailToken(tx, params.Email, JWT_TYPE_SIGN_UP, params.Continue, locale)
+			if err != nil {
+				return err
+			}
+			return ctrl.sendLinkEmail(tx, params.Email, JWT_TYPE_SIGN_UP, locale, nil, uri, tokenString)
+		}); err != nil {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
 			return
 		}
-		vals := uri.Query()
-		vals.Add("token", tokenString)
-		realVerifyPageURI := uri.Scheme + "://" + uri.Host + uri.Path + "?" + vals.Encode()
-
-		tmpl, _ := template.New("email").Parse(getEmailTplHTML())
-		var tpl bytes.Buffer
-
-		tmpl.Execute(&tpl, struct {
-			Content           string
-			RealVerifyPageURI string
-			LinkText          string
-		}{
-			ctrl.Config.GetSignupEmailContent(),
-			realVerifyPageURI,
-			ctrl.Config.GetSignupEmailLinkText(),
-		})
-
-		// TODO Supports multi languages.
-		email := misc.NewEmail(
-			ctrl.Config.GetSMTPHost(),
-			ctrl.Config.GetSMTPPort(),
-			ctrl.Config.GetSMTPUsername(),
-			ctrl.Config.GetSMTPPassword(),
-		)
-		email.SendHTML(
-			ctrl.Config.GetSMTPSenderName(),
-			ctrl.Config.GetSMTPSender(),
-			[]string{params.Email},
-			ctrl.Config.GetSignupEmailSubject(),
-			tpl.String(),
-		)
 
 		c.AbortWithStatusJSON(http.StatusAccepted, gin.H{"message": http.StatusText(http.StatusAccepted)})
 		return
@@ -195,7 +526,7 @@ func (ctrl *Auth) SignUpTokenVerify() gin.HandlerFunc {
 		}
 
 		var claims misc.EmailJwtClaims
-		miscJWT := misc.NewJWT(ctrl.Config.GetJWTSecret())
+		miscJWT := misc.NewJWT(ctrl.Config.GetJWTKeyset())
 		token, err := miscJWT.Parse(params.Token, &claims)
 
 		if err != nil {
@@ -207,6 +538,20 @@ func (ctrl *Auth) SignUpTokenVerify() gin.HandlerFunc {
 			return
 		}
 
+		if isPending, err := models.NewEmailTokensTableEngine(ctrl.DB).IsPending(claims.Id, JWT_TYPE_SIGN_UP); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if !isPending {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": http.StatusText(http.StatusUnauthorized)})
+			return
+		}
+
+		// The allowlist may have changed since the token was issued.
+		if claims.Continue != "" && !ctrl.Config.GetURLAllowlist().Allows(claims.Continue) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": ErrContinueURLNotAllowed.Error()})
+			return
+		}
+
 		c.AbortWithStatusJSON(http.StatusOK, signUpTokenVerifyResp{
 			Email:    claims.Email,
 			Continue: claims.Continue,
@@ -217,7 +562,7 @@ func (ctrl *Auth) SignUpTokenVerify() gin.HandlerFunc {
 
 type signupParams struct {
 	Token    string `json:"token" binding:"required"`
-	Password string `json:"password" binding:"required,min=5,max=128"`
+	Password string `json:"password" binding:"required,max=128"`
 }
 
 func (ctrl *Auth) SignUp() gin.HandlerFunc {
@@ -230,7 +575,7 @@ func (ctrl *Auth) SignUp() gin.HandlerFunc {
 		}
 
 		var claims misc.EmailJwtClaims
-		miscJWT := misc.NewJWT(ctrl.Config.GetJWTSecret())
+		miscJWT := misc.NewJWT(ctrl.Config.GetJWTKeyset())
 		token, err := miscJWT.Parse(params.Token, &claims)
 
 		if err != nil {
@@ -242,9 +587,26 @@ func (ctrl *Auth) SignUp() gin.HandlerFunc {
 			return
 		}
 
-		// TODO Enhanced password requirements.
-		if entityRes, err := models.NewUsersTableEngine(ctrl.DB).Insert(claims.Email, params.Password, USER_STATUS_ENABLED); err != nil {
-			if myErr, ok := err.(*mysql.MySQLError); ok && myErr.Number == 1062 {
+		if violations := ctrl.Config.GetPasswordPolicy().Validate(params.Password, claims.Email); len(violations) > 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Password does not meet the requirements.", "violations": violations})
+			return
+		}
+
+		var entityRes *models.EntityUser
+		if err := models.WithTx(ctrl.DB, func(tx *sqlx.Tx) (err error) {
+			if consumed, err := models.NewEmailTokensTableEngine(ctrl.DB).Consume(tx, claims.Id, JWT_TYPE_SIGN_UP); err != nil {
+				return err
+			} else if !consumed {
+				return ErrEmailTokenInvalid
+			}
+
+			entityRes, err = models.NewUsersTableEngine(ctrl.DB).Insert(tx, ctrl.Config.GetPasswordHasher(), claims.Email, params.Password, USER_STATUS_ENABLED, claims.Locale)
+			return err
+		}); err != nil {
+			if err == ErrEmailTokenInvalid {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": http.StatusText(http.StatusUnauthorized)})
+				return
+			} else if myErr, ok := err.(*mysql.MySQLError); ok && myErr.Number == 1062 {
 				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": http.StatusText(http.StatusConflict)})
 				return
 			} else {
@@ -267,9 +629,10 @@ func (ctrl *Auth) SignUp() gin.HandlerFunc {
 // ForgetPassword
 // ================================================================
 type forgetPwdConfirmParams struct {
-	Email         string `json:"email" binding:"required,email,min=1,max=128"`
+	Email         string `json:"email" binding:"required,email,min=1,max=127"`
 	VerifyPageUrl string `json:"verifyPageURL" binding:"required,url"`
 	Continue      string `json:"continue" binding:"omitempty,url"`
+	Locale        string `json:"locale" binding:"omitempty,max=35"`
 }
 
 type forgetPwdConfirmResp struct {
@@ -281,6 +644,8 @@ func (ctrl *Auth) ForgetPwdConfirm() gin.HandlerFunc {
 		var (
 			params forgetPwdConfirmParams
 			uri    *url.URL
+			user   *models.EntityUser
+			locale string
 		)
 
 		if err := c.ShouldBindJSON(&params); err != nil {
@@ -289,66 +654,36 @@ func (ctrl *Auth) ForgetPwdConfirm() gin.HandlerFunc {
 		} else if uri, err = url.ParseRequestURI(params.VerifyPageUrl); err != nil {
 			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
 			return
+		} else if err := ctrl.checkEmailLinkURLs(params.VerifyPageUrl, params.Continue); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
 		}
 
 		if entityRes, err := models.NewUsersTableEngine(ctrl.DB).GetByIdentity(params.Email); err != nil {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": http.StatusText(http.StatusInternalServerError), "results": err.Error()})
 			return
 		} else if entityRes == nil {
+			if ctrl.Config.GetPrivacyMode() {
+				ctrl.sendNoticeEmail(c, params.Email, misc.EMAIL_TYPE_NO_ACCOUNT, JWT_TYPE_FORGET_PWD, ctrl.emailLocale(c, params.Locale, nil), nil)
+				return
+			}
 			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "This Email is not already exist."})
 			return
+		} else {
+			user = entityRes
+			locale = ctrl.emailLocale(c, params.Locale, entityRes)
 		}
 
-		nowTime := time.Now()
-		expiresAt := nowTime.Add(EMAIL_CONFIRMATION_EXPIRE_MINS * time.Minute).Unix()
-		issuedAt := nowTime.Unix()
-
-		miscJWT := misc.NewJWT(ctrl.Config.GetJWTSecret())
-		tokenString, err := miscJWT.GenToken(jwt.SigningMethodHS512, misc.EmailJwtClaims{
-			StandardClaims: jwt.StandardClaims{
-				Subject:   params.Email,
-				ExpiresAt: expiresAt,
-				IssuedAt:  issuedAt,
-			},
-			Email:    params.Email,
-			Type:     JWT_TYPE_FORGET_PWD,
-			Continue: params.Continue,
-		})
-		if err != nil {
+		if err := models.WithTx(ctrl.DB, func(tx *sqlx.Tx) error {
+			tokenString, err := ctrl.genEmailToken(tx, params.Email, JWT_TYPE_FORGET_PWD, params.Continue, askedLocale(params.Locale, locale))
+			if err != nil {
+				return err
+			}
+			return ctrl.sendLinkEmail(tx, params.Email, JWT_TYPE_FORGET_PWD, locale, user, uri, tokenString)
+		}); err != nil {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
 			return
 		}
-		vals := uri.Query()
-		vals.Add("token", tokenString)
-		realVerifyPageURI := uri.Scheme + "://" + uri.Host + uri.Path + "?" + vals.Encode()
-
-		tmpl, _ := template.New("email").Parse(getEmailTplHTML())
-		var tpl bytes.Buffer
-
-		tmpl.Execute(&tpl, struct {
-			Content           string
-			RealVerifyPageURI string
-			LinkText          string
-		}{
-			ctrl.Config.GetForgetPwdEmailContent(),
-			realVerifyPageURI,
-			ctrl.Config.GetForgetPwdEmailLinkText(),
-		})
-
-		// TODO Supports multi languages.
-		email := misc.NewEmail(
-			ctrl.Config.GetSMTPHost(),
-			ctrl.Config.GetSMTPPort(),
-			ctrl.Config.GetSMTPUsername(),
-			ctrl.Config.GetSMTPPassword(),
-		)
-		email.SendHTML(
-			ctrl.Config.GetSMTPSenderName(),
-			ctrl.Config.GetSMTPSender(),
-			[]string{params.Email},
-			ctrl.Config.GetForgetPwdEmailSubject(),
-			tpl.String(),
-		)
 
 		c.AbortWithStatusJSON(http.StatusAccepted, gin.H{"message": http.StatusText(http.StatusAccepted)})
 		return
@@ -374,7 +709,7 @@ func (ctrl *Auth) ForgetPwdTokenVerify() gin.HandlerFunc {
 		}
 
 		var claims misc.EmailJwtClaims
-		miscJWT := misc.NewJWT(ctrl.Config.GetJWTSecret())
+		miscJWT := misc.NewJWT(ctrl.Config.GetJWTKeyset())
 		token, err := miscJWT.Parse(params.Token, &claims)
 
 		if err != nil {
@@ -386,6 +721,20 @@ func (ctrl *Auth) ForgetPwdTokenVerify() gin.HandlerFunc {
 			return
 		}
 
+		if isPending, err := models.NewEmailTokensTableEngine(ctrl.DB).IsPending(claims.Id, JWT_TYPE_FORGET_PWD); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if !isPending {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": http.StatusText(http.StatusUnauthorized)})
+			return
+		}
+
+		// The allowlist may have changed since the token was issued.
+		if claims.Continue != "" && !ctrl.Config.GetURLAllowlist().Allows(claims.Continue) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": ErrContinueURLNotAllowed.Error()})
+			return
+		}
+
 		c.AbortWithStatusJSON(http.StatusOK, forgetPwdTokenVerifyResp{
 			Email:    claims.Email,
 			Continue: claims.Continue,
@@ -396,7 +745,7 @@ func (ctrl *Auth) ForgetPwdTokenVerify() gin.HandlerFunc {
 
 type forgetPwdParams struct {
 	Token    string `json:"token" binding:"required"`
-	Password string `json:"password" binding:"required,min=5,max=128"`
+	Password string `json:"password" binding:"required,max=128"`
 }
 
 func (ctrl *Auth) ChangePassword() gin.HandlerFunc {
@@ -409,7 +758,7 @@ func (ctrl *Auth) ChangePassword() gin.HandlerFunc {
 		}
 
 		var claims misc.EmailJwtClaims
-		miscJWT := misc.NewJWT(ctrl.Config.GetJWTSecret())
+		miscJWT := misc.NewJWT(ctrl.Config.GetJWTKeyset())
 		token, err := miscJWT.Parse(params.Token, &claims)
 
 		if err != nil {
@@ -421,6 +770,11 @@ func (ctrl *Auth) ChangePassword() gin.HandlerFunc {
 			return
 		}
 
+		if violations := ctrl.Config.GetPasswordPolicy().Validate(params.Password, claims.Email); len(violations) > 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Password does not meet the requirements.", "violations": violations})
+			return
+		}
+
 		usersEngine := models.NewUsersTableEngine(ctrl.DB)
 
 		if entityRes, err := usersEngine.GetByIdentity(claims.Email); err != nil {
@@ -431,18 +785,51 @@ func (ctrl *Auth) ChangePassword() gin.HandlerFunc {
 				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
 				return
 			} else {
-				// TODO next version about password log
-				saltedPwd := append([]byte(params.Password), entityRes.Salt...)
-				compareErr := bcrypt.CompareHashAndPassword(entityRes.Password, saltedPwd)
-				if compareErr == nil {
+				if matched, err := entityRes.VerifyPassword(params.Password); err != nil {
+					c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+					return
+				} else if matched {
 					c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": http.StatusText(http.StatusConflict)})
 					return
 				}
 
-				if _, err := usersEngine.ResetPwd(entityRes.ID, params.Password, entityRes.Salt); err != nil {
+				if historySize := ctrl.Config.GetPasswordHistorySize(); historySize > 0 {
+					if histories, err := models.NewPasswordHistoryTableEngine(ctrl.DB).ListRecentByUserID(entityRes.ID, historySize); err != nil {
+						c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+						return
+					} else {
+						for _, h := range histories {
+							if matched, err := h.VerifyPassword(params.Password); err != nil {
+								c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+								return
+							} else if matched {
+								c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "This password has been used recently."})
+								return
+							}
+						}
+					}
+				}
+
+				if err := models.WithTx(ctrl.DB, func(tx *sqlx.Tx) error {
+					if consumed, err := models.NewEmailTokensTableEngine(ctrl.DB).Consume(tx, claims.Id, JWT_TYPE_FORGET_PWD); err != nil {
+						return err
+					} else if !consumed {
+						return ErrEmailTokenInvalid
+					}
+
+					if _, err := usersEngine.ResetPwd(tx, ctrl.Config.GetPasswordHasher(), entityRes.ID, params.Password, entityRes.Salt, ctrl.Config.GetPasswordHistorySize()); err != nil {
+						return err
+					}
+
+					return ctrl.endSessions(tx, entityRes.ID, nil)
+				}); err == ErrEmailTokenInvalid {
+					c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": http.StatusText(http.StatusUnauthorized)})
+					return
+				} else if err != nil {
 					c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
 					return
 				} else {
+					ctrl.rememberLocale(c, entityRes, claims.Locale)
 					c.AbortWithStatusJSON(http.StatusNoContent, gin.H{"message": http.StatusText(http.StatusNoContent)})
 					return
 				}
@@ -452,15 +839,174 @@ func (ctrl *Auth) ChangePassword() gin.HandlerFunc {
 	}
 }
 
-func getEmailTplHTML() string {
-	return `
-	<!DOCTYPE html>
-		<html>
-			<body>
-				<div>
-					<p>{{ .Content }}</p>
-					<a href={{ .RealVerifyPageURI }}>{{ .LinkText }}</a>
-				</div>
-			</body>
-		</html>`
+// ================================================================
+// Magic Link
+// ================================================================
+type magicLinkConfirmParams struct {
+	Email         string `json:"email" binding:"required,email,min=1,max=127"`
+	VerifyPageUrl string `json:"verifyPageURL" binding:"required,url"`
+	Continue      string `json:"continue" binding:"omitempty,url"`
+	Locale        string `json:"locale" binding:"omitempty,max=35"`
+}
+
+func (ctrl *Auth) MagicLinkConfirm() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var (
+			params magicLinkConfirmParams
+			uri    *url.URL
+			user   *models.EntityUser
+			locale string
+		)
+
+		if err := c.ShouldBindJSON(&params); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		} else if uri, err = url.ParseRequestURI(params.VerifyPageUrl); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		} else if err := ctrl.checkEmailLinkURLs(params.VerifyPageUrl, params.Continue); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+
+		if entityRes, err := models.NewUsersTableEngine(ctrl.DB).GetByIdentity(params.Email); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": http.StatusText(http.StatusInternalServerError), "results": err.Error()})
+			return
+		} else if entityRes == nil {
+			if ctrl.Config.GetPrivacyMode() {
+				ctrl.sendNoticeEmail(c, params.Email, misc.EMAIL_TYPE_NO_ACCOUNT, JWT_TYPE_MAGIC_LOGIN, ctrl.emailLocale(c, params.Locale, nil), nil)
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "This Email is not already exist."})
+			return
+		} else if entityRes.Status != USER_STATUS_ENABLED {
+			if ctrl.Config.GetPrivacyMode() {
+				ctrl.sendNoticeEmail(c, params.Email, misc.EMAIL_TYPE_ACCOUNT_DISABLED, JWT_TYPE_MAGIC_LOGIN, ctrl.emailLocale(c, params.Locale, entityRes), entityRes)
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "This account is not enabled."})
+			return
+		} else {
+			user = entityRes
+			locale = ctrl.emailLocale(c, params.Locale, entityRes)
+		}
+
+		if err := models.WithTx(ctrl.DB, func(tx *sqlx.Tx) error {
+			tokenString, err := ctrl.genEmailToken(tx, params.Email, JWT_TYPE_MAGIC_LOGIN, params.Continue, askedLocale(params.Locale, locale))
+			if err != nil {
+				return err
+			}
+			return ctrl.sendLinkEmail(tx, params.Email, JWT_TYPE_MAGIC_LOGIN, locale, user, uri, tokenString)
+		}); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusAccepted, gin.H{"message": http.StatusText(http.StatusAccepted)})
+		return
+	}
+}
+
+type magicLinkLoginParams struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// MagicLinkLogin exchanges the emailed token for the same result as Login, including the MFA challenge.
+func (ctrl *Auth) MagicLinkLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var params magicLinkLoginParams
+		if err := c.ShouldBindJSON(&params); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+
+		var claims misc.EmailJwtClaims
+		miscJWT := misc.NewJWT(ctrl.Config.GetJWTKeyset())
+		if token, err := miscJWT.Parse(params.Token, &claims); err != nil || !token.Valid || claims.Type != JWT_TYPE_MAGIC_LOGIN {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": http.StatusText(http.StatusUnauthorized)})
+			return
+		}
+
+		var consumed bool
+		if err := models.WithTx(ctrl.DB, func(tx *sqlx.Tx) (err error) {
+			consumed, err = models.NewEmailTokensTableEngine(ctrl.DB).Consume(tx, claims.Id, JWT_TYPE_MAGIC_LOGIN)
+			return err
+		}); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if !consumed {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": http.StatusText(http.StatusUnauthorized)})
+			return
+		}
+
+		if entityRes, err := models.NewUsersTableEngine(ctrl.DB).GetByIdentity(claims.Email); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if entityRes == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": http.StatusText(http.StatusUnauthorized)})
+			return
+		} else if entityRes.Status != USER_STATUS_ENABLED {
+			ctrl.respondNotEnabled(c, entityRes, http.StatusText(http.StatusUnauthorized))
+			return
+		} else {
+			ctrl.rememberLocale(c, entityRes, claims.Locale)
+			ctrl.completeLogin(c, entityRes)
+			return
+		}
+	}
+}
+
+// checkEmailLinkURLs keeps emailed links and the redirect after them on the hosts of ALLOWED_URLS.
+func (ctrl *Auth) checkEmailLinkURLs(verifyPageURL, continueURL string) error {
+	allowlist := ctrl.Config.GetURLAllowlist()
+
+	if !allowlist.Allows(verifyPageURL) {
+		return ErrVerifyPageURLNotAllowed
+	}
+
+	if continueURL != "" && !allowlist.Allows(continueURL) {
+		return ErrContinueURLNotAllowed
+	}
+
+	return nil
+}
+
+// askedLocale is the locale a token carries to the confirmed action, only a locale sent explicitly is stored on the
+// user then.
+func askedLocale(param, locale string) string {
+	if param == "" {
+		return ""
+	}
+	return locale
+}
+
+// genEmailToken signs a single-use email token, any token of the same type still outstanding for the email
+// is revoked so only the latest link works. tx also queues the email carrying the token.
+func (ctrl *Auth) genEmailToken(tx *sqlx.Tx, email, typ, continueURL, locale string) (string, error) {
+	nowTime := time.Now()
+	expiresAt := nowTime.Add(EMAIL_CONFIRMATION_EXPIRE_MINS * time.Minute)
+
+	emailTokensEngine := models.NewEmailTokensTableEngine(ctrl.DB)
+	if _, err := emailTokensEngine.RevokePending(tx, email, typ); err != nil {
+		return "", err
+	}
+
+	entityRes, err := emailTokensEngine.Insert(tx, email, typ, expiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	miscJWT := misc.NewJWT(ctrl.Config.GetJWTKeyset())
+	return miscJWT.GenToken(misc.EmailJwtClaims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        entityRes.ID.String(),
+			Subject:   email,
+			ExpiresAt: expiresAt.Unix(),
+			IssuedAt:  nowTime.Unix(),
+		},
+		Email:    email,
+		Type:     typ,
+		Continue: continueURL,
+		Locale:   locale,
+	})
 }
@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hexcraft-biz/base-accounts-service/misc"
+	"github.com/hexcraft-biz/base-accounts-service/models"
+	"github.com/jmoiron/sqlx"
+)
+
+// emailLocale picks the locale of an email: the locale of the request, the one stored on the user, then
+// Accept-Language. The localizer falls back to the default locale when none of them is supported.
+func (ctrl *Auth) emailLocale(c *gin.Context, param string, user *models.EntityUser) string {
+	preferences := []string{param}
+	if user != nil && user.Locale != nil {
+		preferences = append(preferences, *user.Locale)
+	}
+	if c != nil {
+		preferences = append(preferences, c.GetHeader("Accept-Language"))
+	}
+
+	return ctrl.Config.GetLocalizer().Resolve(preferences...)
+}
+
+// rememberLocale stores the locale a user asked for explicitly once the emailed link is used, so later emails
+// follow it. An empty locale was not asked for.
+func (ctrl *Auth) rememberLocale(c *gin.Context, user *models.EntityUser, locale string) {
+	if locale == "" || (user.Locale != nil && *user.Locale == locale) {
+		return
+	}
+
+	if _, err := models.NewUsersTableEngine(ctrl.DB).UpdateLocale(user.ID, locale); err != nil {
+		c.Error(err)
+	}
+}
+
+// sendLinkEmail mails the token appended to the page uri, with the texts of the email type in the locale. user is
+// nil when the address has no account yet. tx is the one genEmailToken issued the token in.
+func (ctrl *Auth) sendLinkEmail(tx *sqlx.Tx, to, typ, locale string, user *models.EntityUser, uri *url.URL, tokenString string) error {
+	vals := uri.Query()
+	vals.Add("token", tokenString)
+	realVerifyPageURI := uri.Scheme + "://" + uri.Host + uri.Path + "?" + vals.Encode()
+
+	data := ctrl.emailTemplateData(to, typ, locale, user)
+	data.Link = realVerifyPageURI
+	data.ExpiresAt = time.Now().Add(EMAIL_CONFIRMATION_EXPIRE_MINS * time.Minute)
+	data.ExpireMins = EMAIL_CONFIRMATION_EXPIRE_MINS
+
+	return ctrl.sendEmail(tx, typ, data)
+}
+
+// sendNoticeEmail answers a confirmation request in privacy mode when no link can be sent. A token of linkType is
+// issued and dropped like for a sent link, so both answers do the same database work. The client gets the same 202
+// as a sent link, the owner of the address learns the real outcome from the email.
+func (ctrl *Auth) sendNoticeEmail(c *gin.Context, to, typ, linkType, locale string, user *models.EntityUser) {
+	if err := models.WithTx(ctrl.DB, func(tx *sqlx.Tx) error {
+		if _, err := ctrl.genEmailToken(tx, to, linkType, "", locale); err != nil {
+			return err
+		}
+		return ctrl.sendEmail(tx, typ, ctrl.emailTemplateData(to, typ, locale, user))
+	}); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.AbortWithStatusJSON(http.StatusAccepted, gin.H{"message": http.StatusText(http.StatusAccepted)})
+}
+
+// respondNotEnabled answers a first factor that passed for a user who is not enabled. Privacy mode answers like a
+// failed attempt with message, and emails the reason to the owner of the account instead.
+func (ctrl *Auth) respondNotEnabled(c *gin.Context, user *models.EntityUser, message string) {
+	if !ctrl.Config.GetPrivacyMode() {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": ErrAccountNotEnabled.Error()})
+		return
+	}
+
+	locale := ctrl.emailLocale(c, "", user)
+	if err := models.WithTx(ctrl.DB, func(tx *sqlx.Tx) error {
+		return ctrl.sendEmail(tx, misc.EMAIL_TYPE_ACCOUNT_DISABLED, ctrl.emailTemplateData(user.Identity, misc.EMAIL_TYPE_ACCOUNT_DISABLED, locale, user))
+	}); err != nil {
+		c.Error(err)
+	}
+
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": message})
+}
+
+func (ctrl *Auth) emailTemplateData(to, typ, locale string, user *models.EntityUser) *misc.EmailTemplateData {
+	texts := ctrl.Config.GetLocalizer().Texts(locale, typ)
+
+	data := &misc.EmailTemplateData{
+		ProductName: ctrl.Config.GetProductName(),
+		Locale:      locale,
+		Email:       to,
+		Subject:     texts.Subject,
+		Content:     texts.Content,
+		LinkText:    texts.LinkText,
+	}
+
+	if user != nil {
+		data.User = &misc.EmailTemplateUser{
+			ID:       user.ID.String(),
+			Identity: user.Identity,
+			Status:   user.Status,
+		}
+	}
+
+	return data
+}
+
+// sendEmail renders the email and queues it in the outbox within tx, the delivery and its retries happen in the
+// background once tx is committed.
+func (ctrl *Auth) sendEmail(tx *sqlx.Tx, typ string, data *misc.EmailTemplateData) error {
+	html, text, err := ctrl.Config.GetEmailTemplates().Render(typ, data)
+	if err != nil {
+		return err
+	}
+
+	_, err = models.NewEmailOutboxTableEngine(ctrl.DB).Insert(tx, typ, &misc.EmailMessage{
+		FromName: ctrl.Config.GetSMTPSenderName(),
+		From:     ctrl.Config.GetSMTPSender(),
+		To:       []string{data.Email},
+		Subject:  data.Subject,
+		HTML:     html,
+		Text:     text,
+	})
+	return err
+}
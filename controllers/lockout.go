@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hexcraft-biz/base-accounts-service/misc"
+	"github.com/hexcraft-biz/base-accounts-service/models"
+	"github.com/jmoiron/sqlx"
+)
+
+// ================================================================
+// Unlock
+// ================================================================
+type unlockParams struct {
+	Token string `json:"token" binding:"required"`
+}
+
+func (ctrl *Auth) Unlock() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var params unlockParams
+		if err := c.ShouldBindJSON(&params); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+
+		var claims misc.EmailJwtClaims
+		miscJWT := misc.NewJWT(ctrl.Config.GetJWTKeyset())
+		if token, err := miscJWT.Parse(params.Token, &claims); err != nil || !token.Valid || claims.Type != JWT_TYPE_UNLOCK {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": http.StatusText(http.StatusUnauthorized)})
+			return
+		}
+
+		var consumed bool
+		if err := models.WithTx(ctrl.DB, func(tx *sqlx.Tx) (err error) {
+			if consumed, err = models.NewEmailTokensTableEngine(ctrl.DB).Consume(tx, claims.Id, JWT_TYPE_UNLOCK); err != nil || !consumed {
+				return err
+			}
+
+			_, err = models.NewLoginAttemptsTableEngine(ctrl.DB).Clear(tx, claims.Email)
+			return err
+		}); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if !consumed {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": http.StatusText(http.StatusUnauthorized)})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusNoContent, gin.H{"message": http.StatusText(http.StatusNoContent)})
+		return
+	}
+}
+
+// ================================================================
+// Helpers
+// ================================================================
+func respondLocked(c *gin.Context, attemptRes *models.EntityLoginAttempt) {
+	retryAfter := attemptRes.RetryAfterSecs()
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.AbortWithStatusJSON(http.StatusLocked, gin.H{"message": "This account is temporarily locked.", "retryAfter": retryAfter})
+}
+
+// sendUnlockEmail queues a single-use link to UNLOCK_PAGE_URL in the outbox, the page posts the token to
+// /auth/v1/unlock. The page comes from env rather than the request, so whoever triggered the lock can't redirect
+// the link. Nothing is sent when UNLOCK_PAGE_URL is unset.
+func (ctrl *Auth) sendUnlockEmail(user *models.EntityUser, locale string) error {
+	if ctrl.Config.GetUnlockPageURL() == "" {
+		return nil
+	}
+
+	uri, err := url.ParseRequestURI(ctrl.Config.GetUnlockPageURL())
+	if err != nil {
+		return err
+	}
+
+	return models.WithTx(ctrl.DB, func(tx *sqlx.Tx) error {
+		tokenString, err := ctrl.genEmailToken(tx, user.Identity, JWT_TYPE_UNLOCK, "", locale)
+		if err != nil {
+			return err
+		}
+		return ctrl.sendLinkEmail(tx, user.Identity, JWT_TYPE_UNLOCK, locale, user, uri, tokenString)
+	})
+}
@@ -0,0 +1,408 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"github.com/hexcraft-biz/base-accounts-service/middlewares"
+	"github.com/hexcraft-biz/base-accounts-service/misc"
+	"github.com/hexcraft-biz/base-accounts-service/models"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	MFA_STATUS_REQUIRED = "mfa_required"
+	MFA_METHOD_TOTP     = "totp"
+)
+
+type mfaChallengeResp struct {
+	Status   string   `json:"status"`
+	MfaToken string   `json:"mfaToken"`
+	Methods  []string `json:"methods"`
+}
+
+// ================================================================
+// TOTP Enrollment
+// ================================================================
+type totpEnrollResp struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauthURI"`
+}
+
+func (ctrl *Auth) TOTPEnroll() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := middlewares.GetUserID(c)
+
+		userRes, err := models.NewUsersTableEngine(ctrl.DB).GetByID(userID.String())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if userRes == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
+			return
+		}
+
+		totpEngine := models.NewUserTOTPTableEngine(ctrl.DB)
+
+		if totpRes, err := totpEngine.GetByUserID(userID); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if totpRes != nil && totpRes.IsEnabled() {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "TOTP is already enabled."})
+			return
+		}
+
+		secret, err := misc.GenTOTPSecret()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+
+		if _, err := totpEngine.InsertPending(userID, secret); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusCreated, totpEnrollResp{
+			Secret:     secret,
+			OtpauthURI: misc.TOTPURI(ctrl.Config.GetTOTPIssuer(), userRes.Identity, secret),
+		})
+		return
+	}
+}
+
+type totpConfirmParams struct {
+	Code string `json:"code" binding:"required,len=6,numeric"`
+}
+
+type totpConfirmResp struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+func (ctrl *Auth) TOTPConfirm() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var params totpConfirmParams
+		if err := c.ShouldBindJSON(&params); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+
+		userID := middlewares.GetUserID(c)
+		totpEngine := models.NewUserTOTPTableEngine(ctrl.DB)
+
+		totpRes, err := totpEngine.GetByUserID(userID)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if totpRes == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
+			return
+		} else if totpRes.IsEnabled() {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "TOTP is already enabled."})
+			return
+		}
+
+		valid, counter, err := misc.ValidateTOTP(totpRes.Secret, params.Code, time.Now())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if !valid {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "The code is wrong."})
+			return
+		}
+
+		if affected, err := totpEngine.Enable(totpRes.ID, counter); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if affected == 0 {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "TOTP is already enabled."})
+			return
+		}
+
+		codes, err := misc.GenRecoveryCodes()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+
+		codeHashes := make([][]byte, len(codes))
+		for i, code := range codes {
+			codeHashes[i] = misc.HashRecoveryCode(code)
+		}
+
+		if err := models.NewUserRecoveryCodesTableEngine(ctrl.DB).Replace(userID, codeHashes); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusOK, totpConfirmResp{RecoveryCodes: codes})
+		return
+	}
+}
+
+type totpDisableParams struct {
+	Code         string `json:"code" binding:"required_without=RecoveryCode,omitempty,len=6,numeric"`
+	RecoveryCode string `json:"recoveryCode" binding:"required_without=Code,omitempty,max=32"`
+}
+
+func (ctrl *Auth) TOTPDisable() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var params totpDisableParams
+		if err := c.ShouldBindJSON(&params); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+
+		userID := middlewares.GetUserID(c)
+
+		entityRes, err := models.NewUsersTableEngine(ctrl.DB).GetByID(userID.String())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if entityRes == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
+			return
+		}
+
+		// A stolen access token must not be enough to guess the code, wrong codes count towards the lockout.
+		if ctrl.abortIfLocked(c, entityRes) {
+			return
+		}
+
+		if verified, err := ctrl.verifySecondFactor(userID, params.Code, params.RecoveryCode); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if !verified {
+			ctrl.respondWrongCode(c, entityRes)
+			return
+		}
+
+		// The recovery codes go with the secret, they would otherwise keep passing verifySecondFactor.
+		if err := models.WithTx(ctrl.DB, func(tx *sqlx.Tx) error {
+			if _, err := models.NewUserTOTPTableEngine(ctrl.DB).DeleteByUserID(tx, userID); err != nil {
+				return err
+			}
+
+			_, err := models.NewUserRecoveryCodesTableEngine(ctrl.DB).DeleteByUserID(tx, userID)
+			return err
+		}); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+
+		ctrl.clearFailures(c, entityRes)
+
+		c.AbortWithStatusJSON(http.StatusNoContent, gin.H{"message": http.StatusText(http.StatusNoContent)})
+		return
+	}
+}
+
+// ================================================================
+// MFA Verify
+// ================================================================
+type mfaVerifyParams struct {
+	MfaToken     string `json:"mfaToken" binding:"required"`
+	Code         string `json:"code" binding:"required_without=RecoveryCode,omitempty,len=6,numeric"`
+	RecoveryCode string `json:"recoveryCode" binding:"required_without=Code,omitempty,max=32"`
+}
+
+func (ctrl *Auth) MfaVerify() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var params mfaVerifyParams
+		if err := c.ShouldBindJSON(&params); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+
+		userID, err := ctrl.parseMfaToken(params.MfaToken)
+		if err == ErrMfaTokenInvalid {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": http.StatusText(http.StatusUnauthorized)})
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+
+		entityRes, err := models.NewUsersTableEngine(ctrl.DB).GetByID(userID.String())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if entityRes == nil || entityRes.Status != USER_STATUS_ENABLED {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "This account is not enabled."})
+			return
+		}
+
+		// Wrong codes count towards the lockout of the identity, like wrong passwords. The lockout bounds the guesses,
+		// so a wrong code keeps the token and the user does not type the password again.
+		if ctrl.abortIfLocked(c, entityRes) {
+			return
+		}
+
+		if verified, err := ctrl.verifySecondFactor(userID, params.Code, params.RecoveryCode); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if !verified {
+			ctrl.respondWrongCode(c, entityRes)
+			return
+		}
+
+		// The token is used up once it got its tokens, a concurrent request with the same token gets none.
+		if claimed, err := ctrl.claimMfaToken(params.MfaToken); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if !claimed {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": http.StatusText(http.StatusUnauthorized)})
+			return
+		}
+
+		ctrl.clearFailures(c, entityRes)
+		ctrl.respondTokens(c, entityRes)
+		return
+	}
+}
+
+// ================================================================
+// Helpers
+// ================================================================
+func (ctrl *Auth) mfaMethods(userID *uuid.UUID) ([]string, error) {
+	methods := []string{}
+
+	if totpRes, err := models.NewUserTOTPTableEngine(ctrl.DB).GetByUserID(userID); err != nil {
+		return nil, err
+	} else if totpRes != nil && totpRes.IsEnabled() {
+		methods = append(methods, MFA_METHOD_TOTP)
+	}
+
+	if hasCredentials, err := models.NewWebAuthnCredentialsTableEngine(ctrl.DB).HasAny(userID); err != nil {
+		return nil, err
+	} else if hasCredentials {
+		methods = append(methods, MFA_METHOD_WEBAUTHN)
+	}
+
+	return methods, nil
+}
+
+// abortIfLocked answers 423 while the identity of the user is locked out.
+func (ctrl *Auth) abortIfLocked(c *gin.Context, user *models.EntityUser) bool {
+	if !ctrl.Config.GetLockoutPolicy().Enabled() {
+		return false
+	}
+
+	if attemptRes, err := models.NewLoginAttemptsTableEngine(ctrl.DB).GetByIdentity(user.Identity); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return true
+	} else if attemptRes != nil && attemptRes.IsLocked() {
+		respondLocked(c, attemptRes)
+		return true
+	}
+
+	return false
+}
+
+// respondWrongCode counts the wrong second factor towards the lockout, the failure that locks the identity is
+// answered with 423 and emails the unlock link.
+func (ctrl *Auth) respondWrongCode(c *gin.Context, user *models.EntityUser) {
+	if lockoutPolicy := ctrl.Config.GetLockoutPolicy(); lockoutPolicy.Enabled() {
+		if attemptRes, locked, err := models.NewLoginAttemptsTableEngine(ctrl.DB).RecordFailure(user.Identity, lockoutPolicy); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if locked {
+			if err := ctrl.sendUnlockEmail(user, ctrl.emailLocale(c, "", user)); err != nil {
+				c.Error(err)
+			}
+			respondLocked(c, attemptRes)
+			return
+		}
+	}
+
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "The code is wrong."})
+}
+
+func (ctrl *Auth) clearFailures(c *gin.Context, user *models.EntityUser) {
+	if ctrl.Config.GetLockoutPolicy().Enabled() {
+		if err := models.WithTx(ctrl.DB, func(tx *sqlx.Tx) error {
+			_, err := models.NewLoginAttemptsTableEngine(ctrl.DB).Clear(tx, user.Identity)
+			return err
+		}); err != nil {
+			c.Error(err)
+		}
+	}
+}
+
+// verifySecondFactor accepts either a TOTP code or an unused recovery code, both can only be used once.
+func (ctrl *Auth) verifySecondFactor(userID *uuid.UUID, code, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		return models.NewUserRecoveryCodesTableEngine(ctrl.DB).Consume(userID, misc.HashRecoveryCode(recoveryCode))
+	}
+
+	totpEngine := models.NewUserTOTPTableEngine(ctrl.DB)
+
+	totpRes, err := totpEngine.GetByUserID(userID)
+	if err != nil || totpRes == nil || !totpRes.IsEnabled() {
+		return false, err
+	}
+
+	valid, counter, err := misc.ValidateTOTP(totpRes.Secret, code, time.Now())
+	if err != nil || !valid {
+		return false, err
+	}
+
+	return totpEngine.UseCounter(totpRes.ID, counter)
+}
+
+func (ctrl *Auth) genMfaToken(userID *uuid.UUID) (string, error) {
+	nowTime := time.Now()
+
+	miscJWT := misc.NewJWT(ctrl.Config.GetJWTKeyset())
+	return miscJWT.GenToken(misc.MfaJwtClaims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.New().String(),
+			Subject:   userID.String(),
+			ExpiresAt: nowTime.Add(MFA_TOKEN_EXPIRE_MINS * time.Minute).Unix(),
+			IssuedAt:  nowTime.Unix(),
+		},
+		Type: JWT_TYPE_MFA,
+	})
+}
+
+// parseMfaToken returns the user of the token, ErrMfaTokenInvalid when the token is not valid or was already used.
+func (ctrl *Auth) parseMfaToken(tokenStr string) (*uuid.UUID, error) {
+	var claims misc.MfaJwtClaims
+	miscJWT := misc.NewJWT(ctrl.Config.GetJWTKeyset())
+	if token, err := miscJWT.Parse(tokenStr, &claims); err != nil || !token.Valid || claims.Type != JWT_TYPE_MFA {
+		return nil, ErrMfaTokenInvalid
+	}
+
+	if revoked, err := models.NewRevokedTokensTableEngine(ctrl.DB).IsRevoked(claims.Id); err != nil {
+		return nil, err
+	} else if revoked {
+		return nil, ErrMfaTokenInvalid
+	}
+
+	if userID, err := uuid.Parse(claims.Subject); err != nil {
+		return nil, ErrMfaTokenInvalid
+	} else {
+		return &userID, nil
+	}
+}
+
+// claimMfaToken records the jti of a valid MFA token as revoked, it returns false when the token was already used.
+// The INSERT IGNORE makes concurrent attempts with the same token race for a single row.
+func (ctrl *Auth) claimMfaToken(tokenStr string) (bool, error) {
+	var claims misc.MfaJwtClaims
+	miscJWT := misc.NewJWT(ctrl.Config.GetJWTKeyset())
+	if token, err := miscJWT.Parse(tokenStr, &claims); err != nil || !token.Valid || claims.Type != JWT_TYPE_MFA {
+		return false, nil
+	}
+
+	jti, err := uuid.Parse(claims.Id)
+	if err != nil {
+		return false, nil
+	}
+
+	affected, err := models.NewRevokedTokensTableEngine(ctrl.DB).Insert(&jti, time.Unix(claims.ExpiresAt, 0))
+	return affected == 1, err
+}
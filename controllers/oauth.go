@@ -0,0 +1,638 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"github.com/hexcraft-biz/base-accounts-service/config"
+	"github.com/hexcraft-biz/base-accounts-service/middlewares"
+	"github.com/hexcraft-biz/base-accounts-service/misc"
+	"github.com/hexcraft-biz/base-accounts-service/models"
+	"github.com/hexcraft-biz/controller"
+)
+
+const (
+	OAUTH_CODE_BYTES           = 32
+	OAUTH_CODE_EXPIRE_SECS     = 60
+	OAUTH_CODE_CHALLENGE_BYTES = 43
+)
+
+type OAuth struct {
+	*controller.Prototype
+	Config config.ConfigInterface
+}
+
+func NewOAuth(cfg config.ConfigInterface) *OAuth {
+	return &OAuth{
+		Prototype: controller.New("oauth", cfg.GetDB()),
+		Config:    cfg,
+	}
+}
+
+// oauthErr is an error of RFC 6749, it is sent back to the client either in the JSON body or in the query string
+// of its redirect_uri.
+type oauthErr struct {
+	Code        string
+	Description string
+}
+
+func oauthError(c *gin.Context, status int, code, description string) {
+	c.AbortWithStatusJSON(status, gin.H{"error": code, "error_description": description})
+}
+
+// ================================================================
+// Authorization
+// ================================================================
+type authorizeParams struct {
+	ResponseType        string `form:"response_type" json:"response_type"`
+	ClientID            string `form:"client_id" json:"client_id" binding:"required"`
+	RedirectURI         string `form:"redirect_uri" json:"redirect_uri" binding:"required"`
+	Scope               string `form:"scope" json:"scope"`
+	State               string `form:"state" json:"state" binding:"max=1024"`
+	Nonce               string `form:"nonce" json:"nonce" binding:"max=255"`
+	CodeChallenge       string `form:"code_challenge" json:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method" json:"code_challenge_method"`
+	Prompt              string `form:"prompt" json:"prompt"`
+}
+
+type issueCodeResp struct {
+	RedirectTo string `json:"redirectTo"`
+}
+
+// Authorize validates the authorization request and hands it over to the login page in the query string. The login
+// page logs the user in through /auth/v1 and posts the same parameters back to IssueCode.
+func (ctrl *OAuth) Authorize() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var params authorizeParams
+		if err := c.ShouldBindQuery(&params); err != nil {
+			oauthError(c, http.StatusBadRequest, misc.OAUTH_ERR_INVALID_REQUEST, err.Error())
+			return
+		}
+
+		_, oerr, ok := ctrl.checkAuthorizeRequest(c, &params)
+		if !ok {
+			return
+		}
+
+		// There is no session on this side, the login page is the only one knowing whether the user is logged in.
+		if oerr == nil && params.Prompt == "none" {
+			oerr = &oauthErr{Code: misc.OAUTH_ERR_LOGIN_REQUIRED, Description: "prompt=none is not supported."}
+		}
+
+		if oerr != nil {
+			c.Redirect(http.StatusFound, authorizeRedirect(params.RedirectURI, params.State, url.Values{
+				"error":             {oerr.Code},
+				"error_description": {oerr.Description},
+			}))
+			return
+		}
+
+		loginURI, err := url.Parse(ctrl.Config.GetOIDCConfig().LoginPageURL)
+		if err != nil {
+			oauthError(c, http.StatusInternalServerError, misc.OAUTH_ERR_SERVER_ERROR, err.Error())
+			return
+		}
+
+		vals := loginURI.Query()
+		for k, v := range c.Request.URL.Query() {
+			vals[k] = v
+		}
+		loginURI.RawQuery = vals.Encode()
+
+		c.Redirect(http.StatusFound, loginURI.String())
+		return
+	}
+}
+
+// IssueCode is called by the login page with the access token of the logged in user, it answers where to send the
+// browser: the redirect_uri of the client with the code, or with the error.
+func (ctrl *OAuth) IssueCode() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var params authorizeParams
+		if err := c.ShouldBind(&params); err != nil {
+			oauthError(c, http.StatusBadRequest, misc.OAUTH_ERR_INVALID_REQUEST, err.Error())
+			return
+		}
+
+		clientRes, oerr, ok := ctrl.checkAuthorizeRequest(c, &params)
+		if !ok {
+			return
+		} else if oerr != nil {
+			c.AbortWithStatusJSON(http.StatusOK, issueCodeResp{RedirectTo: authorizeRedirect(params.RedirectURI, params.State, url.Values{
+				"error":             {oerr.Code},
+				"error_description": {oerr.Description},
+			})})
+			return
+		}
+
+		userRes, err := models.NewUsersTableEngine(ctrl.DB).GetByID(middlewares.GetUserID(c).String())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if userRes == nil || userRes.Status != USER_STATUS_ENABLED {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "This account is not enabled."})
+			return
+		}
+
+		code, err := misc.GenOpaqueToken(OAUTH_CODE_BYTES)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+
+		expiresAt := time.Now().Add(OAUTH_CODE_EXPIRE_SECS * time.Second)
+		if _, err := models.NewOAuthCodesTableEngine(ctrl.DB).Insert(misc.HashToken(code), clientRes.ID, userRes.ID, params.RedirectURI, params.Scope, params.Nonce, params.CodeChallenge, expiresAt); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusOK, issueCodeResp{RedirectTo: authorizeRedirect(params.RedirectURI, params.State, url.Values{
+			"code": {code},
+		})})
+		return
+	}
+}
+
+// checkAuthorizeRequest answers the errors that must not be redirected, an unknown client or redirect_uri, and
+// returns ok false then. The other errors are returned to be sent to the redirect_uri.
+func (ctrl *OAuth) checkAuthorizeRequest(c *gin.Context, params *authorizeParams) (*models.EntityOAuthClient, *oauthErr, bool) {
+	clientRes, err := models.NewOAuthClientsTableEngine(ctrl.DB).GetByID(params.ClientID)
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, misc.OAUTH_ERR_SERVER_ERROR, err.Error())
+		return nil, nil, false
+	} else if clientRes == nil {
+		oauthError(c, http.StatusBadRequest, misc.OAUTH_ERR_INVALID_CLIENT, "Unknown client_id.")
+		return nil, nil, false
+	} else if !clientRes.HasRedirectURI(params.RedirectURI) {
+		oauthError(c, http.StatusBadRequest, misc.OAUTH_ERR_INVALID_REQUEST, "redirect_uri is not registered for the client.")
+		return nil, nil, false
+	}
+
+	if params.ResponseType != misc.OAUTH_RESPONSE_TYPE_CODE {
+		return clientRes, &oauthErr{Code: misc.OAUTH_ERR_UNSUPPORTED_RESPONSE_TYPE, Description: "response_type must be code."}, true
+	} else if !misc.HasScope(params.Scope, misc.SCOPE_OPENID) {
+		return clientRes, &oauthErr{Code: misc.OAUTH_ERR_INVALID_SCOPE, Description: "The openid scope is required."}, true
+	} else if !misc.ScopeIncludes(clientRes.Scopes, params.Scope) || !misc.ScopeIncludes(strings.Join(misc.OIDCScopes, " "), params.Scope) {
+		return clientRes, &oauthErr{Code: misc.OAUTH_ERR_INVALID_SCOPE, Description: "The scope is not allowed for the client."}, true
+	} else if params.CodeChallengeMethod != misc.OAUTH_CODE_CHALLENGE_S256 || len(params.CodeChallenge) != OAUTH_CODE_CHALLENGE_BYTES {
+		return clientRes, &oauthErr{Code: misc.OAUTH_ERR_INVALID_REQUEST, Description: "PKCE with the S256 code_challenge_method is required."}, true
+	}
+
+	return clientRes, nil, true
+}
+
+// authorizeRedirect appends the values and the state to the registered redirect_uri.
+func authorizeRedirect(redirectURI, state string, vals url.Values) string {
+	uri, _ := url.Parse(redirectURI)
+	query := uri.Query()
+	for k, v := range vals {
+		query[k] = v
+	}
+	if state != "" {
+		query.Set("state", state)
+	}
+	uri.RawQuery = query.Encode()
+
+	return uri.String()
+}
+
+// ================================================================
+// Token
+// ================================================================
+type oauthTokenParams struct {
+	GrantType    string `form:"grant_type" binding:"required"`
+	Code         string `form:"code"`
+	RedirectURI  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	Scope        string `form:"scope"`
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+}
+
+type oauthTokenResp struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// Token is the token endpoint of RFC 6749, it takes form posts and answers in its own error format.
+func (ctrl *OAuth) Token() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "no-store")
+		c.Header("Pragma", "no-cache")
+
+		var params oauthTokenParams
+		if err := c.ShouldBindWith(&params, binding.FormPost); err != nil {
+			oauthError(c, http.StatusBadRequest, misc.OAUTH_ERR_INVALID_REQUEST, err.Error())
+			return
+		}
+
+		clientRes := ctrl.authenticateClient(c, params.ClientID, params.ClientSecret)
+		if clientRes == nil {
+			return
+		}
+
+		// The grants of a user only exist with the OpenID Connect provider.
+		oidcEnabled := ctrl.Config.GetOIDCConfig() != nil
+
+		switch {
+		case params.GrantType == misc.OAUTH_GRANT_CLIENT_CREDENTIALS:
+			ctrl.issueClientCredentials(c, clientRes, &params)
+		case params.GrantType == misc.OAUTH_GRANT_AUTHORIZATION_CODE && oidcEnabled:
+			ctrl.exchangeCode(c, clientRes, &params)
+		case params.GrantType == misc.OAUTH_GRANT_REFRESH_TOKEN && oidcEnabled:
+			ctrl.refreshClientToken(c, clientRes, &params)
+		default:
+			oauthError(c, http.StatusBadRequest, misc.OAUTH_ERR_UNSUPPORTED_GRANT_TYPE, "Unsupported grant_type.")
+		}
+	}
+}
+
+// authenticateClient accepts client_secret_basic and client_secret_post, a public client only sends its client_id.
+// It answers invalid_client itself and returns nil then.
+// The credentials of the body are only used without an Authorization header.
+func (ctrl *OAuth) authenticateClient(c *gin.Context, clientID, clientSecret string) *models.EntityOAuthClient {
+	basicID, basicSecret, basic := c.Request.BasicAuth()
+	if basic {
+		// RFC 6749 form-encodes both before the Basic encoding.
+		clientID, _ = url.QueryUnescape(basicID)
+		clientSecret, _ = url.QueryUnescape(basicSecret)
+	}
+
+	clientRes, err := models.NewOAuthClientsTableEngine(ctrl.DB).GetByID(clientID)
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, misc.OAUTH_ERR_SERVER_ERROR, err.Error())
+		return nil
+	} else if clientRes == nil || (clientRes.IsPublic() && clientSecret != "") || (!clientRes.IsPublic() && !clientRes.VerifySecret(clientSecret)) {
+		if basic {
+			c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+		oauthError(c, http.StatusUnauthorized, misc.OAUTH_ERR_INVALID_CLIENT, "Client authentication failed.")
+		return nil
+	}
+
+	return clientRes
+}
+
+// issueClientCredentials hands a confidential client an access token for itself, without refresh token. The scope
+// defaults to every client scope the client is registered for.
+func (ctrl *OAuth) issueClientCredentials(c *gin.Context, clientRes *models.EntityOAuthClient, params *oauthTokenParams) {
+	if clientRes.IsPublic() {
+		oauthError(c, http.StatusBadRequest, misc.OAUTH_ERR_UNAUTHORIZED_CLIENT, "A public client can not use client_credentials.")
+		return
+	}
+
+	granted := []string{}
+	for _, scope := range misc.ClientScopes {
+		if misc.HasScope(clientRes.Scopes, scope) && (params.Scope == "" || misc.HasScope(params.Scope, scope)) {
+			granted = append(granted, scope)
+		}
+	}
+
+	scope := strings.Join(granted, " ")
+	if len(granted) == 0 || !misc.ScopeIncludes(scope, params.Scope) {
+		oauthError(c, http.StatusBadRequest, misc.OAUTH_ERR_INVALID_SCOPE, "The scope is not allowed for the client.")
+		return
+	}
+
+	nowTime := time.Now()
+	accessExpireSecs := ctrl.Config.GetAccessTokenExpireSecs()
+
+	miscJWT := misc.NewJWT(ctrl.Config.GetJWTKeyset())
+	accessToken, err := miscJWT.GenToken(misc.AccessJwtClaims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.New().String(),
+			Subject:   clientRes.ID.String(),
+			ExpiresAt: nowTime.Add(time.Duration(accessExpireSecs) * time.Second).Unix(),
+			IssuedAt:  nowTime.Unix(),
+		},
+		Type:     misc.JWT_TYPE_CLIENT,
+		Scope:    scope,
+		ClientID: clientRes.ID.String(),
+	})
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, misc.OAUTH_ERR_SERVER_ERROR, err.Error())
+		return
+	}
+
+	c.AbortWithStatusJSON(http.StatusOK, oauthTokenResp{
+		AccessToken: accessToken,
+		TokenType:   TOKEN_TYPE_BEARER,
+		ExpiresIn:   accessExpireSecs,
+		Scope:       scope,
+	})
+}
+
+func (ctrl *OAuth) exchangeCode(c *gin.Context, clientRes *models.EntityOAuthClient, params *oauthTokenParams) {
+	if params.Code == "" || params.CodeVerifier == "" || params.RedirectURI == "" {
+		oauthError(c, http.StatusBadRequest, misc.OAUTH_ERR_INVALID_REQUEST, "code, code_verifier and redirect_uri are required.")
+		return
+	}
+
+	// The code is checked against the request before it is consumed, a request of another client or with a wrong
+	// verifier can't burn the code of the legitimate one.
+	codesEngine := models.NewOAuthCodesTableEngine(ctrl.DB)
+	codeRes, err := codesEngine.GetByCodeHash(misc.HashToken(params.Code))
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, misc.OAUTH_ERR_SERVER_ERROR, err.Error())
+		return
+	} else if codeRes == nil || *codeRes.ClientID != *clientRes.ID || codeRes.RedirectURI != params.RedirectURI || !misc.VerifyPKCE(params.CodeVerifier, codeRes.CodeChallenge) {
+		oauthError(c, http.StatusBadRequest, misc.OAUTH_ERR_INVALID_GRANT, "The code is invalid.")
+		return
+	}
+
+	if consumed, err := codesEngine.Consume(codeRes.ID); err != nil {
+		oauthError(c, http.StatusInternalServerError, misc.OAUTH_ERR_SERVER_ERROR, err.Error())
+		return
+	} else if !consumed {
+		oauthError(c, http.StatusBadRequest, misc.OAUTH_ERR_INVALID_GRANT, "The code is invalid.")
+		return
+	}
+
+	userRes, err := models.NewUsersTableEngine(ctrl.DB).GetByID(codeRes.UserID.String())
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, misc.OAUTH_ERR_SERVER_ERROR, err.Error())
+		return
+	} else if userRes == nil || userRes.Status != USER_STATUS_ENABLED {
+		oauthError(c, http.StatusBadRequest, misc.OAUTH_ERR_INVALID_GRANT, ErrAccountNotEnabled.Error())
+		return
+	}
+
+	nonce := ""
+	if codeRes.Nonce != nil {
+		nonce = *codeRes.Nonce
+	}
+
+	ctrl.respondClientTokens(c, userRes, clientRes.ID, codeRes.Scope, nonce)
+}
+
+func (ctrl *OAuth) refreshClientToken(c *gin.Context, clientRes *models.EntityOAuthClient, params *oauthTokenParams) {
+	if params.RefreshToken == "" {
+		oauthError(c, http.StatusBadRequest, misc.OAUTH_ERR_INVALID_REQUEST, "refresh_token is required.")
+		return
+	}
+
+	refreshRes, userRes, err := NewAuth(ctrl.Config).rotateRefreshToken(params.RefreshToken, clientRes.ID)
+	if err == ErrRefreshTokenInvalid || err == ErrAccountNotEnabled {
+		oauthError(c, http.StatusBadRequest, misc.OAUTH_ERR_INVALID_GRANT, err.Error())
+		return
+	} else if err != nil {
+		oauthError(c, http.StatusInternalServerError, misc.OAUTH_ERR_SERVER_ERROR, err.Error())
+		return
+	}
+
+	scope := ""
+	if refreshRes.Scope != nil {
+		scope = *refreshRes.Scope
+	}
+
+	ctrl.respondClientTokens(c, userRes, clientRes.ID, scope, "")
+}
+
+// respondClientTokens issues the tokens of the grant, with an ID token when the openid scope was granted.
+func (ctrl *OAuth) respondClientTokens(c *gin.Context, user *models.EntityUser, clientID *uuid.UUID, scope, nonce string) {
+	tokenRes, err := NewAuth(ctrl.Config).issueClientTokens(user, clientID, scope)
+	if err == ErrPasswordResetRequired {
+		oauthError(c, http.StatusBadRequest, misc.OAUTH_ERR_INVALID_GRANT, err.Error())
+		return
+	} else if err != nil {
+		oauthError(c, http.StatusInternalServerError, misc.OAUTH_ERR_SERVER_ERROR, err.Error())
+		return
+	}
+
+	resp := oauthTokenResp{
+		AccessToken:  tokenRes.AccessToken,
+		TokenType:    tokenRes.TokenType,
+		ExpiresIn:    tokenRes.ExpiresIn,
+		RefreshToken: tokenRes.RefreshToken,
+		Scope:        scope,
+	}
+
+	if misc.HasScope(scope, misc.SCOPE_OPENID) {
+		if resp.IDToken, err = ctrl.genIDToken(user, clientID, scope, nonce); err != nil {
+			oauthError(c, http.StatusInternalServerError, misc.OAUTH_ERR_SERVER_ERROR, err.Error())
+			return
+		}
+	}
+
+	c.AbortWithStatusJSON(http.StatusOK, resp)
+}
+
+// genIDToken signs the ID token, sub is the users.id and aud the client_id.
+func (ctrl *OAuth) genIDToken(user *models.EntityUser, clientID *uuid.UUID, scope, nonce string) (string, error) {
+	nowTime := time.Now()
+
+	claims := misc.IDJwtClaims{
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    ctrl.Config.GetOIDCConfig().Issuer,
+			Subject:   user.ID.String(),
+			Audience:  clientID.String(),
+			ExpiresAt: nowTime.Add(time.Duration(ctrl.Config.GetAccessTokenExpireSecs()) * time.Second).Unix(),
+			IssuedAt:  nowTime.Unix(),
+		},
+		Nonce: nonce,
+	}
+
+	// Identities are email addresses confirmed by the signup link.
+	if misc.HasScope(scope, misc.SCOPE_EMAIL) {
+		emailVerified := true
+		claims.Email, claims.EmailVerified = user.Identity, &emailVerified
+	}
+
+	miscJWT := misc.NewJWT(ctrl.Config.GetJWTKeyset())
+	return miscJWT.GenToken(claims)
+}
+
+// ================================================================
+// Introspection & Revocation
+// ================================================================
+type oauthTokenHintParams struct {
+	Token         string `form:"token" binding:"required"`
+	TokenTypeHint string `form:"token_type_hint"`
+	ClientID      string `form:"client_id"`
+	ClientSecret  string `form:"client_secret"`
+}
+
+type introspectResp struct {
+	Active   bool   `json:"active"`
+	Sub      string `json:"sub,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+	Exp      int64  `json:"exp,omitempty"`
+	Iat      int64  `json:"iat,omitempty"`
+}
+
+// Introspect tells a resource server whether a token is active, as RFC 7662 asks. Only confidential clients can ask,
+// and a refresh token is only disclosed to the client it was issued to. token_type_hint is not needed, an access
+// token is recognized by its signature.
+func (ctrl *OAuth) Introspect() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "no-store")
+		c.Header("Pragma", "no-cache")
+
+		var params oauthTokenHintParams
+		if err := c.ShouldBindWith(&params, binding.FormPost); err != nil {
+			oauthError(c, http.StatusBadRequest, misc.OAUTH_ERR_INVALID_REQUEST, err.Error())
+			return
+		}
+
+		clientRes := ctrl.authenticateClient(c, params.ClientID, params.ClientSecret)
+		if clientRes == nil {
+			return
+		} else if clientRes.IsPublic() {
+			oauthError(c, http.StatusBadRequest, misc.OAUTH_ERR_UNAUTHORIZED_CLIENT, "A public client can not introspect tokens.")
+			return
+		}
+
+		claims, err := ctrl.parseAnyAccessToken(params.Token)
+		if err != nil {
+			oauthError(c, http.StatusInternalServerError, misc.OAUTH_ERR_SERVER_ERROR, err.Error())
+			return
+		} else if claims != nil {
+			c.AbortWithStatusJSON(http.StatusOK, introspectResp{
+				Active:   true,
+				Sub:      claims.Subject,
+				Scope:    claims.Scope,
+				ClientID: claims.ClientID,
+				Exp:      claims.ExpiresAt,
+				Iat:      claims.IssuedAt,
+			})
+			return
+		}
+
+		refreshRes, err := models.NewRefreshTokensTableEngine(ctrl.DB).GetByTokenHash(misc.HashToken(params.Token))
+		if err != nil {
+			oauthError(c, http.StatusInternalServerError, misc.OAUTH_ERR_SERVER_ERROR, err.Error())
+			return
+		} else if refreshRes == nil || !refreshRes.IsIssuedTo(clientRes.ID) || refreshRes.IsRevoked() || refreshRes.IsExpired() {
+			c.AbortWithStatusJSON(http.StatusOK, introspectResp{Active: false})
+			return
+		}
+
+		resp := introspectResp{
+			Active:   true,
+			Sub:      refreshRes.UserID.String(),
+			ClientID: clientRes.ID.String(),
+			Exp:      refreshRes.ExpiresAt.Unix(),
+			Iat:      refreshRes.Ctime.Unix(),
+		}
+		if refreshRes.Scope != nil {
+			resp.Scope = *refreshRes.Scope
+		}
+
+		c.AbortWithStatusJSON(http.StatusOK, resp)
+		return
+	}
+}
+
+// Revoke revokes an access or refresh token of the client, as RFC 7009 asks. Unknown, expired and already revoked
+// tokens are answered 200 as well. Revoking a refresh token leaves the access tokens it issued valid until they
+// expire.
+func (ctrl *OAuth) Revoke() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var params oauthTokenHintParams
+		if err := c.ShouldBindWith(&params, binding.FormPost); err != nil {
+			oauthError(c, http.StatusBadRequest, misc.OAUTH_ERR_INVALID_REQUEST, err.Error())
+			return
+		}
+
+		clientRes := ctrl.authenticateClient(c, params.ClientID, params.ClientSecret)
+		if clientRes == nil {
+			return
+		}
+
+		if claims, err := ctrl.parseAnyAccessToken(params.Token); err != nil {
+			oauthError(c, http.StatusInternalServerError, misc.OAUTH_ERR_SERVER_ERROR, err.Error())
+			return
+		} else if claims != nil {
+			// The access tokens of /auth/v1 are issued to no client, any client may revoke them.
+			if claims.ClientID != "" && claims.ClientID != clientRes.ID.String() {
+				oauthError(c, http.StatusBadRequest, misc.OAUTH_ERR_UNAUTHORIZED_CLIENT, "The token was not issued to the client.")
+				return
+			}
+
+			jti, err := uuid.Parse(claims.Id)
+			if err != nil {
+				oauthError(c, http.StatusBadRequest, misc.OAUTH_ERR_INVALID_REQUEST, "The token has no jti.")
+				return
+			}
+
+			if _, err := models.NewRevokedTokensTableEngine(ctrl.DB).Insert(&jti, time.Unix(claims.ExpiresAt, 0)); err != nil {
+				oauthError(c, http.StatusInternalServerError, misc.OAUTH_ERR_SERVER_ERROR, err.Error())
+				return
+			}
+		} else if refreshRes, err := models.NewRefreshTokensTableEngine(ctrl.DB).GetByTokenHash(misc.HashToken(params.Token)); err != nil {
+			oauthError(c, http.StatusInternalServerError, misc.OAUTH_ERR_SERVER_ERROR, err.Error())
+			return
+		} else if refreshRes != nil {
+			if !refreshRes.IsIssuedTo(clientRes.ID) {
+				oauthError(c, http.StatusBadRequest, misc.OAUTH_ERR_UNAUTHORIZED_CLIENT, "The token was not issued to the client.")
+				return
+			}
+
+			if _, err := models.NewRefreshTokensTableEngine(ctrl.DB).Revoke(refreshRes.ID); err != nil {
+				oauthError(c, http.StatusInternalServerError, misc.OAUTH_ERR_SERVER_ERROR, err.Error())
+				return
+			}
+		}
+
+		c.AbortWithStatusJSON(http.StatusOK, gin.H{"message": http.StatusText(http.StatusOK)})
+		return
+	}
+}
+
+// parseAnyAccessToken returns the claims of an active access token, of a user or of a client, and nil for anything
+// else.
+func (ctrl *OAuth) parseAnyAccessToken(tokenStr string) (*misc.AccessJwtClaims, error) {
+	for _, parse := range []func(config.ConfigInterface, string) (*misc.AccessJwtClaims, error){middlewares.ParseAccessToken, middlewares.ParseClientToken} {
+		if claims, err := parse(ctrl.Config, tokenStr); err == nil {
+			return claims, nil
+		} else if err != middlewares.ErrInvalidAccessToken {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// ================================================================
+// UserInfo
+// ================================================================
+type userInfoResp struct {
+	Sub           string `json:"sub"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+}
+
+func (ctrl *OAuth) UserInfo() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userRes, err := models.NewUsersTableEngine(ctrl.DB).GetByID(middlewares.GetUserID(c).String())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if userRes == nil || userRes.Status != USER_STATUS_ENABLED {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": http.StatusText(http.StatusUnauthorized)})
+			return
+		}
+
+		resp := userInfoResp{Sub: userRes.ID.String()}
+		if middlewares.GetAccessClaims(c).HasScope(misc.SCOPE_EMAIL) {
+			emailVerified := true
+			resp.Email, resp.EmailVerified = userRes.Identity, &emailVerified
+		}
+
+		c.AbortWithStatusJSON(http.StatusOK, resp)
+		return
+	}
+}
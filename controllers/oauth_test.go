@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hexcraft-biz/base-accounts-service/config"
+	"github.com/hexcraft-biz/base-accounts-service/misc"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	oauthTestRedirectURI = "https://app.example.com/callback"
+	oauthTestVerifier    = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+type oauthConfig struct {
+	config.ConfigInterface
+	db     *sqlx.DB
+	keyset *misc.JWTKeyset
+}
+
+func (cfg *oauthConfig) GetDB() *sqlx.DB               { return cfg.db }
+func (cfg *oauthConfig) GetJWTKeyset() *misc.JWTKeyset { return cfg.keyset }
+func (cfg *oauthConfig) GetOIDCConfig() *misc.OIDCConfig {
+	return &misc.OIDCConfig{Issuer: "https://accounts.example.com"}
+}
+func (cfg *oauthConfig) GetAccessTokenExpireSecs() int  { return 900 }
+func (cfg *oauthConfig) GetRefreshTokenExpireSecs() int { return 2592000 }
+
+// newOAuthEngine wires the token endpoint like features.LoadOAuth does, on a mocked database.
+func newOAuthEngine(t *testing.T) (*gin.Engine, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	keyset, err := misc.NewJWTKeyset(nil, "", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewOAuth(&oauthConfig{db: sqlx.NewDb(db, "mysql"), keyset: keyset})
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.POST("/oauth/token", c.Token())
+
+	return engine, mock
+}
+
+// nowArg matches the current time, the expiry of a code is compared against it.
+type nowArg struct{}
+
+func (nowArg) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	return ok && time.Since(t) < time.Minute && time.Until(t) < time.Minute
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// expectPublicClient expects the lookup of a client without secret by authenticateClient.
+func expectPublicClient(mock sqlmock.Sqlmock, clientID uuid.UUID) {
+	now := time.Now().UTC()
+	mock.ExpectQuery(`SELECT * FROM oauth_clients WHERE id = UUID_TO_BIN(?);`).
+		WithArgs(clientID.String()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ctime", "mtime", "name", "secret_hash", "redirect_uris", "scopes"}).
+			AddRow(clientID[:], now, now, "App", nil, oauthTestRedirectURI, ""))
+}
+
+// expectCode expects the lookup of the code, codeID nil stands for a code that is unknown or has expired.
+func expectCode(mock sqlmock.Sqlmock, code string, codeID, clientID, userID *uuid.UUID) {
+	rows := sqlmock.NewRows([]string{"id", "ctime", "mtime", "code_hash", "client_id", "user_id", "redirect_uri", "scope", "nonce", "code_challenge", "expires_at"})
+	if codeID != nil {
+		now := time.Now().UTC()
+		rows.AddRow(codeID[:], now, now, misc.HashToken(code), clientID[:], userID[:], oauthTestRedirectURI, "openid", "n-0S6_WzA2Mj", pkceChallenge(oauthTestVerifier), now.Add(time.Minute))
+	}
+
+	mock.ExpectQuery(`SELECT * FROM oauth_codes WHERE code_hash = ? AND expires_at > ?;`).
+		WithArgs(misc.HashToken(code), nowArg{}).
+		WillReturnRows(rows)
+}
+
+func codeRequest(engine *gin.Engine, clientID uuid.UUID, code, redirectURI, verifier string) *httptest.ResponseRecorder {
+	form := url.Values{
+		"grant_type":    {misc.OAUTH_GRANT_AUTHORIZATION_CODE},
+		"client_id":     {clientID.String()},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	}
+
+	req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	return w
+}
+
+func checkOAuthError(t *testing.T, w *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+
+	var body map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != status || body["error"] != code {
+		t.Fatalf("status = %d, body = %s, want %d %s", w.Code, w.Body.String(), status, code)
+	}
+}
+
+func TestExchangeCode(t *testing.T) {
+	engine, mock := newOAuthEngine(t)
+	clientID, codeID, userID := uuid.New(), uuid.New(), uuid.New()
+	now := time.Now().UTC()
+	code := "SplxlOBeZQQYbYS6WxSbIA"
+
+	// The code is consumed before the tokens are issued, the refresh token is bound to the client.
+	expectPublicClient(mock, clientID)
+	expectCode(mock, code, &codeID, &clientID, &userID)
+	mock.ExpectExec(`DELETE FROM oauth_codes WHERE id = UUID_TO_BIN(?);`).
+		WithArgs(codeID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT * FROM users WHERE id = UUID_TO_BIN(?);`).
+		WithArgs(userID.String()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ctime", "mtime", "identity", "status", "password_reset_required"}).
+			AddRow(userID[:], now, now, "user@example.com", USER_STATUS_ENABLED, false))
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO refresh_tokens (id,ctime,mtime,user_id,client_id,token_hash,scope,expires_at) VALUES (UUID_TO_BIN(?),?,?,UUID_TO_BIN(?),UUID_TO_BIN(?),?,?,?);`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), userID.String(), clientID.String(), sqlmock.AnyArg(), "openid", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := codeRequest(engine, clientID, code, oauthTestRedirectURI, oauthTestVerifier)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	var resp oauthTokenResp
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.AccessToken == "" || resp.RefreshToken == "" || resp.IDToken == "" || resp.Scope != "openid" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	// A replayed code is gone and not consumed a second time.
+	expectPublicClient(mock, clientID)
+	expectCode(mock, code, nil, nil, nil)
+
+	checkOAuthError(t, codeRequest(engine, clientID, code, oauthTestRedirectURI, oauthTestVerifier), http.StatusBadRequest, misc.OAUTH_ERR_INVALID_GRANT)
+	checkExpectations(t, mock)
+}
+
+func TestExchangeCodeConsumedConcurrently(t *testing.T) {
+	engine, mock := newOAuthEngine(t)
+	clientID, codeID, userID := uuid.New(), uuid.New(), uuid.New()
+	code := "SplxlOBeZQQYbYS6WxSbIA"
+
+	// Another exchange deleted the code between the lookup and Consume, no token is issued.
+	expectPublicClient(mock, clientID)
+	expectCode(mock, code, &codeID, &clientID, &userID)
+	mock.ExpectExec(`DELETE FROM oauth_codes WHERE id = UUID_TO_BIN(?);`).
+		WithArgs(codeID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	checkOAuthError(t, codeRequest(engine, clientID, code, oauthTestRedirectURI, oauthTestVerifier), http.StatusBadRequest, misc.OAUTH_ERR_INVALID_GRANT)
+	checkExpectations(t, mock)
+}
+
+func TestExchangeCodeRejected(t *testing.T) {
+	clientID, otherClientID, codeID, userID := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	code := "SplxlOBeZQQYbYS6WxSbIA"
+
+	// None of these requests consumes the code, the legitimate client can still exchange it.
+	cases := []struct {
+		name        string
+		clientID    uuid.UUID
+		codeID      *uuid.UUID
+		redirectURI string
+		verifier    string
+	}{
+		{"wrong redirect_uri", clientID, &codeID, "https://app.example.com/callback/other", oauthTestVerifier},
+		{"wrong client", otherClientID, &codeID, oauthTestRedirectURI, oauthTestVerifier},
+		{"bad verifier", clientID, &codeID, oauthTestRedirectURI, "M25iVXpKU3puUjFaYWg3T1NDTDQtcW1ROUY5YXlwalNoc0hhakxifmZHag"},
+		{"expired code", clientID, nil, oauthTestRedirectURI, oauthTestVerifier},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			engine, mock := newOAuthEngine(t)
+
+			expectPublicClient(mock, tc.clientID)
+			expectCode(mock, code, tc.codeID, &clientID, &userID)
+
+			checkOAuthError(t, codeRequest(engine, tc.clientID, code, tc.redirectURI, tc.verifier), http.StatusBadRequest, misc.OAUTH_ERR_INVALID_GRANT)
+			checkExpectations(t, mock)
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hexcraft-biz/base-accounts-service/middlewares"
+	"github.com/hexcraft-biz/base-accounts-service/models"
+	"github.com/jmoiron/sqlx"
+)
+
+// ================================================================
+// Sessions
+// ================================================================
+// ListSessions shows where the user is logged in, `current` marks the session of the access token.
+func (ctrl *Auth) ListSessions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idleSince := time.Now().Add(-time.Duration(ctrl.Config.GetRefreshTokenExpireSecs()) * time.Second)
+
+		rows, err := models.NewSessionsTableEngine(ctrl.DB).ListByUserID(middlewares.GetUserID(c), idleSince)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+
+		currentID := middlewares.GetAccessClaims(c).SessionID
+
+		absRows := make([]*models.AbsSession, len(rows))
+		for i, row := range rows {
+			absRows[i] = row.GetAbsSession(currentID)
+		}
+
+		c.AbortWithStatusJSON(http.StatusOK, absRows)
+		return
+	}
+}
+
+// DeleteSession signs a session of the user out, its access tokens are refused from now on.
+func (ctrl *Auth) DeleteSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sessionsEngine := models.NewSessionsTableEngine(ctrl.DB)
+
+		if entityRes, err := sessionsEngine.GetByID(middlewares.GetUserID(c), c.Param("id")); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if entityRes == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
+			return
+		} else if _, err := sessionsEngine.Delete(entityRes.ID); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusNoContent, gin.H{"message": http.StatusText(http.StatusNoContent)})
+		return
+	}
+}
+
+// DeleteOtherSessions signs out everywhere else, the session of the access token is kept.
+func (ctrl *Auth) DeleteOtherSessions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		currentID, err := uuid.Parse(middlewares.GetAccessClaims(c).SessionID)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "The access token has no session, log in again."})
+			return
+		}
+
+		if err := models.WithTx(ctrl.DB, func(tx *sqlx.Tx) error {
+			return ctrl.endSessions(tx, middlewares.GetUserID(c), &currentID)
+		}); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusNoContent, gin.H{"message": http.StatusText(http.StatusNoContent)})
+		return
+	}
+}
+
+// endSessions signs out every session of the user but exceptID, with the refresh tokens issued before sessions were
+// recorded. Without exceptID the refresh tokens of the OAuth clients are revoked as well. It runs in the transaction
+// of the change that ends the sessions, a password is never changed with the old sessions left alive.
+func (ctrl *Auth) endSessions(tx *sqlx.Tx, userID *uuid.UUID, exceptID *uuid.UUID) error {
+	if _, err := models.NewSessionsTableEngine(ctrl.DB).DeleteByUserID(tx, userID, exceptID); err != nil {
+		return err
+	}
+
+	refreshTokensEngine := models.NewRefreshTokensTableEngine(ctrl.DB)
+	if exceptID == nil {
+		_, err := refreshTokensEngine.RevokeByUserID(tx, userID)
+		return err
+	}
+
+	_, err := refreshTokensEngine.RevokeUnboundByUserID(tx, userID)
+	return err
+}
@@ -0,0 +1,230 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"github.com/hexcraft-biz/base-accounts-service/config"
+	"github.com/hexcraft-biz/base-accounts-service/middlewares"
+	"github.com/hexcraft-biz/base-accounts-service/misc"
+	"github.com/hexcraft-biz/base-accounts-service/models"
+	"github.com/jmoiron/sqlx"
+)
+
+type sessionsConfig struct {
+	config.ConfigInterface
+	db     *sqlx.DB
+	keyset *misc.JWTKeyset
+}
+
+func (cfg *sessionsConfig) GetDB() *sqlx.DB                { return cfg.db }
+func (cfg *sessionsConfig) GetJWTKeyset() *misc.JWTKeyset  { return cfg.keyset }
+func (cfg *sessionsConfig) GetRefreshTokenExpireSecs() int { return 2592000 }
+
+// newSessionsEngine wires the sessions routes like features.LoadAuth does, on a mocked database.
+func newSessionsEngine(t *testing.T) (*gin.Engine, sqlmock.Sqlmock, *sessionsConfig) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	keyset, err := misc.NewJWTKeyset(nil, "", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &sessionsConfig{db: sqlx.NewDb(db, "mysql"), keyset: keyset}
+	c := NewAuth(cfg)
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/auth/v1/sessions", middlewares.AccessToken(cfg), c.ListSessions())
+	engine.DELETE("/auth/v1/sessions", middlewares.AccessToken(cfg), c.DeleteOtherSessions())
+	engine.DELETE("/auth/v1/sessions/:id", middlewares.AccessToken(cfg), c.DeleteSession())
+
+	return engine, mock, cfg
+}
+
+// sessionAccessToken signs an access token of the session and expects the lookups of middlewares.AccessToken,
+// signedOut tells whether the session is gone.
+func sessionAccessToken(t *testing.T, mock sqlmock.Sqlmock, cfg *sessionsConfig, userID, sessionID uuid.UUID, signedOut bool) string {
+	jti := uuid.New().String()
+	tokenStr, err := misc.NewJWT(cfg.keyset).GenToken(misc.AccessJwtClaims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Subject:   userID.String(),
+			ExpiresAt: time.Now().Add(15 * time.Minute).Unix(),
+		},
+		Type:      misc.JWT_TYPE_ACCESS,
+		SessionID: sessionID.String(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := 1
+	if signedOut {
+		count = 0
+	}
+
+	mock.ExpectQuery(`SELECT COUNT(*) FROM revoked_tokens WHERE id = UUID_TO_BIN(?);`).
+		WithArgs(jti).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(`SELECT COUNT(*) FROM sessions WHERE id = UUID_TO_BIN(?);`).
+		WithArgs(sessionID.String()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(count))
+
+	return tokenStr
+}
+
+func sessionRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "ctime", "mtime", "user_id", "user_agent", "ip", "device_label", "last_seen_at"})
+}
+
+func sessionsRequest(engine *gin.Engine, method, path, tokenStr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer "+tokenStr)
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	return w
+}
+
+func checkExpectations(t *testing.T, mock sqlmock.Sqlmock) {
+	t.Helper()
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListSessions(t *testing.T) {
+	engine, mock, cfg := newSessionsEngine(t)
+	userID, currentID, otherID := uuid.New(), uuid.New(), uuid.New()
+	seen := time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	tokenStr := sessionAccessToken(t, mock, cfg, userID, currentID, false)
+	mock.ExpectQuery(`SELECT * FROM sessions WHERE user_id = UUID_TO_BIN(?) AND last_seen_at > ? ORDER BY last_seen_at DESC, id;`).
+		WithArgs(userID.String(), sqlmock.AnyArg()).
+		WillReturnRows(sessionRows().
+			AddRow(otherID[:], seen, seen, userID[:], "Mozilla/5.0 (iPhone)", "203.0.113.9", "Safari on iOS", seen).
+			AddRow(currentID[:], seen, seen, userID[:], "Mozilla/5.0 (Macintosh)", "10.0.0.1", "Chrome on macOS", seen.Add(-time.Hour)))
+
+	w := sessionsRequest(engine, "GET", "/auth/v1/sessions", tokenStr)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	var sessions []models.AbsSession
+	if err := json.Unmarshal(w.Body.Bytes(), &sessions); err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("got %d sessions, want 2", len(sessions))
+	}
+
+	// Only the session of the access token is current.
+	if sessions[0].ID != otherID || sessions[0].Current || sessions[0].DeviceLabel != "Safari on iOS" || sessions[0].LastSeenAt != "2022-03-04 05:06:07" {
+		t.Fatalf("unexpected session %+v", sessions[0])
+	}
+	if sessions[1].ID != currentID || !sessions[1].Current {
+		t.Fatalf("unexpected session %+v", sessions[1])
+	}
+
+	checkExpectations(t, mock)
+}
+
+func TestDeleteSession(t *testing.T) {
+	engine, mock, cfg := newSessionsEngine(t)
+	userID, currentID, otherID := uuid.New(), uuid.New(), uuid.New()
+	seen := time.Now().UTC().Truncate(time.Second)
+
+	tokenStr := sessionAccessToken(t, mock, cfg, userID, currentID, false)
+	mock.ExpectQuery(`SELECT * FROM sessions WHERE id = UUID_TO_BIN(?) AND user_id = UUID_TO_BIN(?);`).
+		WithArgs(otherID.String(), userID.String()).
+		WillReturnRows(sessionRows().AddRow(otherID[:], seen, seen, userID[:], "", "203.0.113.9", "", seen))
+	mock.ExpectExec(`DELETE FROM sessions WHERE id = UUID_TO_BIN(?);`).
+		WithArgs(otherID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if w := sessionsRequest(engine, "DELETE", "/auth/v1/sessions/"+otherID.String(), tokenStr); w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	// A session of another user is looked up with the user and not found.
+	foreignID := uuid.New()
+	tokenStr = sessionAccessToken(t, mock, cfg, userID, currentID, false)
+	mock.ExpectQuery(`SELECT * FROM sessions WHERE id = UUID_TO_BIN(?) AND user_id = UUID_TO_BIN(?);`).
+		WithArgs(foreignID.String(), userID.String()).
+		WillReturnRows(sessionRows())
+
+	if w := sessionsRequest(engine, "DELETE", "/auth/v1/sessions/"+foreignID.String(), tokenStr); w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", w.Code)
+	}
+
+	// An id that is not a UUID is not even looked up.
+	tokenStr = sessionAccessToken(t, mock, cfg, userID, currentID, false)
+	if w := sessionsRequest(engine, "DELETE", "/auth/v1/sessions/current", tokenStr); w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", w.Code)
+	}
+
+	checkExpectations(t, mock)
+}
+
+func TestDeleteOtherSessions(t *testing.T) {
+	engine, mock, cfg := newSessionsEngine(t)
+	userID, currentID := uuid.New(), uuid.New()
+
+	// The sessions and the refresh tokens without one end in one transaction, the current session is kept.
+	tokenStr := sessionAccessToken(t, mock, cfg, userID, currentID, false)
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM sessions WHERE user_id = UUID_TO_BIN(?) AND id <> UUID_TO_BIN(?);`).
+		WithArgs(userID.String(), currentID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = UUID_TO_BIN(?) AND client_id IS NULL AND session_id IS NULL AND revoked_at IS NULL;`).
+		WithArgs(userID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if w := sessionsRequest(engine, "DELETE", "/auth/v1/sessions", tokenStr); w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	// A failure leaves every session in place.
+	tokenStr = sessionAccessToken(t, mock, cfg, userID, currentID, false)
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM sessions WHERE user_id = UUID_TO_BIN(?) AND id <> UUID_TO_BIN(?);`).
+		WithArgs(userID.String(), currentID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = UUID_TO_BIN(?) AND client_id IS NULL AND session_id IS NULL AND revoked_at IS NULL;`).
+		WithArgs(userID.String()).
+		WillReturnError(errors.New("connection lost"))
+	mock.ExpectRollback()
+
+	if w := sessionsRequest(engine, "DELETE", "/auth/v1/sessions", tokenStr); w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", w.Code)
+	}
+
+	checkExpectations(t, mock)
+}
+
+func TestSignedOutSessionAccessToken(t *testing.T) {
+	engine, mock, cfg := newSessionsEngine(t)
+
+	// The token is well signed and unexpired, its session is gone.
+	tokenStr := sessionAccessToken(t, mock, cfg, uuid.New(), uuid.New(), true)
+	if w := sessionsRequest(engine, "GET", "/auth/v1/sessions", tokenStr); w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", w.Code)
+	}
+
+	checkExpectations(t, mock)
+}
@@ -0,0 +1,384 @@
+package controllers
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
+	"github.com/hexcraft-biz/base-accounts-service/middlewares"
+	"github.com/hexcraft-biz/base-accounts-service/misc"
+	"github.com/hexcraft-biz/base-accounts-service/models"
+)
+
+const (
+	MFA_METHOD_WEBAUTHN            = "webauthn"
+	WEBAUTHN_CHALLENGE_EXPIRE_MINS = 5
+)
+
+type webauthnOptionsResp struct {
+	SessionID string      `json:"sessionId"`
+	PublicKey interface{} `json:"publicKey"`
+}
+
+// ================================================================
+// Registration
+// ================================================================
+func (ctrl *Auth) WebAuthnRegisterOptions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := middlewares.GetUserID(c)
+
+		userRes, err := models.NewUsersTableEngine(ctrl.DB).GetByID(userID.String())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if userRes == nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
+			return
+		}
+
+		exclude, err := ctrl.webauthnDescriptors(userID)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+
+		challengeRes, err := ctrl.genWebAuthnChallenge(userID, models.WEBAUTHN_CHALLENGE_TYPE_REGISTER)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusOK, webauthnOptionsResp{
+			SessionID: challengeRes.ID.String(),
+			PublicKey: ctrl.Config.GetWebAuthnConfig().CreationOptions(challengeRes.Challenge, userID[:], userRes.Identity, exclude),
+		})
+		return
+	}
+}
+
+type webauthnAttestationParams struct {
+	SessionID  string `json:"sessionId" binding:"required,uuid"`
+	Label      string `json:"label" binding:"max=64"`
+	Credential struct {
+		ID       string `json:"id" binding:"required"`
+		Type     string `json:"type" binding:"required,eq=public-key"`
+		Response struct {
+			ClientDataJSON    string   `json:"clientDataJSON" binding:"required"`
+			AttestationObject string   `json:"attestationObject" binding:"required"`
+			Transports        []string `json:"transports" binding:"max=8,dive,max=16"`
+		} `json:"response"`
+	} `json:"credential"`
+}
+
+func (ctrl *Auth) WebAuthnRegister() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var params webauthnAttestationParams
+		if err := c.ShouldBindJSON(&params); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+
+		clientDataJSON, cdErr := misc.DecodeBase64URL(params.Credential.Response.ClientDataJSON)
+		attestationObject, aoErr := misc.DecodeBase64URL(params.Credential.Response.AttestationObject)
+		if cdErr != nil || aoErr != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "The credential is not base64url encoded."})
+			return
+		}
+
+		userID := middlewares.GetUserID(c)
+
+		challengeRes, err := models.NewWebAuthnChallengesTableEngine(ctrl.DB).Consume(params.SessionID, models.WEBAUTHN_CHALLENGE_TYPE_REGISTER)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if challengeRes == nil || challengeRes.UserID == nil || *challengeRes.UserID != *userID {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": http.StatusText(http.StatusUnauthorized)})
+			return
+		}
+
+		cred, err := ctrl.Config.GetWebAuthnConfig().VerifyRegistration(challengeRes.Challenge, clientDataJSON, attestationObject, false)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+			return
+		}
+
+		if credentialID, err := misc.DecodeBase64URL(params.Credential.ID); err != nil || !bytes.Equal(credentialID, cred.CredentialID) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "The credential id does not match the attestation."})
+			return
+		}
+
+		if entityRes, err := models.NewWebAuthnCredentialsTableEngine(ctrl.DB).Insert(
+			userID,
+			cred.CredentialID,
+			cred.PublicKey,
+			cred.SignCount,
+			cred.AAGUID,
+			cred.AttestationFmt,
+			params.Credential.Response.Transports,
+			params.Label,
+		); err != nil {
+			if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == 1062 {
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "This credential is already registered."})
+				return
+			} else {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+				return
+			}
+		} else {
+			c.AbortWithStatusJSON(http.StatusCreated, entityRes.GetAbsCredential())
+			return
+		}
+	}
+}
+
+// ================================================================
+// Login
+// ================================================================
+type webauthnLoginOptionsParams struct {
+	Identity string `json:"identity" binding:"omitempty,email,max=127"`
+	MfaToken string `json:"mfaToken"`
+}
+
+// WebAuthnLoginOptions starts an assertion. With an mfaToken the passkey is used as the second factor of a
+// password login, otherwise it is a passwordless login which requires user verification. Without identity the
+// browser offers the discoverable credentials it has for this site.
+func (ctrl *Auth) WebAuthnLoginOptions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var params webauthnLoginOptionsParams
+		if err := c.ShouldBindJSON(&params); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+
+		var userID *uuid.UUID
+		typ, userVerification := models.WEBAUTHN_CHALLENGE_TYPE_LOGIN, misc.WEBAUTHN_UV_REQUIRED
+
+		if params.MfaToken != "" {
+			var err error
+			if userID, err = ctrl.parseMfaToken(params.MfaToken); err == ErrMfaTokenInvalid {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": http.StatusText(http.StatusUnauthorized)})
+				return
+			} else if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+				return
+			}
+			typ, userVerification = models.WEBAUTHN_CHALLENGE_TYPE_MFA, misc.WEBAUTHN_UV_PREFERRED
+		} else if params.Identity != "" {
+			if userRes, err := models.NewUsersTableEngine(ctrl.DB).GetByIdentity(params.Identity); err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+				return
+			} else if userRes != nil {
+				userID = userRes.ID
+			}
+		}
+
+		allow := []misc.WebAuthnCredentialDescriptor{}
+		if userID != nil {
+			var err error
+			if allow, err = ctrl.webauthnDescriptors(userID); err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+				return
+			}
+		}
+
+		// In privacy mode an identity without passkeys, known or not, gets decoys instead of an empty list, so the
+		// answer tells nothing about the account.
+		if len(allow) == 0 && params.MfaToken == "" && params.Identity != "" && ctrl.Config.GetPrivacyMode() {
+			allow = ctrl.Config.GetWebAuthnConfig().DecoyDescriptors(params.Identity)
+		}
+
+		challengeRes, err := ctrl.genWebAuthnChallenge(userID, typ)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusOK, webauthnOptionsResp{
+			SessionID: challengeRes.ID.String(),
+			PublicKey: ctrl.Config.GetWebAuthnConfig().RequestOptions(challengeRes.Challenge, userVerification, allow),
+		})
+		return
+	}
+}
+
+type webauthnAssertionParams struct {
+	SessionID  string `json:"sessionId" binding:"required,uuid"`
+	MfaToken   string `json:"mfaToken"`
+	Credential struct {
+		ID       string `json:"id" binding:"required"`
+		Type     string `json:"type" binding:"required,eq=public-key"`
+		Response struct {
+			ClientDataJSON    string `json:"clientDataJSON" binding:"required"`
+			AuthenticatorData string `json:"authenticatorData" binding:"required"`
+			Signature         string `json:"signature" binding:"required"`
+			UserHandle        string `json:"userHandle"`
+		} `json:"response"`
+	} `json:"credential"`
+}
+
+func (ctrl *Auth) WebAuthnLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var params webauthnAssertionParams
+		if err := c.ShouldBindJSON(&params); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+
+		credentialID, idErr := misc.DecodeBase64URL(params.Credential.ID)
+		clientDataJSON, cdErr := misc.DecodeBase64URL(params.Credential.Response.ClientDataJSON)
+		authData, adErr := misc.DecodeBase64URL(params.Credential.Response.AuthenticatorData)
+		signature, sigErr := misc.DecodeBase64URL(params.Credential.Response.Signature)
+		userHandle, uhErr := misc.DecodeBase64URL(params.Credential.Response.UserHandle)
+		if idErr != nil || cdErr != nil || adErr != nil || sigErr != nil || uhErr != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "The credential is not base64url encoded."})
+			return
+		}
+
+		var mfaUserID *uuid.UUID
+		typ, requireUV := models.WEBAUTHN_CHALLENGE_TYPE_LOGIN, true
+		if params.MfaToken != "" {
+			var err error
+			if mfaUserID, err = ctrl.parseMfaToken(params.MfaToken); err == ErrMfaTokenInvalid {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": http.StatusText(http.StatusUnauthorized)})
+				return
+			} else if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+				return
+			}
+			typ, requireUV = models.WEBAUTHN_CHALLENGE_TYPE_MFA, false
+		}
+
+		challengeRes, err := models.NewWebAuthnChallengesTableEngine(ctrl.DB).Consume(params.SessionID, typ)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if challengeRes == nil || (mfaUserID != nil && (challengeRes.UserID == nil || *challengeRes.UserID != *mfaUserID)) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": http.StatusText(http.StatusUnauthorized)})
+			return
+		}
+
+		credentialsEngine := models.NewWebAuthnCredentialsTableEngine(ctrl.DB)
+
+		credRes, err := credentialsEngine.GetByCredentialID(credentialID)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if credRes == nil ||
+			(challengeRes.UserID != nil && *challengeRes.UserID != *credRes.UserID) ||
+			(len(userHandle) > 0 && !bytes.Equal(userHandle, credRes.UserID[:])) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": http.StatusText(http.StatusUnauthorized)})
+			return
+		}
+
+		signCount, err := ctrl.Config.GetWebAuthnConfig().VerifyAssertion(challengeRes.Challenge, clientDataJSON, authData, signature, credRes.PublicKey, credRes.SignCount, requireUV)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+			return
+		}
+
+		if ok, err := credentialsEngine.UseSignCount(credRes.ID, signCount); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": misc.ErrWebAuthnSignCount.Error()})
+			return
+		}
+
+		// Like /auth/v1/mfa/verify, the MFA token is only used up by the assertion that passed.
+		if params.MfaToken != "" {
+			if claimed, err := ctrl.claimMfaToken(params.MfaToken); err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+				return
+			} else if !claimed {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": http.StatusText(http.StatusUnauthorized)})
+				return
+			}
+		}
+
+		// A user verified passkey proves possession and a PIN or biometric, so it is not followed by an MFA challenge.
+		if entityRes, err := models.NewUsersTableEngine(ctrl.DB).GetByID(credRes.UserID.String()); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if entityRes == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": http.StatusText(http.StatusUnauthorized)})
+			return
+		} else if entityRes.Status != USER_STATUS_ENABLED {
+			ctrl.respondNotEnabled(c, entityRes, http.StatusText(http.StatusUnauthorized))
+			return
+		} else {
+			ctrl.respondTokens(c, entityRes)
+			return
+		}
+	}
+}
+
+// ================================================================
+// Credentials
+// ================================================================
+func (ctrl *Auth) WebAuthnCredentials() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rows, err := models.NewWebAuthnCredentialsTableEngine(ctrl.DB).ListByUserID(middlewares.GetUserID(c))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+
+		absRows := make([]*models.AbsWebAuthnCredential, len(rows))
+		for i, row := range rows {
+			absRows[i] = row.GetAbsCredential()
+		}
+
+		c.AbortWithStatusJSON(http.StatusOK, absRows)
+		return
+	}
+}
+
+func (ctrl *Auth) WebAuthnCredentialDelete() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, err := uuid.Parse(c.Param("id")); err != nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
+			return
+		}
+
+		if affected, err := models.NewWebAuthnCredentialsTableEngine(ctrl.DB).DeleteByUserID(middlewares.GetUserID(c), c.Param("id")); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if affected == 0 {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusNoContent, gin.H{"message": http.StatusText(http.StatusNoContent)})
+		return
+	}
+}
+
+// ================================================================
+// Helpers
+// ================================================================
+func (ctrl *Auth) genWebAuthnChallenge(userID *uuid.UUID, typ string) (*models.EntityWebAuthnChallenge, error) {
+	challenge, err := misc.GenWebAuthnChallenge()
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(WEBAUTHN_CHALLENGE_EXPIRE_MINS * time.Minute)
+	return models.NewWebAuthnChallengesTableEngine(ctrl.DB).Insert(userID, typ, challenge, expiresAt)
+}
+
+func (ctrl *Auth) webauthnDescriptors(userID *uuid.UUID) ([]misc.WebAuthnCredentialDescriptor, error) {
+	rows, err := models.NewWebAuthnCredentialsTableEngine(ctrl.DB).ListByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	descriptors := make([]misc.WebAuthnCredentialDescriptor, len(rows))
+	for i, row := range rows {
+		descriptors[i] = misc.NewWebAuthnCredentialDescriptor(row.CredentialID, row.TransportList())
+	}
+
+	return descriptors, nil
+}
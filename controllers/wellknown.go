@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hexcraft-biz/base-accounts-service/config"
+	"github.com/hexcraft-biz/base-accounts-service/misc"
+	"github.com/hexcraft-biz/controller"
+)
+
+const (
+	JWKS_MAX_AGE_SECS = "300"
+)
+
+type WellKnown struct {
+	*controller.Prototype
+	Config config.ConfigInterface
+}
+
+func NewWellKnown(cfg config.ConfigInterface) *WellKnown {
+	return &WellKnown{
+		Prototype: controller.New("wellknown", cfg.GetDB()),
+		Config:    cfg,
+	}
+}
+
+// JWKS publishes the public keys verifying our tokens. Clients cache it, so a new key has to be published here
+// for at least the max age before it starts signing.
+func (ctrl *WellKnown) JWKS() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age="+JWKS_MAX_AGE_SECS)
+		c.JSON(http.StatusOK, ctrl.Config.GetJWTKeyset().JWKS())
+	}
+}
+
+type openIDConfigurationResp struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	JwksURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// OpenIDConfiguration is the discovery document of OpenID Connect, the endpoints are below the issuer.
+func (ctrl *WellKnown) OpenIDConfiguration() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		issuer := ctrl.Config.GetOIDCConfig().Issuer
+
+		c.Header("Cache-Control", "public, max-age="+JWKS_MAX_AGE_SECS)
+		c.JSON(http.StatusOK, openIDConfigurationResp{
+			Issuer:                            issuer,
+			AuthorizationEndpoint:             issuer + "/oauth/authorize",
+			TokenEndpoint:                     issuer + "/oauth/token",
+			UserinfoEndpoint:                  issuer + "/oauth/userinfo",
+			IntrospectionEndpoint:             issuer + "/oauth/introspect",
+			RevocationEndpoint:                issuer + "/oauth/revoke",
+			JwksURI:                           issuer + "/.well-known/jwks.json",
+			ResponseTypesSupported:            []string{misc.OAUTH_RESPONSE_TYPE_CODE},
+			GrantTypesSupported:               []string{misc.OAUTH_GRANT_AUTHORIZATION_CODE, misc.OAUTH_GRANT_REFRESH_TOKEN, misc.OAUTH_GRANT_CLIENT_CREDENTIALS},
+			SubjectTypesSupported:             []string{"public"},
+			IDTokenSigningAlgValuesSupported:  []string{ctrl.Config.GetJWTKeyset().Signer.Alg},
+			ScopesSupported:                   append(append([]string{}, misc.OIDCScopes...), misc.ClientScopes...),
+			TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+			CodeChallengeMethodsSupported:     []string{misc.OAUTH_CODE_CHALLENGE_S256},
+			ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "nonce", "email", "email_verified"},
+		})
+	}
+}
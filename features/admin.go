@@ -0,0 +1,33 @@
+package features
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/hexcraft-biz/base-accounts-service/config"
+	"github.com/hexcraft-biz/base-accounts-service/controllers"
+	"github.com/hexcraft-biz/base-accounts-service/middlewares"
+	"github.com/hexcraft-biz/feature"
+)
+
+func LoadAdmin(e *gin.Engine, cfg config.ConfigInterface) {
+	c := controllers.NewAdmin(cfg)
+
+	adminV1 := feature.New(e, "/admin/v1")
+	adminV1.Use(middlewares.AdminAuth(cfg))
+
+	adminV1.GET("/users", c.ListUsers())
+	adminV1.GET("/users/:id", c.GetUser())
+	adminV1.PUT("/users/:id/status", c.UpdateUserStatus())
+	adminV1.POST("/users/:id/password/reset", c.ResetUserPassword())
+	adminV1.DELETE("/users/:id", c.DeleteUser())
+	adminV1.POST("/users/import", c.ImportUsers())
+	adminV1.GET("/clients", c.ListOAuthClients())
+	adminV1.GET("/clients/:id", c.GetOAuthClient())
+	adminV1.POST("/clients", c.CreateOAuthClient())
+	adminV1.PUT("/clients/:id", c.UpdateOAuthClient())
+	adminV1.POST("/clients/:id/secret", c.RotateOAuthClientSecret())
+	adminV1.DELETE("/clients/:id", c.DeleteOAuthClient())
+	adminV1.DELETE("/lockouts/:identity", c.ClearLockout())
+	adminV1.GET("/emails", c.ListEmailOutbox())
+	adminV1.GET("/emails/:id", c.GetEmailOutbox())
+	adminV1.POST("/emails/:id/retry", c.RetryEmailOutbox())
+}
@@ -4,17 +4,21 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/hexcraft-biz/base-accounts-service/config"
 	"github.com/hexcraft-biz/base-accounts-service/controllers"
+	"github.com/hexcraft-biz/base-accounts-service/middlewares"
+	"github.com/hexcraft-biz/base-accounts-service/misc"
 	"github.com/hexcraft-biz/feature"
 )
 
 const (
-	SCOPE_USER_PROTOTYPE = "user.prototype"
+	SCOPE_USER_PROTOTYPE = misc.SCOPE_USER_PROTOTYPE
 )
 
 func LoadAuth(e *gin.Engine, cfg config.ConfigInterface) {
 	c := controllers.NewAuth(cfg)
 
 	authV1 := feature.New(e, "/auth/v1")
+	authV1.Use(middlewares.ClientToken(cfg, SCOPE_USER_PROTOTYPE))
+	authV1.Use(middlewares.RateLimit(cfg))
 
 	authV1.POST("/signup/confirmation", c.SignUpEmailConfirm())
 	authV1.GET("/signup/tokeninfo", c.SignUpTokenVerify())
@@ -25,4 +29,26 @@ func LoadAuth(e *gin.Engine, cfg config.ConfigInterface) {
 	authV1.PUT("/password", c.ChangePassword())
 
 	authV1.POST("/login", c.Login())
+	authV1.POST("/token/refresh", c.RefreshToken())
+	authV1.POST("/logout", c.Logout())
+	authV1.POST("/unlock", c.Unlock())
+
+	authV1.POST("/magiclink/confirmation", c.MagicLinkConfirm())
+	authV1.POST("/magiclink/login", c.MagicLinkLogin())
+
+	authV1.POST("/mfa/totp", middlewares.AccessToken(cfg), c.TOTPEnroll())
+	authV1.POST("/mfa/totp/confirm", middlewares.AccessToken(cfg), c.TOTPConfirm())
+	authV1.DELETE("/mfa/totp", middlewares.AccessToken(cfg), c.TOTPDisable())
+	authV1.POST("/mfa/verify", c.MfaVerify())
+
+	authV1.POST("/webauthn/register/options", middlewares.AccessToken(cfg), c.WebAuthnRegisterOptions())
+	authV1.POST("/webauthn/register", middlewares.AccessToken(cfg), c.WebAuthnRegister())
+	authV1.POST("/webauthn/login/options", c.WebAuthnLoginOptions())
+	authV1.POST("/webauthn/login", c.WebAuthnLogin())
+	authV1.GET("/webauthn/credentials", middlewares.AccessToken(cfg), c.WebAuthnCredentials())
+	authV1.DELETE("/webauthn/credentials/:id", middlewares.AccessToken(cfg), c.WebAuthnCredentialDelete())
+
+	authV1.GET("/sessions", middlewares.AccessToken(cfg), c.ListSessions())
+	authV1.DELETE("/sessions", middlewares.AccessToken(cfg), c.DeleteOtherSessions())
+	authV1.DELETE("/sessions/:id", middlewares.AccessToken(cfg), c.DeleteSession())
 }
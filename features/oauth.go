@@ -0,0 +1,28 @@
+package features
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/hexcraft-biz/base-accounts-service/config"
+	"github.com/hexcraft-biz/base-accounts-service/controllers"
+	"github.com/hexcraft-biz/base-accounts-service/middlewares"
+	"github.com/hexcraft-biz/base-accounts-service/misc"
+	"github.com/hexcraft-biz/feature"
+)
+
+func LoadOAuth(e *gin.Engine, cfg config.ConfigInterface) {
+	c := controllers.NewOAuth(cfg)
+
+	oauth := feature.New(e, "/oauth")
+	oauth.Use(middlewares.RateLimit(cfg))
+
+	oauth.POST("/token", c.Token())
+	oauth.POST("/introspect", c.Introspect())
+	oauth.POST("/revoke", c.Revoke())
+
+	if cfg.GetOIDCConfig() != nil {
+		oauth.GET("/authorize", c.Authorize())
+		oauth.POST("/authorize", middlewares.AccessToken(cfg), c.IssueCode())
+		oauth.GET("/userinfo", middlewares.OAuthAccessToken(cfg, misc.SCOPE_OPENID), c.UserInfo())
+		oauth.POST("/userinfo", middlewares.OAuthAccessToken(cfg, misc.SCOPE_OPENID), c.UserInfo())
+	}
+}
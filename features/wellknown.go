@@ -0,0 +1,19 @@
+package features
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/hexcraft-biz/base-accounts-service/config"
+	"github.com/hexcraft-biz/base-accounts-service/controllers"
+	"github.com/hexcraft-biz/feature"
+)
+
+func LoadWellKnown(e *gin.Engine, cfg config.ConfigInterface) {
+	c := controllers.NewWellKnown(cfg)
+
+	wellKnown := feature.New(e, "/.well-known")
+	wellKnown.GET("/jwks.json", c.JWKS())
+
+	if cfg.GetOIDCConfig() != nil {
+		wellKnown.GET("/openid-configuration", c.OpenIDConfiguration())
+	}
+}
@@ -0,0 +1,68 @@
+package jobs
+
+import (
+	"log"
+	"time"
+
+	"github.com/hexcraft-biz/base-accounts-service/config"
+)
+
+type job struct {
+	Name     string
+	Interval time.Duration
+	Run      func() error
+}
+
+type Scheduler struct {
+	jobs []*job
+}
+
+func New(cfg config.ConfigInterface) *Scheduler {
+	s := &Scheduler{}
+
+	// password history
+	s.Every("password_history_prune", time.Hour, PrunePasswordHistory(cfg))
+
+	// login attempts
+	s.Every("login_attempts_clean", time.Hour, CleanLoginAttempts(cfg))
+
+	// sessions
+	s.Every("sessions_clean", time.Hour, CleanSessions(cfg))
+
+	// webauthn
+	s.Every("webauthn_challenges_clean", time.Hour, CleanWebAuthnChallenges(cfg))
+
+	// oauth
+	s.Every("oauth_codes_clean", time.Hour, CleanOAuthCodes(cfg))
+	s.Every("revoked_tokens_clean", time.Hour, CleanRevokedTokens(cfg))
+
+	// email outbox
+	s.Every("email_outbox_deliver", cfg.GetEmailOutboxPolicy().PollInterval(), DeliverEmailOutbox(cfg))
+	s.Every("email_outbox_prune", time.Hour, PruneEmailOutbox(cfg))
+
+	return s
+}
+
+func (s *Scheduler) Every(name string, interval time.Duration, run func() error) {
+	s.jobs = append(s.jobs, &job{
+		Name:     name,
+		Interval: interval,
+		Run:      run,
+	})
+}
+
+// Start runs every registered job in its own goroutine, errors are logged and never stop the ticker.
+func (s *Scheduler) Start() {
+	for _, j := range s.jobs {
+		go func(j *job) {
+			ticker := time.NewTicker(j.Interval)
+			defer ticker.Stop()
+
+			for range ticker.C {
+				if err := j.Run(); err != nil {
+					log.Printf("[jobs] %s: %s", j.Name, err.Error())
+				}
+			}
+		}(j)
+	}
+}
@@ -0,0 +1,70 @@
+package jobs
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/hexcraft-biz/base-accounts-service/config"
+	"github.com/hexcraft-biz/base-accounts-service/models"
+)
+
+// DeliverEmailOutbox sends the due messages of the outbox with a pool of workers. It keeps claiming batches until
+// the queue is drained, a failed message is rescheduled by the backoff of the policy.
+func DeliverEmailOutbox(cfg config.ConfigInterface) func() error {
+	return func() error {
+		policy := cfg.GetEmailOutboxPolicy()
+		outboxEngine := models.NewEmailOutboxTableEngine(cfg.GetDB())
+
+		for {
+			rows, err := outboxEngine.Claim(policy.BatchSize, policy.Lease(), policy.MaxAttempts)
+			if err != nil || len(rows) == 0 {
+				return err
+			}
+
+			queue := make(chan *models.EntityEmailOutbox)
+			var wg sync.WaitGroup
+
+			for i := 0; i < policy.Workers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for o := range queue {
+						deliverEmail(cfg, outboxEngine, o)
+					}
+				}()
+			}
+
+			for _, o := range rows {
+				queue <- o
+			}
+			close(queue)
+			wg.Wait()
+
+			if len(rows) < policy.BatchSize {
+				return nil
+			}
+		}
+	}
+}
+
+func deliverEmail(cfg config.ConfigInterface, outboxEngine *models.EmailOutboxTableEngine, o *models.EntityEmailOutbox) {
+	msg := o.GetMessage()
+	msg.ListUnsubscribe = cfg.GetEmailListUnsubscribe()
+
+	if sendErr := cfg.GetMailer().Send(msg); sendErr != nil {
+		if _, err := outboxEngine.MarkFailed(o, sendErr, cfg.GetEmailOutboxPolicy()); err != nil {
+			log.Printf("[jobs] email_outbox_deliver: %s", err.Error())
+		}
+	} else if _, err := outboxEngine.MarkSent(o); err != nil {
+		log.Printf("[jobs] email_outbox_deliver: %s", err.Error())
+	}
+}
+
+// PruneEmailOutbox removes delivered messages once they are no longer useful for inspection.
+func PruneEmailOutbox(cfg config.ConfigInterface) func() error {
+	return func() error {
+		_, err := models.NewEmailOutboxTableEngine(cfg.GetDB()).DeleteSent(time.Now().Add(-models.EMAIL_OUTBOX_SENT_RETENTION))
+		return err
+	}
+}
@@ -0,0 +1,14 @@
+package jobs
+
+import (
+	"github.com/hexcraft-biz/base-accounts-service/config"
+	"github.com/hexcraft-biz/base-accounts-service/models"
+)
+
+// CleanLoginAttempts removes failure counters that expired and no longer hold a lock.
+func CleanLoginAttempts(cfg config.ConfigInterface) func() error {
+	return func() error {
+		_, err := models.NewLoginAttemptsTableEngine(cfg.GetDB()).DeleteStale(cfg.GetLockoutPolicy())
+		return err
+	}
+}
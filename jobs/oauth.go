@@ -0,0 +1,22 @@
+package jobs
+
+import (
+	"github.com/hexcraft-biz/base-accounts-service/config"
+	"github.com/hexcraft-biz/base-accounts-service/models"
+)
+
+// CleanOAuthCodes removes authorization codes that were never exchanged.
+func CleanOAuthCodes(cfg config.ConfigInterface) func() error {
+	return func() error {
+		_, err := models.NewOAuthCodesTableEngine(cfg.GetDB()).DeleteExpired()
+		return err
+	}
+}
+
+// CleanRevokedTokens forgets revoked JWTs once they expired.
+func CleanRevokedTokens(cfg config.ConfigInterface) func() error {
+	return func() error {
+		_, err := models.NewRevokedTokensTableEngine(cfg.GetDB()).DeleteExpired()
+		return err
+	}
+}
@@ -0,0 +1,14 @@
+package jobs
+
+import (
+	"github.com/hexcraft-biz/base-accounts-service/config"
+	"github.com/hexcraft-biz/base-accounts-service/models"
+)
+
+// PrunePasswordHistory drops history entries that are older than the configured reuse window.
+func PrunePasswordHistory(cfg config.ConfigInterface) func() error {
+	return func() error {
+		_, err := models.NewPasswordHistoryTableEngine(cfg.GetDB()).Prune(cfg.GetPasswordHistorySize())
+		return err
+	}
+}
@@ -0,0 +1,17 @@
+package jobs
+
+import (
+	"time"
+
+	"github.com/hexcraft-biz/base-accounts-service/config"
+	"github.com/hexcraft-biz/base-accounts-service/models"
+)
+
+// CleanSessions removes sessions idle for longer than a refresh token lives, they can not be resumed anymore.
+func CleanSessions(cfg config.ConfigInterface) func() error {
+	return func() error {
+		before := time.Now().Add(-time.Duration(cfg.GetRefreshTokenExpireSecs()) * time.Second)
+		_, err := models.NewSessionsTableEngine(cfg.GetDB()).DeleteIdle(before)
+		return err
+	}
+}
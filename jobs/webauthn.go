@@ -0,0 +1,14 @@
+package jobs
+
+import (
+	"github.com/hexcraft-biz/base-accounts-service/config"
+	"github.com/hexcraft-biz/base-accounts-service/models"
+)
+
+// CleanWebAuthnChallenges removes challenges of ceremonies that were never finished.
+func CleanWebAuthnChallenges(cfg config.ConfigInterface) func() error {
+	return func() error {
+		_, err := models.NewWebAuthnChallengesTableEngine(cfg.GetDB()).DeleteExpired()
+		return err
+	}
+}
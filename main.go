@@ -1,19 +1,48 @@
 package main
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"flag"
+	"fmt"
+	"net/mail"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 
+	"github.com/hexcraft-biz/base-accounts-service/jobs"
+	"github.com/hexcraft-biz/base-accounts-service/misc"
+	"github.com/hexcraft-biz/base-accounts-service/models"
 	"github.com/hexcraft-biz/base-accounts-service/service"
 	"github.com/hexcraft-biz/env"
+	"github.com/hexcraft-biz/env/redis"
 	"github.com/jmoiron/sqlx"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func main() {
+	checkTemplates := flag.Bool("check-templates", false, "validate the email templates and exit")
+	flag.Parse()
+
+	if *checkTemplates {
+		MustNot(CheckTemplates())
+		fmt.Println("Email templates are valid.")
+		return
+	}
+
 	cfg, err := Load()
 	MustNot(err)
+
 	cfg.DBOpen(false)
 
+	if flag.Arg(0) == "import" {
+		MustNot(ImportUsers(cfg, flag.Args()[1:]))
+		return
+	}
+
+	jobs.New(cfg).Start()
 	service.New(cfg).Run(":" + cfg.Env.AppPort)
 }
 
@@ -23,24 +52,152 @@ func MustNot(err error) {
 	}
 }
 
+// CheckTemplates parses and renders the email templates and loads the locale bundles like FetchEnv does, without
+// the rest of the env, so it runs where the database and the secrets are not around.
+func CheckTemplates() error {
+	productName := os.Getenv("PRODUCT_NAME")
+	if productName == "" {
+		productName = DefaultProductName
+	}
+
+	if templates, err := misc.NewEmailTemplates(os.Getenv("EMAIL_TEMPLATES_DIR")); err != nil {
+		return errors.New("Invalid environment variable : EMAIL_TEMPLATES_DIR, " + err.Error())
+	} else if err := templates.Check(productName); err != nil {
+		return errors.New("Invalid environment variable : EMAIL_TEMPLATES_DIR, " + err.Error())
+	}
+
+	_, err := fetchLocalizerEnv(&Env{})
+	return err
+}
+
+// ImportUsers is the command line flavour of POST /admin/v1/users/import.
+// Usage: app import -format jsonl|csv -file <path>
+func ImportUsers(cfg *Config, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", misc.USER_IMPORT_FORMAT_JSONL, "jsonl or csv")
+	file := fs.String("file", "", "path of the file to import, reads stdin when empty")
+	fs.Parse(args)
+
+	in := os.Stdin
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	records, err := misc.ParseUserImport(in, *format)
+	if err != nil {
+		return err
+	}
+
+	created, enc := 0, json.NewEncoder(os.Stdout)
+	for _, r := range models.NewUsersTableEngine(cfg.DB).Import(records) {
+		if r.Result == misc.USER_IMPORT_RESULT_CREATED {
+			created += 1
+		}
+		enc.Encode(r)
+	}
+
+	fmt.Fprintf(os.Stderr, "total: %d, created: %d, failed: %d\n", len(records), created, len(records)-created)
+	return nil
+}
+
+func FetchOptIntEnv(envStr string) (value int, exist bool, err error) {
+	if envStr != "" {
+		exist = true
+		if intVal, err := strconv.Atoi(envStr); err != nil {
+			return value, exist, err
+		} else {
+			value = intVal
+		}
+	} else {
+		exist = false
+	}
+
+	return value, exist, nil
+}
+
+func FetchOptBoolEnv(envStr string) (value bool, exist bool, err error) {
+	if envStr != "" {
+		exist = true
+		if boolVal, err := strconv.ParseBool(envStr); err != nil {
+			return value, exist, err
+		} else {
+			value = boolVal
+		}
+	} else {
+		exist = false
+	}
+
+	return value, exist, nil
+}
+
 // ================================================================
 // Env
 // ================================================================
+const (
+	DefaultAccessTokenExpireSecs  = 900
+	DefaultRefreshTokenExpireSecs = 2592000
+	DefaultPasswordHistorySize    = 5
+	DefaultTOTPIssuer             = "base-accounts-service"
+	DefaultWebAuthnRPName         = "base-accounts-service"
+	DefaultProductName            = "base-accounts-service"
+)
+
 type Env struct {
 	*env.Prototype
-	JWTSecret              []byte
-	SMTPHost               string
-	SMTPPort               string
-	SMTPUsername           string
-	SMTPPassword           string
-	SMTPSender             string
-	SMTPSenderName         string
-	SignupEmailSubject     string
-	SignupEmailContent     string
-	SignupEmailLinkText    string
-	ForgetPwdEmailSubject  string
-	ForgetPwdEmailContent  string
-	ForgetPwdEmailLinkText string
+	JWTKeyset                   *misc.JWTKeyset
+	MailTransport               string
+	Mailer                      misc.Mailer
+	SMTPHost                    string
+	SMTPPort                    string
+	SMTPUsername                string
+	SMTPPassword                string
+	SMTPSender                  string
+	SMTPSenderName              string
+	SignupEmailSubject          string
+	SignupEmailContent          string
+	SignupEmailLinkText         string
+	ForgetPwdEmailSubject       string
+	ForgetPwdEmailContent       string
+	ForgetPwdEmailLinkText      string
+	MagicLinkEmailSubject       string
+	MagicLinkEmailContent       string
+	MagicLinkEmailLinkText      string
+	UnlockPageURL               string
+	UnlockEmailSubject          string
+	UnlockEmailContent          string
+	UnlockEmailLinkText         string
+	PrivacyMode                 bool
+	AccountExistsEmailSubject   string
+	AccountExistsEmailContent   string
+	NoAccountEmailSubject       string
+	NoAccountEmailContent       string
+	AccountDisabledEmailSubject string
+	AccountDisabledEmailContent string
+	AccessTokenExpireSecs       int
+	RefreshTokenExpireSecs      int
+	PasswordPolicy              *misc.PasswordPolicy
+	PasswordHistorySize         int
+	PasswordHasher              misc.PasswordHasher
+	AdminAPIKey                 string
+	AdminIdentities             map[string]bool
+	TOTPIssuer                  string
+	WebAuthnConfig              *misc.WebAuthnConfig
+	OIDCConfig                  *misc.OIDCConfig
+	RequireClientToken          bool
+	LockoutPolicy               *misc.LockoutPolicy
+	EmailOutboxPolicy           *misc.EmailOutboxPolicy
+	EmailListUnsubscribe        string
+	RateLimitRules              []*misc.RateLimitRule
+	RateLimitStore              misc.RateLimitStore
+	URLAllowlist                *misc.URLAllowlist
+	Localizer                   *misc.Localizer
+	ProductName                 string
+	EmailTemplates              *misc.EmailTemplates
 }
 
 func FetchEnv() (*Env, error) {
@@ -52,37 +209,50 @@ func FetchEnv() (*Env, error) {
 			Prototype: e,
 		}
 
-		if os.Getenv("JWT_SECRET") != "" {
-			env.JWTSecret = []byte(os.Getenv("JWT_SECRET"))
-		} else {
-			return nil, errors.New("Invalid environment variable : JWT_SECRET")
+		if env.JWTKeyset, err = fetchJWTKeysetEnv(); err != nil {
+			return nil, err
 		}
 
-		if os.Getenv("SMTP_HOST") != "" {
-			env.SMTPHost = os.Getenv("SMTP_HOST")
-		} else {
-			return nil, errors.New("Invalid environment variable : SMTP_HOST")
+		if env.MailTransport = os.Getenv("MAIL_TRANSPORT"); env.MailTransport == "" {
+			env.MailTransport = misc.MAIL_TRANSPORT_SMTP
 		}
 
-		if os.Getenv("SMTP_PORT") != "" {
-			env.SMTPPort = os.Getenv("SMTP_PORT")
-		} else {
-			return nil, errors.New("Invalid environment variable : SMTP_PORT")
-		}
+		switch env.MailTransport {
+		case misc.MAIL_TRANSPORT_SMTP:
+			if os.Getenv("SMTP_HOST") != "" {
+				env.SMTPHost = os.Getenv("SMTP_HOST")
+			} else {
+				return nil, errors.New("Invalid environment variable : SMTP_HOST")
+			}
 
-		if os.Getenv("SMTP_USERNAME") != "" {
+			if os.Getenv("SMTP_PORT") != "" {
+				env.SMTPPort = os.Getenv("SMTP_PORT")
+			} else {
+				return nil, errors.New("Invalid environment variable : SMTP_PORT")
+			}
+
+			// No AUTH without a username, e.g. a relay on the private network.
 			env.SMTPUsername = os.Getenv("SMTP_USERNAME")
-		} else {
-			return nil, errors.New("Invalid environment variable : SMTP_USERNAME")
-		}
+			if env.SMTPPassword = os.Getenv("SMTP_PASSWORD"); env.SMTPUsername != "" && env.SMTPPassword == "" {
+				return nil, errors.New("Invalid environment variable : SMTP_PASSWORD")
+			}
 
-		if os.Getenv("SMTP_PASSWORD") != "" {
-			env.SMTPPassword = os.Getenv("SMTP_PASSWORD")
-		} else {
-			return nil, errors.New("Invalid environment variable : SMTP_PASSWORD")
+			if env.Mailer, err = fetchSMTPMailerEnv(env); err != nil {
+				return nil, err
+			}
+		case misc.MAIL_TRANSPORT_FILE:
+			if os.Getenv("MAIL_FILE_DIR") == "" {
+				return nil, errors.New("Invalid environment variable : MAIL_FILE_DIR")
+			} else if env.Mailer, err = misc.NewFileMailer(os.Getenv("MAIL_FILE_DIR")); err != nil {
+				return nil, errors.New("Invalid environment variable : MAIL_FILE_DIR, " + err.Error())
+			}
+		case misc.MAIL_TRANSPORT_LOG:
+			env.Mailer = misc.NewLogMailer()
+		default:
+			return nil, errors.New("Invalid environment variable : MAIL_TRANSPORT")
 		}
 
-		if os.Getenv("SMTP_SENDER") != "" {
+		if addr, err := mail.ParseAddress(os.Getenv("SMTP_SENDER")); err == nil && addr.Address == os.Getenv("SMTP_SENDER") {
 			env.SMTPSender = os.Getenv("SMTP_SENDER")
 		} else {
 			return nil, errors.New("Invalid environment variable : SMTP_SENDER")
@@ -130,10 +300,593 @@ func FetchEnv() (*Env, error) {
 			return nil, errors.New("Invalid environment variable : FORGET_PWD_LINK_TEXT")
 		}
 
+		if env.MagicLinkEmailSubject = os.Getenv("MAGIC_LINK_EMAIL_SUBJECT"); env.MagicLinkEmailSubject == "" {
+			env.MagicLinkEmailSubject = misc.DefaultMagicLinkEmailSubject
+		}
+
+		if env.MagicLinkEmailContent = os.Getenv("MAGIC_LINK_EMAIL_CONTENT"); env.MagicLinkEmailContent == "" {
+			env.MagicLinkEmailContent = misc.DefaultMagicLinkEmailContent
+		}
+
+		if env.MagicLinkEmailLinkText = os.Getenv("MAGIC_LINK_LINK_TEXT"); env.MagicLinkEmailLinkText == "" {
+			env.MagicLinkEmailLinkText = misc.DefaultMagicLinkEmailLinkText
+		}
+
+		// Without an unlock page no unlock email is sent, locks then only expire.
+		if env.UnlockPageURL = os.Getenv("UNLOCK_PAGE_URL"); env.UnlockPageURL != "" {
+			if _, err := url.ParseRequestURI(env.UnlockPageURL); err != nil {
+				return nil, errors.New("Invalid environment variable : UNLOCK_PAGE_URL")
+			}
+		}
+
+		if env.UnlockEmailSubject = os.Getenv("UNLOCK_EMAIL_SUBJECT"); env.UnlockEmailSubject == "" {
+			env.UnlockEmailSubject = misc.DefaultUnlockEmailSubject
+		}
+
+		if env.UnlockEmailContent = os.Getenv("UNLOCK_EMAIL_CONTENT"); env.UnlockEmailContent == "" {
+			env.UnlockEmailContent = misc.DefaultUnlockEmailContent
+		}
+
+		if env.UnlockEmailLinkText = os.Getenv("UNLOCK_LINK_TEXT"); env.UnlockEmailLinkText == "" {
+			env.UnlockEmailLinkText = misc.DefaultUnlockEmailLinkText
+		}
+
+		if value, exist, err := FetchOptBoolEnv(os.Getenv("PRIVACY_MODE")); err != nil {
+			return nil, errors.New("Invalid environment variable : PRIVACY_MODE")
+		} else if exist {
+			env.PrivacyMode = value
+		}
+
+		// The notices replace the errors that would reveal an account, so they are only needed in privacy mode.
+		if env.PrivacyMode {
+			if os.Getenv("ACCOUNT_EXISTS_EMAIL_SUBJECT") != "" {
+				env.AccountExistsEmailSubject = os.Getenv("ACCOUNT_EXISTS_EMAIL_SUBJECT")
+			} else {
+				return nil, errors.New("Invalid environment variable : ACCOUNT_EXISTS_EMAIL_SUBJECT")
+			}
+
+			if os.Getenv("ACCOUNT_EXISTS_EMAIL_CONTENT") != "" {
+				env.AccountExistsEmailContent = os.Getenv("ACCOUNT_EXISTS_EMAIL_CONTENT")
+			} else {
+				return nil, errors.New("Invalid environment variable : ACCOUNT_EXISTS_EMAIL_CONTENT")
+			}
+
+			if os.Getenv("NO_ACCOUNT_EMAIL_SUBJECT") != "" {
+				env.NoAccountEmailSubject = os.Getenv("NO_ACCOUNT_EMAIL_SUBJECT")
+			} else {
+				return nil, errors.New("Invalid environment variable : NO_ACCOUNT_EMAIL_SUBJECT")
+			}
+
+			if os.Getenv("NO_ACCOUNT_EMAIL_CONTENT") != "" {
+				env.NoAccountEmailContent = os.Getenv("NO_ACCOUNT_EMAIL_CONTENT")
+			} else {
+				return nil, errors.New("Invalid environment variable : NO_ACCOUNT_EMAIL_CONTENT")
+			}
+
+			if env.AccountDisabledEmailSubject = os.Getenv("ACCOUNT_DISABLED_EMAIL_SUBJECT"); env.AccountDisabledEmailSubject == "" {
+				env.AccountDisabledEmailSubject = misc.DefaultAccountDisabledEmailSubject
+			}
+
+			if env.AccountDisabledEmailContent = os.Getenv("ACCOUNT_DISABLED_EMAIL_CONTENT"); env.AccountDisabledEmailContent == "" {
+				env.AccountDisabledEmailContent = misc.DefaultAccountDisabledEmailContent
+			}
+		}
+
+		env.AccessTokenExpireSecs = DefaultAccessTokenExpireSecs
+		if value, exist, err := FetchOptIntEnv(os.Getenv("ACCESS_TOKEN_EXPIRE_SECS")); err != nil || (exist && value <= 0) {
+			return nil, errors.New("Invalid environment variable : ACCESS_TOKEN_EXPIRE_SECS")
+		} else if exist {
+			env.AccessTokenExpireSecs = value
+		}
+
+		env.RefreshTokenExpireSecs = DefaultRefreshTokenExpireSecs
+		if value, exist, err := FetchOptIntEnv(os.Getenv("REFRESH_TOKEN_EXPIRE_SECS")); err != nil || (exist && value <= 0) {
+			return nil, errors.New("Invalid environment variable : REFRESH_TOKEN_EXPIRE_SECS")
+		} else if exist {
+			env.RefreshTokenExpireSecs = value
+		}
+
+		if env.PasswordPolicy, err = fetchPasswordPolicyEnv(); err != nil {
+			return nil, err
+		}
+
+		env.PasswordHistorySize = DefaultPasswordHistorySize
+		if value, exist, err := FetchOptIntEnv(os.Getenv("PWD_HISTORY_SIZE")); err != nil || (exist && value < 0) {
+			return nil, errors.New("Invalid environment variable : PWD_HISTORY_SIZE")
+		} else if exist {
+			env.PasswordHistorySize = value
+		}
+
+		if env.PasswordHasher, err = fetchPasswordHasherEnv(); err != nil {
+			return nil, err
+		}
+
+		if err := fetchFirebaseScryptEnv(); err != nil {
+			return nil, err
+		}
+
+		env.AdminAPIKey = os.Getenv("ADMIN_API_KEY")
+
+		env.AdminIdentities = map[string]bool{}
+		for _, identity := range strings.Split(os.Getenv("ADMIN_IDENTITIES"), ",") {
+			if identity = strings.ToLower(strings.TrimSpace(identity)); identity != "" {
+				env.AdminIdentities[identity] = true
+			}
+		}
+
+		if env.TOTPIssuer = os.Getenv("TOTP_ISSUER"); env.TOTPIssuer == "" {
+			env.TOTPIssuer = DefaultTOTPIssuer
+		}
+
+		if env.WebAuthnConfig, err = fetchWebAuthnEnv(e, env.JWTKeyset); err != nil {
+			return nil, err
+		}
+
+		if env.OIDCConfig, err = fetchOIDCEnv(e, env.JWTKeyset); err != nil {
+			return nil, err
+		}
+
+		env.RequireClientToken = true
+		if value, exist, err := FetchOptBoolEnv(os.Getenv("REQUIRE_CLIENT_TOKEN")); err != nil {
+			return nil, errors.New("Invalid environment variable : REQUIRE_CLIENT_TOKEN")
+		} else if exist {
+			env.RequireClientToken = value
+		}
+
+		if env.LockoutPolicy, err = fetchLockoutPolicyEnv(); err != nil {
+			return nil, err
+		}
+
+		if env.EmailOutboxPolicy, err = fetchEmailOutboxPolicyEnv(); err != nil {
+			return nil, err
+		}
+
+		if env.EmailListUnsubscribe = os.Getenv("EMAIL_LIST_UNSUBSCRIBE"); env.EmailListUnsubscribe != "" {
+			if u, err := url.Parse(env.EmailListUnsubscribe); err != nil || (u.Scheme != "mailto" && u.Scheme != "https") {
+				return nil, errors.New("Invalid environment variable : EMAIL_LIST_UNSUBSCRIBE")
+			}
+		}
+
+		if env.RateLimitRules, env.RateLimitStore, err = fetchRateLimitEnv(); err != nil {
+			return nil, err
+		}
+
+		if env.URLAllowlist, err = misc.NewURLAllowlist(strings.Split(os.Getenv("ALLOWED_URLS"), ",")); err != nil || env.URLAllowlist.IsEmpty() {
+			return nil, errors.New("Invalid environment variable : ALLOWED_URLS")
+		}
+
+		if env.Localizer, err = fetchLocalizerEnv(env); err != nil {
+			return nil, err
+		}
+
+		if env.ProductName = os.Getenv("PRODUCT_NAME"); env.ProductName == "" {
+			env.ProductName = DefaultProductName
+		}
+
+		if env.EmailTemplates, err = misc.NewEmailTemplates(os.Getenv("EMAIL_TEMPLATES_DIR")); err != nil {
+			return nil, errors.New("Invalid environment variable : EMAIL_TEMPLATES_DIR, " + err.Error())
+		} else if err := env.EmailTemplates.Check(env.ProductName); err != nil {
+			return nil, errors.New("Invalid environment variable : EMAIL_TEMPLATES_DIR, " + err.Error())
+		}
+
 		return env, nil
 	}
 }
 
+// fetchJWTKeysetEnv loads the PEM keys of JWT_KEY_FILES and JWT_KEYS_DIR. Without any key tokens are signed with
+// JWT_SECRET, with keys JWT_SECRET only verifies the tokens it signed before.
+func fetchJWTKeysetEnv() (*misc.JWTKeyset, error) {
+	keys := []*misc.JWTKey{}
+	for _, path := range strings.Split(os.Getenv("JWT_KEY_FILES"), ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		} else if key, err := misc.LoadJWTKeyFile(path); err != nil {
+			return nil, errors.New("Invalid environment variable : JWT_KEY_FILES, " + path + " : " + err.Error())
+		} else {
+			keys = append(keys, key)
+		}
+	}
+
+	if dir := os.Getenv("JWT_KEYS_DIR"); dir != "" {
+		if dirKeys, err := misc.LoadJWTKeyDir(dir); err != nil {
+			return nil, errors.New("Invalid environment variable : JWT_KEYS_DIR, " + err.Error())
+		} else {
+			keys = append(keys, dirKeys...)
+		}
+	}
+
+	var secret []byte
+	if os.Getenv("JWT_SECRET") != "" {
+		secret = []byte(os.Getenv("JWT_SECRET"))
+	} else if len(keys) == 0 {
+		return nil, errors.New("Invalid environment variable : JWT_SECRET")
+	}
+
+	keyset, err := misc.NewJWTKeyset(keys, os.Getenv("JWT_SIGNING_KID"), secret)
+	if err != nil {
+		return nil, errors.New("Invalid JWT keyset : " + err.Error())
+	}
+
+	return keyset, nil
+}
+
+// fetchLocalizerEnv loads the locale bundles, the email env is the last fallback of every locale.
+func fetchLocalizerEnv(env *Env) (*misc.Localizer, error) {
+	defaultLocale := os.Getenv("DEFAULT_LOCALE")
+	if defaultLocale == "" {
+		defaultLocale = misc.DefaultLocale
+	}
+
+	localizer, err := misc.NewLocalizer(defaultLocale, map[string]misc.EmailTexts{
+		misc.EMAIL_TYPE_SIGN_UP:          {Subject: env.SignupEmailSubject, Content: env.SignupEmailContent, LinkText: env.SignupEmailLinkText},
+		misc.EMAIL_TYPE_FORGET_PWD:       {Subject: env.ForgetPwdEmailSubject, Content: env.ForgetPwdEmailContent, LinkText: env.ForgetPwdEmailLinkText},
+		misc.EMAIL_TYPE_MAGIC_LOGIN:      {Subject: env.MagicLinkEmailSubject, Content: env.MagicLinkEmailContent, LinkText: env.MagicLinkEmailLinkText},
+		misc.EMAIL_TYPE_UNLOCK:           {Subject: env.UnlockEmailSubject, Content: env.UnlockEmailContent, LinkText: env.UnlockEmailLinkText},
+		misc.EMAIL_TYPE_ACCOUNT_EXISTS:   {Subject: env.AccountExistsEmailSubject, Content: env.AccountExistsEmailContent},
+		misc.EMAIL_TYPE_NO_ACCOUNT:       {Subject: env.NoAccountEmailSubject, Content: env.NoAccountEmailContent},
+		misc.EMAIL_TYPE_ACCOUNT_DISABLED: {Subject: env.AccountDisabledEmailSubject, Content: env.AccountDisabledEmailContent},
+	})
+	if err != nil {
+		return nil, errors.New("Invalid environment variable : DEFAULT_LOCALE")
+	}
+
+	if dir := os.Getenv("LOCALES_DIR"); dir != "" {
+		if err := localizer.LoadDir(dir); err != nil {
+			return nil, errors.New("Invalid environment variable : LOCALES_DIR, " + err.Error())
+		}
+	}
+
+	return localizer, nil
+}
+
+func fetchPasswordPolicyEnv() (*misc.PasswordPolicy, error) {
+	policy := misc.NewPasswordPolicy()
+
+	if value, exist, err := FetchOptIntEnv(os.Getenv("PWD_MIN_LENGTH")); err != nil || (exist && value <= 0) {
+		return nil, errors.New("Invalid environment variable : PWD_MIN_LENGTH")
+	} else if exist {
+		policy.MinLength = value
+	}
+
+	if value, exist, err := FetchOptBoolEnv(os.Getenv("PWD_REQUIRE_UPPER")); err != nil {
+		return nil, errors.New("Invalid environment variable : PWD_REQUIRE_UPPER")
+	} else if exist {
+		policy.RequireUpper = value
+	}
+
+	if value, exist, err := FetchOptBoolEnv(os.Getenv("PWD_REQUIRE_LOWER")); err != nil {
+		return nil, errors.New("Invalid environment variable : PWD_REQUIRE_LOWER")
+	} else if exist {
+		policy.RequireLower = value
+	}
+
+	if value, exist, err := FetchOptBoolEnv(os.Getenv("PWD_REQUIRE_DIGIT")); err != nil {
+		return nil, errors.New("Invalid environment variable : PWD_REQUIRE_DIGIT")
+	} else if exist {
+		policy.RequireDigit = value
+	}
+
+	if value, exist, err := FetchOptBoolEnv(os.Getenv("PWD_REQUIRE_SYMBOL")); err != nil {
+		return nil, errors.New("Invalid environment variable : PWD_REQUIRE_SYMBOL")
+	} else if exist {
+		policy.RequireSymbol = value
+	}
+
+	if value, exist, err := FetchOptIntEnv(os.Getenv("PWD_MAX_REPEATED_CHARS")); err != nil || (exist && value < 0) {
+		return nil, errors.New("Invalid environment variable : PWD_MAX_REPEATED_CHARS")
+	} else if exist {
+		policy.MaxRepeatedChars = value
+	}
+
+	if path := os.Getenv("PWD_BLOCKED_WORDS_FILE"); path != "" {
+		if err := policy.LoadBlockedWords(path); err != nil {
+			return nil, errors.New("Invalid environment variable : PWD_BLOCKED_WORDS_FILE, " + err.Error())
+		}
+	}
+
+	return policy, nil
+}
+
+func fetchPasswordHasherEnv() (misc.PasswordHasher, error) {
+	switch algo := os.Getenv("PWD_HASH_ALGORITHM"); algo {
+	case "", misc.PWD_ALGO_BCRYPT:
+		cost := bcrypt.DefaultCost
+		if value, exist, err := FetchOptIntEnv(os.Getenv("PWD_BCRYPT_COST")); err != nil || (exist && (value < bcrypt.MinCost || value > bcrypt.MaxCost)) {
+			return nil, errors.New("Invalid environment variable : PWD_BCRYPT_COST")
+		} else if exist {
+			cost = value
+		}
+		return misc.NewBcryptHasher(cost), nil
+
+	case misc.PWD_ALGO_ARGON2ID:
+		h := misc.NewArgon2idHasher(misc.DefaultArgon2MemoryKB, misc.DefaultArgon2Iterations, misc.DefaultArgon2Parallelism)
+		if value, exist, err := FetchOptIntEnv(os.Getenv("PWD_ARGON2_MEMORY_KB")); err != nil || (exist && value <= 0) {
+			return nil, errors.New("Invalid environment variable : PWD_ARGON2_MEMORY_KB")
+		} else if exist {
+			h.MemoryKB = uint32(value)
+		}
+		if value, exist, err := FetchOptIntEnv(os.Getenv("PWD_ARGON2_ITERATIONS")); err != nil || (exist && value <= 0) {
+			return nil, errors.New("Invalid environment variable : PWD_ARGON2_ITERATIONS")
+		} else if exist {
+			h.Iterations = uint32(value)
+		}
+		if value, exist, err := FetchOptIntEnv(os.Getenv("PWD_ARGON2_PARALLELISM")); err != nil || (exist && (value <= 0 || value > 255)) {
+			return nil, errors.New("Invalid environment variable : PWD_ARGON2_PARALLELISM")
+		} else if exist {
+			h.Parallelism = uint8(value)
+		}
+		if err := h.Validate(); err != nil {
+			return nil, errors.New("Invalid environment variables : PWD_ARGON2_* exceed the supported bounds")
+		}
+		return h, nil
+
+	case misc.PWD_ALGO_SCRYPT:
+		h := misc.NewScryptHasher(misc.DefaultScryptLogN, misc.DefaultScryptR, misc.DefaultScryptP)
+		if value, exist, err := FetchOptIntEnv(os.Getenv("PWD_SCRYPT_LN")); err != nil || (exist && (value <= 0 || value >= 32)) {
+			return nil, errors.New("Invalid environment variable : PWD_SCRYPT_LN")
+		} else if exist {
+			h.LogN = value
+		}
+		if value, exist, err := FetchOptIntEnv(os.Getenv("PWD_SCRYPT_R")); err != nil || (exist && value <= 0) {
+			return nil, errors.New("Invalid environment variable : PWD_SCRYPT_R")
+		} else if exist {
+			h.R = value
+		}
+		if value, exist, err := FetchOptIntEnv(os.Getenv("PWD_SCRYPT_P")); err != nil || (exist && value <= 0) {
+			return nil, errors.New("Invalid environment variable : PWD_SCRYPT_P")
+		} else if exist {
+			h.P = value
+		}
+		if err := h.Validate(); err != nil {
+			return nil, errors.New("Invalid environment variables : PWD_SCRYPT_* exceed the supported bounds")
+		}
+		return h, nil
+
+	default:
+		return nil, errors.New("Invalid environment variable : PWD_HASH_ALGORITHM")
+	}
+}
+
+// fetchFirebaseScryptEnv registers the verifier of imported Firebase users, it needs the hash config of the export.
+func fetchFirebaseScryptEnv() error {
+	if os.Getenv("FIREBASE_SCRYPT_SIGNER_KEY") == "" {
+		return nil
+	}
+
+	signerKey, err := base64.StdEncoding.DecodeString(os.Getenv("FIREBASE_SCRYPT_SIGNER_KEY"))
+	if err != nil {
+		return errors.New("Invalid environment variable : FIREBASE_SCRYPT_SIGNER_KEY")
+	}
+
+	saltSeparator, err := base64.StdEncoding.DecodeString(os.Getenv("FIREBASE_SCRYPT_SALT_SEPARATOR"))
+	if err != nil {
+		return errors.New("Invalid environment variable : FIREBASE_SCRYPT_SALT_SEPARATOR")
+	}
+
+	rounds, err := strconv.Atoi(os.Getenv("FIREBASE_SCRYPT_ROUNDS"))
+	if err != nil || rounds <= 0 {
+		return errors.New("Invalid environment variable : FIREBASE_SCRYPT_ROUNDS")
+	}
+
+	memCost, err := strconv.Atoi(os.Getenv("FIREBASE_SCRYPT_MEM_COST"))
+	if err != nil || memCost <= 0 || memCost >= 32 {
+		return errors.New("Invalid environment variable : FIREBASE_SCRYPT_MEM_COST")
+	}
+
+	misc.RegisterPasswordHasher(misc.NewFirebaseScryptHasher(signerKey, saltSeparator, rounds, memCost))
+	return nil
+}
+
+func fetchWebAuthnEnv(e *env.Prototype, keyset *misc.JWTKeyset) (*misc.WebAuthnConfig, error) {
+	cfg := &misc.WebAuthnConfig{
+		RPID:        e.AppHost,
+		RPName:      DefaultWebAuthnRPName,
+		Origins:     []string{"https://" + e.AppHost},
+		Attestation: misc.WEBAUTHN_ATTESTATION_NONE,
+	}
+
+	if os.Getenv("WEBAUTHN_RP_ID") != "" {
+		cfg.RPID = os.Getenv("WEBAUTHN_RP_ID")
+	}
+
+	if os.Getenv("WEBAUTHN_RP_NAME") != "" {
+		cfg.RPName = os.Getenv("WEBAUTHN_RP_NAME")
+	}
+
+	if os.Getenv("WEBAUTHN_ORIGINS") != "" {
+		cfg.Origins = []string{}
+		for _, origin := range strings.Split(os.Getenv("WEBAUTHN_ORIGINS"), ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				cfg.Origins = append(cfg.Origins, origin)
+			}
+		}
+	}
+
+	switch os.Getenv("WEBAUTHN_ATTESTATION") {
+	case "":
+	case misc.WEBAUTHN_ATTESTATION_NONE, misc.WEBAUTHN_ATTESTATION_DIRECT:
+		cfg.Attestation = os.Getenv("WEBAUTHN_ATTESTATION")
+	default:
+		return nil, errors.New("Invalid environment variable : WEBAUTHN_ATTESTATION")
+	}
+
+	if cfg.RPID == "" {
+		return nil, errors.New("Invalid environment variable : WEBAUTHN_RP_ID")
+	}
+
+	if secret, err := keyset.DeriveSecret("webauthn-decoy"); err != nil {
+		return nil, errors.New("Invalid JWT keyset : " + err.Error())
+	} else {
+		cfg.DecoySecret = secret
+	}
+
+	return cfg, nil
+}
+
+// fetchOIDCEnv enables the OpenID Connect provider when OIDC_LOGIN_PAGE_URL is set. ID tokens are verified by the
+// clients with the JWKS, so a key of JWT_KEY_FILES or JWT_KEYS_DIR has to sign.
+func fetchOIDCEnv(e *env.Prototype, keyset *misc.JWTKeyset) (*misc.OIDCConfig, error) {
+	if os.Getenv("OIDC_LOGIN_PAGE_URL") == "" {
+		return nil, nil
+	}
+
+	cfg := &misc.OIDCConfig{
+		Issuer:       strings.TrimSuffix(e.GetAppRootURL(), "/"),
+		LoginPageURL: os.Getenv("OIDC_LOGIN_PAGE_URL"),
+	}
+
+	if u, err := url.Parse(cfg.LoginPageURL); err != nil || !u.IsAbs() {
+		return nil, errors.New("Invalid environment variable : OIDC_LOGIN_PAGE_URL")
+	}
+
+	if os.Getenv("OIDC_ISSUER") != "" {
+		if u, err := url.Parse(os.Getenv("OIDC_ISSUER")); err != nil || u.Scheme != "https" || u.RawQuery != "" || u.Fragment != "" {
+			return nil, errors.New("Invalid environment variable : OIDC_ISSUER")
+		}
+		cfg.Issuer = strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/")
+	}
+
+	if keyset.Signer.Kid == "" {
+		return nil, errors.New("Invalid environment variable : OIDC_LOGIN_PAGE_URL, requires JWT_KEY_FILES or JWT_KEYS_DIR")
+	}
+
+	return cfg, nil
+}
+
+func fetchLockoutPolicyEnv() (*misc.LockoutPolicy, error) {
+	policy := misc.NewLockoutPolicy()
+
+	if value, exist, err := FetchOptIntEnv(os.Getenv("LOGIN_LOCKOUT_THRESHOLD")); err != nil || (exist && value < 0) {
+		return nil, errors.New("Invalid environment variable : LOGIN_LOCKOUT_THRESHOLD")
+	} else if exist {
+		policy.Threshold = value
+	}
+
+	if value, exist, err := FetchOptIntEnv(os.Getenv("LOGIN_LOCKOUT_BASE_SECS")); err != nil || (exist && value <= 0) {
+		return nil, errors.New("Invalid environment variable : LOGIN_LOCKOUT_BASE_SECS")
+	} else if exist {
+		policy.BaseSecs = value
+	}
+
+	if value, exist, err := FetchOptIntEnv(os.Getenv("LOGIN_LOCKOUT_MAX_SECS")); err != nil || (exist && value < policy.BaseSecs) {
+		return nil, errors.New("Invalid environment variable : LOGIN_LOCKOUT_MAX_SECS")
+	} else if exist {
+		policy.MaxSecs = value
+	}
+
+	if value, exist, err := FetchOptIntEnv(os.Getenv("LOGIN_LOCKOUT_RESET_SECS")); err != nil || (exist && value <= 0) {
+		return nil, errors.New("Invalid environment variable : LOGIN_LOCKOUT_RESET_SECS")
+	} else if exist {
+		policy.ResetSecs = value
+	}
+
+	return policy, nil
+}
+
+func fetchSMTPMailerEnv(env *Env) (*misc.Email, error) {
+	email := misc.NewEmail(env.SMTPHost, env.SMTPPort, env.SMTPUsername, env.SMTPPassword)
+
+	if mode := os.Getenv("SMTP_TLS_MODE"); mode != "" {
+		if !misc.IsValidSMTPTLSMode(mode) {
+			return nil, errors.New("Invalid environment variable : SMTP_TLS_MODE")
+		}
+		email.TLSMode = mode
+	}
+
+	// PLAIN refuses to send credentials over a plain text connection, so the none mode goes without them.
+	if email.TLSMode == misc.SMTP_TLS_MODE_NONE && email.Username != "" {
+		return nil, errors.New("Invalid environment variable : SMTP_USERNAME, SMTP_TLS_MODE=none can't authenticate")
+	}
+
+	skipVerify, _, err := FetchOptBoolEnv(os.Getenv("SMTP_TLS_SKIP_VERIFY"))
+	if err != nil {
+		return nil, errors.New("Invalid environment variable : SMTP_TLS_SKIP_VERIFY")
+	}
+
+	if email.TLSConfig, err = misc.NewSMTPTLSConfig(env.SMTPHost, os.Getenv("SMTP_TLS_CA_FILE"), skipVerify); err != nil {
+		return nil, errors.New("Invalid environment variable : SMTP_TLS_CA_FILE, " + err.Error())
+	}
+
+	if keyFile := os.Getenv("DKIM_PRIVATE_KEY_FILE"); keyFile != "" {
+		if os.Getenv("DKIM_DOMAIN") == "" {
+			return nil, errors.New("Invalid environment variable : DKIM_DOMAIN")
+		} else if os.Getenv("DKIM_SELECTOR") == "" {
+			return nil, errors.New("Invalid environment variable : DKIM_SELECTOR")
+		} else if email.DKIM, err = misc.NewDKIMSigner(os.Getenv("DKIM_DOMAIN"), os.Getenv("DKIM_SELECTOR"), keyFile); err != nil {
+			return nil, errors.New("Invalid environment variable : DKIM_PRIVATE_KEY_FILE, " + err.Error())
+		}
+	}
+
+	return email, nil
+}
+
+func fetchEmailOutboxPolicyEnv() (*misc.EmailOutboxPolicy, error) {
+	policy := misc.NewEmailOutboxPolicy()
+
+	if value, exist, err := FetchOptIntEnv(os.Getenv("EMAIL_OUTBOX_WORKERS")); err != nil || (exist && value <= 0) {
+		return nil, errors.New("Invalid environment variable : EMAIL_OUTBOX_WORKERS")
+	} else if exist {
+		policy.Workers = value
+	}
+
+	if value, exist, err := FetchOptIntEnv(os.Getenv("EMAIL_OUTBOX_MAX_ATTEMPTS")); err != nil || (exist && value <= 0) {
+		return nil, errors.New("Invalid environment variable : EMAIL_OUTBOX_MAX_ATTEMPTS")
+	} else if exist {
+		policy.MaxAttempts = value
+	}
+
+	if value, exist, err := FetchOptIntEnv(os.Getenv("EMAIL_OUTBOX_BASE_SECS")); err != nil || (exist && value <= 0) {
+		return nil, errors.New("Invalid environment variable : EMAIL_OUTBOX_BASE_SECS")
+	} else if exist {
+		policy.BaseSecs = value
+	}
+
+	if value, exist, err := FetchOptIntEnv(os.Getenv("EMAIL_OUTBOX_MAX_SECS")); err != nil || (exist && value < policy.BaseSecs) {
+		return nil, errors.New("Invalid environment variable : EMAIL_OUTBOX_MAX_SECS")
+	} else if exist {
+		policy.MaxSecs = value
+	}
+
+	if value, exist, err := FetchOptIntEnv(os.Getenv("EMAIL_OUTBOX_POLL_SECS")); err != nil || (exist && value <= 0) {
+		return nil, errors.New("Invalid environment variable : EMAIL_OUTBOX_POLL_SECS")
+	} else if exist {
+		policy.PollSecs = value
+	}
+
+	if value, exist, err := FetchOptIntEnv(os.Getenv("EMAIL_OUTBOX_LEASE_SECS")); err != nil || (exist && value <= policy.MinLeaseSecs()) {
+		return nil, errors.New("Invalid environment variable : EMAIL_OUTBOX_LEASE_SECS")
+	} else if exist {
+		policy.LeaseSecs = value
+	}
+
+	return policy, nil
+}
+
+func fetchRateLimitEnv() ([]*misc.RateLimitRule, misc.RateLimitStore, error) {
+	rulesStr, exist := os.LookupEnv("RATE_LIMIT_RULES")
+	if !exist {
+		rulesStr = misc.DefaultRateLimitRules
+	}
+
+	rules, err := misc.ParseRateLimitRules(rulesStr)
+	if err != nil {
+		return nil, nil, errors.New("Invalid environment variable : RATE_LIMIT_RULES")
+	}
+
+	switch os.Getenv("RATE_LIMIT_BACKEND") {
+	case "", misc.RATE_LIMIT_BACKEND_MEMORY:
+		return rules, misc.NewMemoryRateLimitStore(), nil
+	case misc.RATE_LIMIT_BACKEND_REDIS:
+		if client, err := redis.NewRedisClient(); err != nil {
+			return nil, nil, err
+		} else {
+			return rules, misc.NewRedisRateLimitStore(client, "ratelimit:"), nil
+		}
+	default:
+		return nil, nil, errors.New("Invalid environment variable : RATE_LIMIT_BACKEND")
+	}
+}
+
 // ================================================================
 // Config
 // ================================================================
@@ -177,8 +930,8 @@ func (cfg *Config) GetTrustProxy() string {
 	return cfg.Env.TrustProxy
 }
 
-func (cfg *Config) GetJWTSecret() []byte {
-	return cfg.Env.JWTSecret
+func (cfg *Config) GetJWTKeyset() *misc.JWTKeyset {
+	return cfg.Env.JWTKeyset
 }
 
 func (cfg *Config) GetSMTPHost() string {
@@ -228,3 +981,145 @@ func (cfg *Config) GetForgetPwdEmailContent() string {
 func (cfg *Config) GetForgetPwdEmailLinkText() string {
 	return cfg.Env.ForgetPwdEmailLinkText
 }
+
+func (cfg *Config) GetMagicLinkEmailSubject() string {
+	return cfg.Env.MagicLinkEmailSubject
+}
+
+func (cfg *Config) GetMagicLinkEmailContent() string {
+	return cfg.Env.MagicLinkEmailContent
+}
+
+func (cfg *Config) GetMagicLinkEmailLinkText() string {
+	return cfg.Env.MagicLinkEmailLinkText
+}
+
+func (cfg *Config) GetUnlockPageURL() string {
+	return cfg.Env.UnlockPageURL
+}
+
+func (cfg *Config) GetUnlockEmailSubject() string {
+	return cfg.Env.UnlockEmailSubject
+}
+
+func (cfg *Config) GetUnlockEmailContent() string {
+	return cfg.Env.UnlockEmailContent
+}
+
+func (cfg *Config) GetUnlockEmailLinkText() string {
+	return cfg.Env.UnlockEmailLinkText
+}
+
+func (cfg *Config) GetPrivacyMode() bool {
+	return cfg.Env.PrivacyMode
+}
+
+func (cfg *Config) GetAccountExistsEmailSubject() string {
+	return cfg.Env.AccountExistsEmailSubject
+}
+
+func (cfg *Config) GetAccountExistsEmailContent() string {
+	return cfg.Env.AccountExistsEmailContent
+}
+
+func (cfg *Config) GetNoAccountEmailSubject() string {
+	return cfg.Env.NoAccountEmailSubject
+}
+
+func (cfg *Config) GetNoAccountEmailContent() string {
+	return cfg.Env.NoAccountEmailContent
+}
+
+func (cfg *Config) GetAccountDisabledEmailSubject() string {
+	return cfg.Env.AccountDisabledEmailSubject
+}
+
+func (cfg *Config) GetAccountDisabledEmailContent() string {
+	return cfg.Env.AccountDisabledEmailContent
+}
+
+func (cfg *Config) GetAccessTokenExpireSecs() int {
+	return cfg.Env.AccessTokenExpireSecs
+}
+
+func (cfg *Config) GetRefreshTokenExpireSecs() int {
+	return cfg.Env.RefreshTokenExpireSecs
+}
+
+func (cfg *Config) GetPasswordPolicy() *misc.PasswordPolicy {
+	return cfg.Env.PasswordPolicy
+}
+
+func (cfg *Config) GetPasswordHistorySize() int {
+	return cfg.Env.PasswordHistorySize
+}
+
+func (cfg *Config) GetPasswordHasher() misc.PasswordHasher {
+	return cfg.Env.PasswordHasher
+}
+
+func (cfg *Config) GetAdminAPIKey() string {
+	return cfg.Env.AdminAPIKey
+}
+
+// IsAdminIdentity tells whether the access tokens of the identity carry the admin scope.
+func (cfg *Config) IsAdminIdentity(identity string) bool {
+	return cfg.Env.AdminIdentities[strings.ToLower(identity)]
+}
+
+func (cfg *Config) GetTOTPIssuer() string {
+	return cfg.Env.TOTPIssuer
+}
+
+func (cfg *Config) GetWebAuthnConfig() *misc.WebAuthnConfig {
+	return cfg.Env.WebAuthnConfig
+}
+
+// GetOIDCConfig is nil when the OpenID Connect provider is disabled.
+func (cfg *Config) GetOIDCConfig() *misc.OIDCConfig {
+	return cfg.Env.OIDCConfig
+}
+
+func (cfg *Config) GetRequireClientToken() bool {
+	return cfg.Env.RequireClientToken
+}
+
+func (cfg *Config) GetLockoutPolicy() *misc.LockoutPolicy {
+	return cfg.Env.LockoutPolicy
+}
+
+func (cfg *Config) GetRateLimitRules() []*misc.RateLimitRule {
+	return cfg.Env.RateLimitRules
+}
+
+func (cfg *Config) GetRateLimitStore() misc.RateLimitStore {
+	return cfg.Env.RateLimitStore
+}
+
+func (cfg *Config) GetURLAllowlist() *misc.URLAllowlist {
+	return cfg.Env.URLAllowlist
+}
+
+func (cfg *Config) GetLocalizer() *misc.Localizer {
+	return cfg.Env.Localizer
+}
+
+func (cfg *Config) GetProductName() string {
+	return cfg.Env.ProductName
+}
+
+func (cfg *Config) GetEmailTemplates() *misc.EmailTemplates {
+	return cfg.Env.EmailTemplates
+}
+
+func (cfg *Config) GetMailer() misc.Mailer {
+	return cfg.Env.Mailer
+}
+
+func (cfg *Config) GetEmailOutboxPolicy() *misc.EmailOutboxPolicy {
+	return cfg.Env.EmailOutboxPolicy
+}
+
+func (cfg *Config) GetEmailListUnsubscribe() string {
+	return cfg.Env.EmailListUnsubscribe
+}
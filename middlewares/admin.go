@@ -0,0 +1,50 @@
+package middlewares
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hexcraft-biz/base-accounts-service/config"
+	"github.com/hexcraft-biz/base-accounts-service/misc"
+	"github.com/hexcraft-biz/base-accounts-service/models"
+)
+
+const (
+	HEADER_ADMIN_API_KEY = "X-Admin-Api-Key"
+)
+
+// AdminAuth lets requests through that carry the configured admin API key, or an access token with the admin
+// scope. The scope is not trusted alone: the user has to be enabled and still listed in ADMIN_IDENTITIES, so a
+// disabled or removed administrator loses access before the token expires. Without ADMIN_API_KEY and
+// ADMIN_IDENTITIES every admin endpoint is closed.
+func AdminAuth(cfg config.ConfigInterface) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if given := c.GetHeader(HEADER_ADMIN_API_KEY); given != "" {
+			apiKey := cfg.GetAdminAPIKey()
+			if apiKey == "" || subtle.ConstantTimeCompare([]byte(apiKey), []byte(given)) != 1 {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": http.StatusText(http.StatusUnauthorized)})
+				return
+			}
+
+			c.Next()
+			return
+		}
+
+		if claims, err := ParseAccessToken(cfg, BearerToken(c)); err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": http.StatusText(http.StatusUnauthorized)})
+			return
+		} else if claims.ClientID != "" || !claims.HasScope(misc.SCOPE_ADMIN) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": http.StatusText(http.StatusForbidden)})
+			return
+		} else if userRes, err := models.NewUsersTableEngine(cfg.GetDB()).GetByID(claims.Subject); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		} else if userRes == nil || userRes.Status != models.USER_STATUS_ENABLED || !cfg.IsAdminIdentity(userRes.Identity) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": http.StatusText(http.StatusForbidden)})
+			return
+		}
+
+		c.Next()
+	}
+}
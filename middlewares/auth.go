@@ -0,0 +1,140 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hexcraft-biz/base-accounts-service/config"
+	"github.com/hexcraft-biz/base-accounts-service/misc"
+	"github.com/hexcraft-biz/base-accounts-service/models"
+)
+
+const (
+	CTX_USER_ID       = "userID"
+	CTX_ACCESS_CLAIMS = "accessClaims"
+)
+
+var (
+	ErrInvalidAccessToken = errors.New("Invalid access token.")
+)
+
+// AccessToken requires a valid access token in "Authorization: Bearer <token>" and keeps the user ID in the context.
+// Tokens issued to OAuth clients are refused, they are only good for the /oauth endpoints.
+func AccessToken(cfg config.ConfigInterface) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, err := ParseAccessToken(cfg, BearerToken(c))
+		if err != nil || claims.ClientID != "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": http.StatusText(http.StatusUnauthorized)})
+			return
+		}
+
+		userID, err := uuid.Parse(claims.Subject)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": http.StatusText(http.StatusUnauthorized)})
+			return
+		}
+
+		c.Set(CTX_USER_ID, &userID)
+		c.Set(CTX_ACCESS_CLAIMS, claims)
+		c.Next()
+	}
+}
+
+// OAuthAccessToken requires an access token issued to an OAuth client with the scope, errors are announced in the
+// WWW-Authenticate header as RFC 6750 asks.
+func OAuthAccessToken(cfg config.ConfigInterface, scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, err := ParseAccessToken(cfg, BearerToken(c))
+		if err != nil || claims.ClientID == "" {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": http.StatusText(http.StatusUnauthorized)})
+			return
+		} else if !claims.HasScope(scope) {
+			c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": http.StatusText(http.StatusForbidden)})
+			return
+		}
+
+		userID, err := uuid.Parse(claims.Subject)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": http.StatusText(http.StatusUnauthorized)})
+			return
+		}
+
+		c.Set(CTX_USER_ID, &userID)
+		c.Set(CTX_ACCESS_CLAIMS, claims)
+		c.Next()
+	}
+}
+
+// ParseAccessToken verifies the signature, the expiry and the type of an access token, that it was not revoked
+// through /oauth/revoke and that its session was not signed out.
+func ParseAccessToken(cfg config.ConfigInterface, tokenStr string) (*misc.AccessJwtClaims, error) {
+	return parseAccessJwt(cfg, tokenStr, misc.JWT_TYPE_ACCESS)
+}
+
+// ParseClientToken is ParseAccessToken for the token a client got with the client_credentials grant.
+func ParseClientToken(cfg config.ConfigInterface, tokenStr string) (*misc.AccessJwtClaims, error) {
+	return parseAccessJwt(cfg, tokenStr, misc.JWT_TYPE_CLIENT)
+}
+
+func parseAccessJwt(cfg config.ConfigInterface, tokenStr, typ string) (*misc.AccessJwtClaims, error) {
+	if tokenStr == "" {
+		return nil, ErrInvalidAccessToken
+	}
+
+	var claims misc.AccessJwtClaims
+	miscJWT := misc.NewJWT(cfg.GetJWTKeyset())
+	if token, err := miscJWT.Parse(tokenStr, &claims); err != nil || !token.Valid || claims.Type != typ {
+		return nil, ErrInvalidAccessToken
+	}
+
+	if revoked, err := models.NewRevokedTokensTableEngine(cfg.GetDB()).IsRevoked(claims.Id); err != nil {
+		return nil, err
+	} else if revoked {
+		return nil, ErrInvalidAccessToken
+	}
+
+	if claims.SessionID != "" {
+		if exists, err := models.NewSessionsTableEngine(cfg.GetDB()).Exists(claims.SessionID); err != nil {
+			return nil, err
+		} else if !exists {
+			return nil, ErrInvalidAccessToken
+		}
+	}
+
+	return &claims, nil
+}
+
+func BearerToken(c *gin.Context) string {
+	authorization := c.GetHeader("Authorization")
+	if len(authorization) > 7 && strings.EqualFold(authorization[:7], "Bearer ") {
+		return strings.TrimSpace(authorization[7:])
+	}
+
+	return ""
+}
+
+func GetUserID(c *gin.Context) *uuid.UUID {
+	if v, ok := c.Get(CTX_USER_ID); ok {
+		if userID, ok := v.(*uuid.UUID); ok {
+			return userID
+		}
+	}
+
+	return nil
+}
+
+func GetAccessClaims(c *gin.Context) *misc.AccessJwtClaims {
+	if v, ok := c.Get(CTX_ACCESS_CLAIMS); ok {
+		if claims, ok := v.(*misc.AccessJwtClaims); ok {
+			return claims
+		}
+	}
+
+	return nil
+}
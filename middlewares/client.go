@@ -0,0 +1,33 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hexcraft-biz/base-accounts-service/config"
+)
+
+const (
+	HEADER_CLIENT_TOKEN = "X-Client-Token"
+)
+
+// ClientToken lets requests through that carry a client_credentials token with the scope in the X-Client-Token
+// header, Authorization stays free for the access token of the user. It is a no-op with REQUIRE_CLIENT_TOKEN=false.
+func ClientToken(cfg config.ConfigInterface, scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !cfg.GetRequireClientToken() {
+			c.Next()
+			return
+		}
+
+		if claims, err := ParseClientToken(cfg, c.GetHeader(HEADER_CLIENT_TOKEN)); err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "A valid client token is required."})
+			return
+		} else if !claims.HasScope(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "The client token lacks the " + scope + " scope."})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,176 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/hexcraft-biz/base-accounts-service/config"
+	"github.com/hexcraft-biz/base-accounts-service/misc"
+)
+
+type rateLimitConfig struct {
+	config.ConfigInterface
+	trustProxy string
+	rules      []*misc.RateLimitRule
+	store      misc.RateLimitStore
+}
+
+func (cfg *rateLimitConfig) GetTrustProxy() string                    { return cfg.trustProxy }
+func (cfg *rateLimitConfig) GetRateLimitRules() []*misc.RateLimitRule { return cfg.rules }
+func (cfg *rateLimitConfig) GetRateLimitStore() misc.RateLimitStore   { return cfg.store }
+
+// recordingStore remembers the keys it was asked for.
+type recordingStore struct {
+	misc.RateLimitStore
+	keys []string
+}
+
+func (s *recordingStore) Take(ctx context.Context, keys []string, limits []misc.RateLimit) (bool, time.Duration, error) {
+	s.keys = append(s.keys, keys...)
+	return s.RateLimitStore.Take(ctx, keys, limits)
+}
+
+// newRateLimitEngine wires the middleware like service.New does.
+func newRateLimitEngine(t *testing.T, trustProxy, rules string, store misc.RateLimitStore) *gin.Engine {
+	parsed, err := misc.ParseRateLimitRules(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &rateLimitConfig{trustProxy: trustProxy, rules: parsed, store: store}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.SetTrustedProxies([]string{cfg.GetTrustProxy()})
+	engine.Use(RateLimit(cfg))
+	engine.POST("/auth/v1/login", func(c *gin.Context) {
+		var params struct {
+			Identity string `json:"identity" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&params); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusOK, gin.H{"identity": params.Identity})
+	})
+	engine.GET("/auth/v1/userinfo", func(c *gin.Context) {
+		c.AbortWithStatus(http.StatusOK)
+	})
+
+	return engine
+}
+
+func rateLimitRequest(engine *gin.Engine, method, path, remoteAddr, forwardedFor, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.RemoteAddr = remoteAddr
+	req.Header.Set("Content-Type", "application/json")
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimitIPKey(t *testing.T) {
+	cases := []struct {
+		name       string
+		trustProxy string
+		wantIP     string
+	}{
+		{"without TRUST_PROXY", "", "10.0.0.1"},
+		{"behind a trusted proxy", "10.0.0.1", "203.0.113.9"},
+		{"behind another proxy", "10.0.0.2", "10.0.0.1"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := &recordingStore{RateLimitStore: misc.NewMemoryRateLimitStore()}
+			engine := newRateLimitEngine(t, tc.trustProxy, "POST /auth/v1/login=ip:2/1m", store)
+
+			for i := 0; i < 2; i++ {
+				if w := rateLimitRequest(engine, "POST", "/auth/v1/login", "10.0.0.1:4321", "203.0.113.9", `{"identity":"a@example.com"}`); w.Code != http.StatusOK {
+					t.Fatalf("request %d: status = %d", i, w.Code)
+				}
+			}
+
+			w := rateLimitRequest(engine, "POST", "/auth/v1/login", "10.0.0.1:4321", "203.0.113.9", `{"identity":"a@example.com"}`)
+			if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "30" {
+				t.Fatalf("status = %d, Retry-After = %q", w.Code, w.Header().Get("Retry-After"))
+			}
+
+			if want := "POST /auth/v1/login|ip|" + tc.wantIP; store.keys[0] != want {
+				t.Fatalf("key = %q, want %q", store.keys[0], want)
+			}
+		})
+	}
+}
+
+func TestRateLimitEmailKey(t *testing.T) {
+	store := &recordingStore{RateLimitStore: misc.NewMemoryRateLimitStore()}
+	engine := newRateLimitEngine(t, "", "POST /auth/v1/login=email:1/1h", store)
+
+	// The body is still there for the handler to bind.
+	if w := rateLimitRequest(engine, "POST", "/auth/v1/login", "10.0.0.1:4321", "", `{"identity":" A@Example.com "}`); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "A@Example.com") {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if want := "POST /auth/v1/login|email|a@example.com"; store.keys[0] != want {
+		t.Fatalf("key = %q, want %q", store.keys[0], want)
+	}
+
+	// The address is normalized, another IP does not help.
+	if w := rateLimitRequest(engine, "POST", "/auth/v1/login", "10.0.0.2:4321", "", `{"identity":"a@example.com"}`); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", w.Code)
+	}
+	if w := rateLimitRequest(engine, "POST", "/auth/v1/login", "10.0.0.1:4321", "", `{"identity":"b@example.com"}`); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+
+	// Without an address the email limit does not apply.
+	for i := 0; i < 3; i++ {
+		if w := rateLimitRequest(engine, "POST", "/auth/v1/login", "10.0.0.1:4321", "", `not json`); w.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want 400", w.Code)
+		}
+	}
+}
+
+func TestRateLimitRedisStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	// Two replicas share the buckets.
+	rules := "POST /auth/v1/login=ip:2/1m,email:3/1m"
+	first := newRateLimitEngine(t, "", rules, misc.NewRedisRateLimitStore(client, "ratelimit:"))
+	second := newRateLimitEngine(t, "", rules, misc.NewRedisRateLimitStore(client, "ratelimit:"))
+
+	if w := rateLimitRequest(first, "POST", "/auth/v1/login", "10.0.0.1:4321", "", `{"identity":"a@example.com"}`); w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	if w := rateLimitRequest(second, "POST", "/auth/v1/login", "10.0.0.1:4321", "", `{"identity":"a@example.com"}`); w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	if w := rateLimitRequest(first, "POST", "/auth/v1/login", "10.0.0.1:4321", "", `{"identity":"a@example.com"}`); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", w.Code)
+	}
+	if !mr.Exists("ratelimit:POST /auth/v1/login|ip|10.0.0.1") || !mr.Exists("ratelimit:POST /auth/v1/login|email|a@example.com") {
+		t.Fatalf("unexpected keys %v", mr.Keys())
+	}
+
+	// Routes without a rule are not counted, and an outage of Redis lets requests through.
+	if w := rateLimitRequest(first, "GET", "/auth/v1/userinfo", "10.0.0.1:4321", "", ""); w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	mr.Close()
+	if w := rateLimitRequest(first, "POST", "/auth/v1/login", "10.0.0.3:4321", "", `{"identity":"c@example.com"}`); w.Code != http.StatusOK {
+		t.Fatalf("status = %d during an outage, want 200", w.Code)
+	}
+}
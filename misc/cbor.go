@@ -0,0 +1,216 @@
+package misc
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// A minimal CBOR (RFC 8949) decoder, enough for WebAuthn attestation objects and COSE keys.
+// Integers decode to int64, byte strings to []byte, text strings to string, arrays to []interface{} and
+// maps to map[interface{}]interface{} keyed by int64 or string. Indefinite lengths are rejected.
+
+const (
+	cborMaxDepth = 16
+)
+
+var (
+	ErrCBORTruncated   = errors.New("cbor: unexpected end of data")
+	ErrCBORUnsupported = errors.New("cbor: unsupported item")
+)
+
+// DecodeCBOR decodes the first item of data and returns it with the number of bytes it used.
+func DecodeCBOR(data []byte) (interface{}, int, error) {
+	d := &cborDecoder{data: data}
+	v, err := d.decode(0)
+	return v, d.pos, err
+}
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *cborDecoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, ErrCBORTruncated
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *cborDecoder) argument(info byte) (uint64, error) {
+	switch {
+	case info < 24:
+		return uint64(info), nil
+	case info == 24:
+		b, err := d.next(1)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(b[0]), nil
+	case info == 25:
+		b, err := d.next(2)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case info == 26:
+		b, err := d.next(4)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	case info == 27:
+		b, err := d.next(8)
+		if err != nil {
+			return 0, err
+		}
+		return binary.BigEndian.Uint64(b), nil
+	default:
+		return 0, ErrCBORUnsupported
+	}
+}
+
+func (d *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > cborMaxDepth {
+		return nil, ErrCBORUnsupported
+	}
+
+	head, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	major, info := head[0]>>5, head[0]&0x1f
+
+	if major == 7 {
+		return d.decodeSimple(info)
+	}
+
+	arg, err := d.argument(info)
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, ErrCBORUnsupported
+		}
+		return int64(arg), nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, ErrCBORUnsupported
+		}
+		return -1 - int64(arg), nil
+	case 2:
+		if arg > uint64(len(d.data)) {
+			return nil, ErrCBORTruncated
+		}
+		b, err := d.next(int(arg))
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, b...), nil
+	case 3:
+		if arg > uint64(len(d.data)) {
+			return nil, ErrCBORTruncated
+		}
+		b, err := d.next(int(arg))
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case 4:
+		if arg > uint64(len(d.data)) {
+			return nil, ErrCBORTruncated
+		}
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			item, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case 5:
+		if arg > uint64(len(d.data)) {
+			return nil, ErrCBORTruncated
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			key, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, ErrCBORUnsupported
+			}
+			val, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = val
+		}
+		return m, nil
+	case 6:
+		// Tags carry no meaning for WebAuthn, the tagged item is returned as is.
+		return d.decode(depth + 1)
+	default:
+		return nil, ErrCBORUnsupported
+	}
+}
+
+func (d *cborDecoder) decodeSimple(info byte) (interface{}, error) {
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		b, err := d.next(2)
+		if err != nil {
+			return nil, err
+		}
+		return float64(halfToFloat(binary.BigEndian.Uint16(b))), nil
+	case 26:
+		b, err := d.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 27:
+		b, err := d.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	default:
+		return nil, ErrCBORUnsupported
+	}
+}
+
+func halfToFloat(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	frac := uint32(h) & 0x3ff
+
+	switch exp {
+	case 0:
+		f := float32(frac) / 1024 * float32(math.Pow(2, -14))
+		if sign != 0 {
+			return -f
+		}
+		return f
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | frac<<13)
+	default:
+		return math.Float32frombits(sign | (exp+112)<<23 | frac<<13)
+	}
+}
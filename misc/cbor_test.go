@@ -0,0 +1,113 @@
+package misc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// cborItem encodes the handful of types a software authenticator needs, map keys are sorted like canonical CBOR.
+func cborItem(v interface{}) []byte {
+	switch x := v.(type) {
+	case int:
+		if x < 0 {
+			return cborHead(1, uint64(-1-x))
+		}
+		return cborHead(0, uint64(x))
+	case int64:
+		return cborItem(int(x))
+	case []byte:
+		return append(cborHead(2, uint64(len(x))), x...)
+	case string:
+		return append(cborHead(3, uint64(len(x))), x...)
+	case []interface{}:
+		b := cborHead(4, uint64(len(x)))
+		for _, item := range x {
+			b = append(b, cborItem(item)...)
+		}
+		return b
+	case map[interface{}]interface{}:
+		pairs := make([][2][]byte, 0, len(x))
+		for k, val := range x {
+			pairs = append(pairs, [2][]byte{cborItem(k), cborItem(val)})
+		}
+		sort.Slice(pairs, func(i, j int) bool { return bytes.Compare(pairs[i][0], pairs[j][0]) < 0 })
+		b := cborHead(5, uint64(len(x)))
+		for _, p := range pairs {
+			b = append(append(b, p[0]...), p[1]...)
+		}
+		return b
+	default:
+		panic("cborItem: unsupported type")
+	}
+}
+
+func cborHead(major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return []byte{major<<5 | byte(arg)}
+	case arg <= 0xff:
+		return []byte{major<<5 | 24, byte(arg)}
+	case arg <= 0xffff:
+		b := []byte{major<<5 | 25, 0, 0}
+		binary.BigEndian.PutUint16(b[1:], uint16(arg))
+		return b
+	default:
+		b := []byte{major<<5 | 26, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(arg))
+		return b
+	}
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	item := map[interface{}]interface{}{
+		int64(1):  int64(2),
+		int64(-1): []byte{0x01, 0x02},
+		"fmt":     "none",
+		"list":    []interface{}{int64(-300), "x"},
+	}
+	data := append(cborItem(item), 0xff)
+
+	got, n, err := DecodeCBOR(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(data)-1 {
+		t.Fatalf("used %d bytes, want %d", n, len(data)-1)
+	}
+	if !reflect.DeepEqual(got, item) {
+		t.Fatalf("got %#v, want %#v", got, item)
+	}
+}
+
+func TestDecodeCBORMalformed(t *testing.T) {
+	deep := bytes.Repeat([]byte{0x81}, cborMaxDepth+2)
+
+	cases := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, ErrCBORTruncated},
+		{"truncated argument", []byte{0x19, 0x01}, ErrCBORTruncated},
+		{"truncated byte string", []byte{0x45, 0x01, 0x02}, ErrCBORTruncated},
+		{"huge byte string", []byte{0x5b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, ErrCBORTruncated},
+		{"truncated map", []byte{0xa2, 0x01, 0x02}, ErrCBORTruncated},
+		{"huge array", []byte{0x9a, 0xff, 0xff, 0xff, 0xff}, ErrCBORTruncated},
+		{"indefinite length", []byte{0x5f, 0x41, 0x00, 0xff}, ErrCBORUnsupported},
+		{"reserved additional info", []byte{0x1c}, ErrCBORUnsupported},
+		{"array map key", []byte{0xa1, 0x80, 0x01}, ErrCBORUnsupported},
+		{"too deep", append(deep, 0x01), ErrCBORUnsupported},
+		{"negative overflow", []byte{0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, ErrCBORUnsupported},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, _, err := DecodeCBOR(tc.data); err != tc.want {
+				t.Fatalf("err = %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
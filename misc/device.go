@@ -0,0 +1,62 @@
+package misc
+
+import "strings"
+
+const (
+	DeviceLabelUnknown = "Unknown device"
+)
+
+type uaMatch struct {
+	Token string
+	Name  string
+}
+
+// Order matters, e.g. Edge and Opera also announce Chrome, and Chrome announces Safari.
+var (
+	uaBrowsers = []uaMatch{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"SamsungBrowser/", "Samsung Internet"},
+		{"Firefox/", "Firefox"},
+		{"FxiOS/", "Firefox"},
+		{"CriOS/", "Chrome"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+	}
+	uaSystems = []uaMatch{
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Android", "Android"},
+		{"CrOS", "ChromeOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	}
+)
+
+// DeviceLabel names the device of a session from its User-Agent, e.g. "Chrome on Windows". It is only meant to help
+// the user recognize the session.
+func DeviceLabel(userAgent string) string {
+	browser, system := uaLookup(uaBrowsers, userAgent), uaLookup(uaSystems, userAgent)
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	default:
+		return DeviceLabelUnknown
+	}
+}
+
+func uaLookup(matches []uaMatch, userAgent string) string {
+	for _, m := range matches {
+		if strings.Contains(userAgent, m.Token) {
+			return m.Name
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,171 @@
+package misc
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DKIMSignedHeaders are signed when the message has them, From is required by RFC 6376.
+var DKIMSignedHeaders = []string{"From", "To", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type", "List-Unsubscribe"}
+
+var (
+	ErrDKIMKeyType = errors.New("The DKIM private key must be RSA or Ed25519.")
+	ErrDKIMMessage = errors.New("The message has no header section.")
+
+	dkimWSP = regexp.MustCompile(`[ \t]+`)
+)
+
+// DKIMSigner adds a DKIM-Signature with relaxed/relaxed canonicalization, the public key is published at
+// <Selector>._domainkey.<Domain>.
+type DKIMSigner struct {
+	Domain   string
+	Selector string
+	Key      crypto.Signer
+}
+
+// NewDKIMSigner reads a PEM private key, PKCS#1 or PKCS#8, RSA or Ed25519.
+func NewDKIMSigner(domain, selector, keyFile string) (*DKIMSigner, error) {
+	b, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, ErrDKIMKeyType
+	}
+
+	var key crypto.Signer
+	if rsaKey, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		key = rsaKey
+	} else if parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+		return nil, err
+	} else {
+		switch k := parsed.(type) {
+		case *rsa.PrivateKey:
+			key = k
+		case ed25519.PrivateKey:
+			key = k
+		default:
+			return nil, ErrDKIMKeyType
+		}
+	}
+
+	return &DKIMSigner{
+		Domain:   domain,
+		Selector: selector,
+		Key:      key,
+	}, nil
+}
+
+// Sign returns the message with its DKIM-Signature header prepended, msg must use CRLF line endings.
+func (s *DKIMSigner) Sign(msg []byte) ([]byte, error) {
+	i := bytes.Index(msg, []byte("\r\n\r\n"))
+	if i < 0 {
+		return nil, ErrDKIMMessage
+	}
+	headers, body := parseDKIMHeaders(string(msg[:i+2])), string(msg[i+4:])
+
+	bodyHash := sha256.Sum256([]byte(dkimRelaxedBody(body)))
+
+	// Every instance of a header is signed, from the last to the first as RFC 6376 5.4.2 picks them.
+	names, canonical := []string{}, ""
+	for _, name := range DKIMSignedHeaders {
+		for j := len(headers) - 1; j >= 0; j-- {
+			if strings.EqualFold(headers[j].name, name) {
+				names = append(names, name)
+				canonical += dkimRelaxedHeader(headers[j].name, headers[j].value) + "\r\n"
+			}
+		}
+	}
+
+	algo := "rsa-sha256"
+	if _, ok := s.Key.(ed25519.PrivateKey); ok {
+		algo = "ed25519-sha256"
+	}
+
+	value := "v=1; a=" + algo + "; c=relaxed/relaxed; d=" + s.Domain + "; s=" + s.Selector +
+		"; t=" + strconv.FormatInt(time.Now().Unix(), 10) + "; h=" + strings.Join(names, ":") +
+		"; bh=" + base64.StdEncoding.EncodeToString(bodyHash[:]) + "; b="
+	canonical += dkimRelaxedHeader("DKIM-Signature", value)
+
+	hash := sha256.Sum256([]byte(canonical))
+
+	var (
+		sig []byte
+		err error
+	)
+	if _, ok := s.Key.(ed25519.PrivateKey); ok {
+		sig, err = s.Key.Sign(rand.Reader, hash[:], crypto.Hash(0))
+	} else {
+		sig, err = s.Key.Sign(rand.Reader, hash[:], crypto.SHA256)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Only b= is folded, its whitespace is ignored by verifiers.
+	b := base64.StdEncoding.EncodeToString(sig)
+	for j := 64; j < len(b); j += 66 {
+		b = b[:j] + "\r\n " + b[j:]
+	}
+
+	return append([]byte("DKIM-Signature: "+value+b+"\r\n"), msg...), nil
+}
+
+type dkimHeader struct {
+	name  string
+	value string
+}
+
+// parseDKIMHeaders keeps folded values as they are, the relaxed canonicalization unfolds them.
+func parseDKIMHeaders(section string) []dkimHeader {
+	headers := []dkimHeader{}
+	for _, line := range strings.SplitAfter(section, "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(headers) > 0 {
+			headers[len(headers)-1].value += line
+		} else if k := strings.Index(line, ":"); k > 0 {
+			headers = append(headers, dkimHeader{name: line[:k], value: line[k+1:]})
+		}
+	}
+
+	return headers
+}
+
+func dkimRelaxedHeader(name, value string) string {
+	value = strings.NewReplacer("\r\n", "").Replace(value)
+	value = strings.TrimSpace(dkimWSP.ReplaceAllString(value, " "))
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + value
+}
+
+func dkimRelaxedBody(body string) string {
+	lines := strings.Split(body, "\r\n")
+	for j, line := range lines {
+		lines[j] = strings.TrimRight(dkimWSP.ReplaceAllString(line, " "), " ")
+	}
+
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
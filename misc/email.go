@@ -1,35 +1,158 @@
 package misc
 
 import (
-	"net/smtp"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
 	"strings"
+	"time"
 )
 
-type Email struct {
-	SmtpHost string
-	SmtpPort string
-	Username string
-	Password string
+// EmailMessage is a rendered email, Text is the optional plain text alternative of HTML. Date and MessageID are
+// filled in when the message is built, set them to get the same bytes every time.
+type EmailMessage struct {
+	FromName        string
+	From            string
+	To              []string
+	Subject         string
+	HTML            string
+	Text            string
+	ListUnsubscribe string
+	Date            time.Time
+	MessageID       string
 }
 
-func NewEmail(smtpHost string, smtpPort string, username string, password string) *Email {
-	return &Email{
-		SmtpHost: smtpHost,
-		SmtpPort: smtpPort,
-		Username: username,
-		Password: password,
+var ErrInvalidEmailAddress = errors.New("Invalid email address")
+
+// Bytes formats the message as RFC 5322 with CRLF line endings. Header values never contain a line break, so
+// nothing given by a user can add a header, and non-ASCII names and subjects are RFC 2047 encoded words.
+func (m *EmailMessage) Bytes() ([]byte, error) {
+	from, err := formatAddress(m.FromName, m.From)
+	if err != nil {
+		return nil, err
+	}
+
+	to := make([]string, len(m.To))
+	for i, addr := range m.To {
+		if to[i], err = formatAddress("", addr); err != nil {
+			return nil, err
+		}
+	}
+
+	date := m.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	messageID := m.MessageID
+	if messageID == "" {
+		messageID = genMessageID(m.From)
+	}
+
+	var buf bytes.Buffer
+	writeHeader(&buf, "From", from)
+	writeHeader(&buf, "To", strings.Join(to, ", "))
+	writeHeader(&buf, "Subject", encodeHeaderWords(m.Subject))
+	writeHeader(&buf, "Date", date.Format(time.RFC1123Z))
+	writeHeader(&buf, "Message-ID", "<"+sanitizeHeader(messageID)+">")
+	if m.ListUnsubscribe != "" {
+		writeHeader(&buf, "List-Unsubscribe", "<"+sanitizeHeader(m.ListUnsubscribe)+">")
+	}
+	writeHeader(&buf, "MIME-Version", "1.0")
+
+	if m.Text == "" {
+		writeHeader(&buf, "Content-Type", `text/html; charset="UTF-8"`)
+		writeHeader(&buf, "Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, m.HTML); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	// The boundary is derived from the Message-ID, so a message with fixed Date and MessageID is reproducible.
+	sum := sha256.Sum256([]byte(messageID))
+	mw := multipart.NewWriter(&buf)
+	if err := mw.SetBoundary("=_" + hex.EncodeToString(sum[:16])); err != nil {
+		return nil, err
+	}
+
+	writeHeader(&buf, "Content-Type", `multipart/alternative; boundary="`+mw.Boundary()+`"`)
+	buf.WriteString("\r\n")
+
+	for _, part := range []struct {
+		contentType string
+		body        string
+	}{
+		{`text/plain; charset="UTF-8"`, m.Text},
+		{`text/html; charset="UTF-8"`, m.HTML},
+	} {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(pw, part.body); err != nil {
+			return nil, err
+		}
 	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func formatAddress(name, addr string) (string, error) {
+	if parsed, err := mail.ParseAddress(addr); err != nil || parsed.Address != addr {
+		return "", ErrInvalidEmailAddress
+	}
+
+	return (&mail.Address{Name: sanitizeHeader(name), Address: addr}).String(), nil
+}
+
+// encodeHeaderWords leaves ASCII as it is, anything else becomes encoded words folded on their own lines.
+func encodeHeaderWords(value string) string {
+	return strings.ReplaceAll(mime.QEncoding.Encode("UTF-8", sanitizeHeader(value)), "?= =?", "?=\r\n =?")
+}
+
+func sanitizeHeader(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
+
+func writeHeader(buf *bytes.Buffer, key, value string) {
+	buf.WriteString(key + ": " + value + "\r\n")
 }
 
-func (e *Email) SendHTML(senderName, from string, to []string, subject, body string) error {
-	server := e.SmtpHost + ":" + e.SmtpPort
+func writeQuotedPrintable(w io.Writer, body string) error {
+	// quoted-printable keeps the line breaks of the body as they are, they have to be CRLF already.
+	body = strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n")
 
-	fromStr := "From: " + senderName + "<" + from + ">\n"
-	toStr := "To: " + strings.Join(to, ",") + "\n"
-	subjectStr := "Subject: " + subject + "\n"
-	mime := "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n"
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
 
-	msg := []byte(fromStr + toStr + subjectStr + mime + "\n" + body)
+func genMessageID(from string) string {
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i >= 0 && i < len(from)-1 {
+		domain = from[i+1:]
+	}
 
-	return smtp.SendMail(server, smtp.PlainAuth("", e.Username, e.Password, e.SmtpHost), from, to, msg)
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b) + "@" + domain
 }
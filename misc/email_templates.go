@@ -0,0 +1,129 @@
+package misc
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	texttemplate "text/template"
+	"time"
+)
+
+//go:embed templates/*.html templates/*.txt
+var defaultEmailTemplates embed.FS
+
+var EmailTypes = []string{
+	EMAIL_TYPE_SIGN_UP,
+	EMAIL_TYPE_FORGET_PWD,
+	EMAIL_TYPE_MAGIC_LOGIN,
+	EMAIL_TYPE_UNLOCK,
+	EMAIL_TYPE_ACCOUNT_EXISTS,
+	EMAIL_TYPE_NO_ACCOUNT,
+	EMAIL_TYPE_ACCOUNT_DISABLED,
+}
+
+type EmailTemplateUser struct {
+	ID       string
+	Identity string
+	Status   string
+}
+
+// EmailTemplateData is what every template can use. User is nil when the address has no account yet, Link and
+// ExpiresAt are zero for the emails without a link.
+type EmailTemplateData struct {
+	ProductName string
+	Locale      string
+	Email       string
+	User        *EmailTemplateUser
+	Subject     string
+	Content     string
+	LinkText    string
+	Link        string
+	ExpiresAt   time.Time
+	ExpireMins  int
+}
+
+// EmailTemplates holds an HTML and a plain text template per email type. The HTML part is rendered with
+// html/template so the texts and the link are escaped.
+type EmailTemplates struct {
+	html map[string]*htmltemplate.Template
+	text map[string]*texttemplate.Template
+}
+
+// NewEmailTemplates parses <type>.html and <type>.txt of dir, every file missing from dir (or all of them when dir
+// is empty) is taken from the embedded defaults.
+func NewEmailTemplates(dir string) (*EmailTemplates, error) {
+	t := &EmailTemplates{
+		html: map[string]*htmltemplate.Template{},
+		text: map[string]*texttemplate.Template{},
+	}
+
+	for _, typ := range EmailTypes {
+		if src, err := readEmailTemplate(dir, typ+".html"); err != nil {
+			return nil, err
+		} else if t.html[typ], err = htmltemplate.New(typ + ".html").Parse(src); err != nil {
+			return nil, err
+		}
+
+		if src, err := readEmailTemplate(dir, typ+".txt"); err != nil {
+			return nil, err
+		} else if t.text[typ], err = texttemplate.New(typ + ".txt").Parse(src); err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+func readEmailTemplate(dir, name string) (string, error) {
+	if dir != "" {
+		if b, err := os.ReadFile(filepath.Join(dir, name)); err == nil {
+			return string(b), nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+
+	b, err := fs.ReadFile(defaultEmailTemplates, "templates/"+name)
+	return string(b), err
+}
+
+// Render returns the HTML and the plain text part of an email type.
+func (t *EmailTemplates) Render(typ string, data *EmailTemplateData) (string, string, error) {
+	var html, text bytes.Buffer
+
+	if err := t.html[typ].Execute(&html, data); err != nil {
+		return "", "", err
+	}
+
+	if err := t.text[typ].Execute(&text, data); err != nil {
+		return "", "", err
+	}
+
+	return html.String(), text.String(), nil
+}
+
+// Check renders every email type with sample data, so a template using an unknown variable fails at startup
+// instead of when the first email is sent.
+func (t *EmailTemplates) Check(productName string) error {
+	for _, typ := range EmailTypes {
+		if _, _, err := t.Render(typ, &EmailTemplateData{
+			ProductName: productName,
+			Locale:      DefaultLocale,
+			Email:       "xxx@mail.com",
+			User:        &EmailTemplateUser{ID: "00000000-0000-0000-0000-000000000000", Identity: "xxx@mail.com", Status: "enabled"},
+			Subject:     "Subject",
+			Content:     "Content",
+			LinkText:    "Link",
+			Link:        "https://www.example.com/?token=xxx",
+			ExpiresAt:   time.Now(),
+			ExpireMins:  10,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,213 @@
+package misc
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files of testdata")
+
+var testEmailDate = time.Date(2022, 3, 4, 5, 6, 7, 0, time.FixedZone("CST", 8*60*60))
+
+// checkGolden compares got with testdata/name, go test -run TestEmailMessageBytes -update rewrites the file.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("%s differs:\n%s", path, got)
+	}
+}
+
+// checkCRLF fails on a line feed that does not end a CRLF.
+func checkCRLF(t *testing.T, b []byte) {
+	t.Helper()
+
+	for i, c := range b {
+		if c == '\n' && (i == 0 || b[i-1] != '\r') {
+			t.Fatalf("bare LF at byte %d", i)
+		} else if c == '\r' && (i == len(b)-1 || b[i+1] != '\n') {
+			t.Fatalf("bare CR at byte %d", i)
+		}
+	}
+}
+
+func TestEmailMessageBytes(t *testing.T) {
+	cases := []struct {
+		name string
+		msg  *EmailMessage
+	}{
+		{"html.eml", &EmailMessage{
+			FromName:  "Accounts",
+			From:      "no-reply@example.com",
+			To:        []string{"user@example.com"},
+			Subject:   "Login Link",
+			HTML:      "<p>Hello,\nfollow the link.</p>",
+			Date:      testEmailDate,
+			MessageID: "0123456789abcdef@example.com",
+		}},
+		{"multipart.eml", &EmailMessage{
+			FromName:        "帳號中心 Équipe",
+			From:            "no-reply@example.com",
+			To:              []string{"user@example.com", "other@example.com"},
+			Subject:         "登入連結 — 請在三十分鐘內點擊下方連結登入您的帳號 — Login Link",
+			HTML:            "<p>請點擊下方連結登入。</p>\r\n<p><a href=\"https://example.com/login?token=a.b.c\">Log in</a></p>",
+			Text:            "請點擊下方連結登入。\nhttps://example.com/login?token=a.b.c",
+			ListUnsubscribe: "mailto:unsubscribe@example.com",
+			Date:            testEmailDate,
+			MessageID:       "fedcba9876543210@example.com",
+		}},
+		{"injection.eml", &EmailMessage{
+			FromName:  "Accounts\r\nBcc: victim@example.com",
+			From:      "no-reply@example.com",
+			To:        []string{"user@example.com"},
+			Subject:   "Hello\nBcc: victim@example.com",
+			HTML:      "<p>Hello</p>",
+			Date:      testEmailDate,
+			MessageID: "injected\r\nBcc: victim@example.com",
+		}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := tc.msg.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			checkCRLF(t, b)
+			checkGolden(t, tc.name, b)
+
+			// The golden bytes parse back to the message.
+			parsed, err := mail.ReadMessage(bytes.NewReader(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dec := new(mime.WordDecoder)
+			if subject, err := dec.DecodeHeader(parsed.Header.Get("Subject")); err != nil || subject != sanitizeHeader(tc.msg.Subject) {
+				t.Fatalf("Subject = %q, %v", subject, err)
+			}
+			if from, err := parsed.Header.AddressList("From"); err != nil || from[0].Name != sanitizeHeader(tc.msg.FromName) || from[0].Address != tc.msg.From {
+				t.Fatalf("From = %v, %v", from, err)
+			}
+			if to, err := parsed.Header.AddressList("To"); err != nil || len(to) != len(tc.msg.To) {
+				t.Fatalf("To = %v, %v", to, err)
+			}
+			if date, err := parsed.Header.Date(); err != nil || !date.Equal(tc.msg.Date) {
+				t.Fatalf("Date = %v, %v", date, err)
+			}
+			if len(parsed.Header["Bcc"]) > 0 {
+				t.Fatal("a header was injected")
+			}
+		})
+	}
+}
+
+func TestEmailMessageMultipart(t *testing.T) {
+	msg := &EmailMessage{
+		FromName:  "Accounts",
+		From:      "no-reply@example.com",
+		To:        []string{"user@example.com"},
+		Subject:   "Login Link",
+		HTML:      "<p>é</p>",
+		Text:      "é",
+		Date:      testEmailDate,
+		MessageID: "0123456789abcdef@example.com",
+	}
+
+	b, err := msg.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := mail.ReadMessage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q, %v", mediaType, err)
+	}
+
+	// The plain text comes first, a client shows the last part it understands.
+	mr := multipart.NewReader(parsed.Body, params["boundary"])
+	for _, want := range []struct{ contentType, body string }{
+		{`text/plain; charset="UTF-8"`, "é"},
+		{`text/html; charset="UTF-8"`, "<p>é</p>"},
+	} {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(part)
+		if part.Header.Get("Content-Type") != want.contentType || string(body) != want.body {
+			t.Fatalf("part %q = %q", part.Header.Get("Content-Type"), body)
+		}
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Fatalf("more than two parts, err = %v", err)
+	}
+
+	// Fixed Date and Message-ID give the same bytes every time.
+	if again, _ := msg.Bytes(); !bytes.Equal(again, b) {
+		t.Fatal("the message is not reproducible")
+	}
+}
+
+func TestEmailMessageDefaults(t *testing.T) {
+	msg := &EmailMessage{From: "no-reply@example.com", To: []string{"user@example.com"}, Subject: "Hi", HTML: "<p>Hi</p>"}
+
+	before := time.Now().Add(-time.Second)
+	b, err := msg.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := mail.ReadMessage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if date, err := parsed.Header.Date(); err != nil || date.Before(before) || date.After(time.Now()) {
+		t.Fatalf("Date = %v, %v", date, err)
+	}
+	if id := parsed.Header.Get("Message-ID"); !regexp.MustCompile(`^<[0-9a-f]{32}@example\.com>$`).MatchString(id) {
+		t.Fatalf("Message-ID = %q", id)
+	}
+	if other, _ := msg.Bytes(); bytes.Equal(other, b) || strings.Contains(string(other), parsed.Header.Get("Message-ID")) {
+		t.Fatal("two messages got the same Message-ID")
+	}
+}
+
+func TestEmailMessageInvalidAddress(t *testing.T) {
+	for _, msg := range []*EmailMessage{
+		{From: "no-reply@example.com\r\nBcc: victim@example.com", To: []string{"user@example.com"}},
+		{From: "Accounts <no-reply@example.com>", To: []string{"user@example.com"}},
+		{From: "no-reply@example.com", To: []string{"user@example.com, victim@example.com"}},
+		{From: "no-reply@example.com", To: []string{"user@example.com\nBcc: victim@example.com"}},
+	} {
+		if _, err := msg.Bytes(); err != ErrInvalidEmailAddress {
+			t.Fatalf("%q -> %q: err = %v, want ErrInvalidEmailAddress", msg.From, msg.To, err)
+		}
+	}
+}
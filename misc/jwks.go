@@ -0,0 +1,279 @@
+package misc
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	JWT_ALG_HS512 = "HS512"
+	JWT_ALG_RS256 = "RS256"
+	JWT_ALG_ES256 = "ES256"
+	JWT_ALG_EDDSA = "EdDSA"
+
+	JWT_RSA_MIN_BITS = 2048
+)
+
+var (
+	ErrJWTKeyType       = errors.New("The JWT key must be RSA (2048 bits at least), ECDSA P-256 or Ed25519.")
+	ErrJWTKeyPEM        = errors.New("The JWT key file has no PEM block.")
+	ErrJWTDuplicateKid  = errors.New("Two JWT keys have the same kid.")
+	ErrJWTNoSigningKey  = errors.New("No JWT signing key, JWT_SIGNING_KID must name a private key.")
+	ErrJWTAmbiguousKey  = errors.New("More than one JWT private key, JWT_SIGNING_KID has to pick the signing one.")
+	ErrJWTUnknownKid    = errors.New("Unknown kid.")
+	ErrJWTUnexpectedAlg = errors.New("Unexpected alg.")
+)
+
+// JWTKey is a key of the keyset, SignKey is nil for a key that only verifies tokens signed before a rotation.
+type JWTKey struct {
+	Kid       string
+	Alg       string
+	Method    jwt.SigningMethod
+	SignKey   interface{}
+	VerifyKey interface{}
+}
+
+// NewHMACKey is the shared JWT_SECRET, its tokens have no kid and it is never published.
+func NewHMACKey(secret []byte) *JWTKey {
+	return &JWTKey{
+		Alg:       JWT_ALG_HS512,
+		Method:    jwt.SigningMethodHS512,
+		SignKey:   secret,
+		VerifyKey: secret,
+	}
+}
+
+// LoadJWTKeyFile reads a PEM key, the file name without its extension is the kid. Private keys can be PKCS#1,
+// SEC 1 or PKCS#8, public keys PKIX or PKCS#1. The alg follows from the key type.
+func LoadJWTKeyFile(path string) (*JWTKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, ErrJWTKeyPEM
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, ErrJWTKeyType
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &JWTKey{Kid: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.SignKey, key.VerifyKey = k, &k.PublicKey
+	case *ecdsa.PrivateKey:
+		key.SignKey, key.VerifyKey = k, &k.PublicKey
+	case ed25519.PrivateKey:
+		key.SignKey, key.VerifyKey = k, k.Public()
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		key.VerifyKey = k
+	default:
+		return nil, ErrJWTKeyType
+	}
+
+	switch k := key.VerifyKey.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < JWT_RSA_MIN_BITS {
+			return nil, ErrJWTKeyType
+		}
+		key.Alg, key.Method = JWT_ALG_RS256, jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return nil, ErrJWTKeyType
+		}
+		key.Alg, key.Method = JWT_ALG_ES256, jwt.SigningMethodES256
+	case ed25519.PublicKey:
+		key.Alg, key.Method = JWT_ALG_EDDSA, jwt.SigningMethodEdDSA
+	}
+
+	return key, nil
+}
+
+// LoadJWTKeyDir reads every *.pem of the directory, in file name order.
+func LoadJWTKeyDir(dir string) ([]*JWTKey, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	keys := []*JWTKey{}
+	for _, p := range paths {
+		if key, err := LoadJWTKeyFile(p); err != nil {
+			return nil, errors.New(p + " : " + err.Error())
+		} else {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+// JWTKeyset signs with one key and verifies with all of them, so the previous key keeps verifying the tokens it
+// signed after a rotation. Keys are looked up by the kid header, a token without kid only matches the HMAC key.
+type JWTKeyset struct {
+	Signer *JWTKey
+	Keys   map[string]*JWTKey
+}
+
+// NewJWTKeyset picks the private key named signingKid as signer, signingKid can be empty when only one private
+// key is given. secret, when not nil, keeps verifying the HS512 tokens issued before the move to asymmetric keys,
+// and signs when no key is given at all.
+func NewJWTKeyset(keys []*JWTKey, signingKid string, secret []byte) (*JWTKeyset, error) {
+	ks := &JWTKeyset{Keys: map[string]*JWTKey{}}
+	if secret != nil {
+		ks.Keys[""] = NewHMACKey(secret)
+	}
+
+	for _, key := range keys {
+		if _, ok := ks.Keys[key.Kid]; ok || key.Kid == "" {
+			return nil, ErrJWTDuplicateKid
+		}
+		ks.Keys[key.Kid] = key
+
+		if key.SignKey == nil || (signingKid != "" && key.Kid != signingKid) {
+			continue
+		} else if ks.Signer != nil {
+			return nil, ErrJWTAmbiguousKey
+		}
+		ks.Signer = key
+	}
+
+	if ks.Signer == nil {
+		if signingKid != "" || secret == nil {
+			return nil, ErrJWTNoSigningKey
+		}
+		ks.Signer = ks.Keys[""]
+	}
+
+	return ks, nil
+}
+
+// VerifyKey is the jwt.Keyfunc of the keyset, the alg of the header has to be the one of the key so a token can
+// not pick how it is verified.
+func (ks *JWTKeyset) VerifyKey(token *jwt.Token) (interface{}, error) {
+	kid := ""
+	if v, ok := token.Header["kid"]; ok {
+		if kid, ok = v.(string); !ok || kid == "" {
+			return nil, ErrJWTUnknownKid
+		}
+	}
+
+	key, ok := ks.Keys[kid]
+	if !ok {
+		return nil, ErrJWTUnknownKid
+	} else if token.Method == nil || token.Method.Alg() != key.Alg {
+		return nil, ErrJWTUnexpectedAlg
+	}
+
+	return key.VerifyKey, nil
+}
+
+// Algs lists the algs of the keyset, as accepted by the parser.
+func (ks *JWTKeyset) Algs() []string {
+	seen, algs := map[string]bool{}, []string{}
+	for _, key := range ks.Keys {
+		if !seen[key.Alg] {
+			seen[key.Alg] = true
+			algs = append(algs, key.Alg)
+		}
+	}
+	sort.Strings(algs)
+
+	return algs
+}
+
+// DeriveSecret derives a secret for label from the signing key, so every replica gets the same one without another
+// env variable. It changes with the signing key.
+func (ks *JWTKeyset) DeriveSecret(label string) ([]byte, error) {
+	material, ok := ks.Signer.SignKey.([]byte)
+	if !ok {
+		var err error
+		if material, err = x509.MarshalPKCS8PrivateKey(ks.Signer.SignKey); err != nil {
+			return nil, err
+		}
+	}
+
+	mac := hmac.New(sha256.New, material)
+	mac.Write([]byte(label))
+	return mac.Sum(nil), nil
+}
+
+// ================================================================
+// JWKS
+// ================================================================
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []*JWK `json:"keys"`
+}
+
+// JWKS publishes the public keys in kid order, the HMAC secret is left out.
+func (ks *JWTKeyset) JWKS() *JWKSet {
+	set := &JWKSet{Keys: []*JWK{}}
+	for _, key := range ks.Keys {
+		jwk := &JWK{Use: "sig", Alg: key.Alg, Kid: key.Kid}
+		switch k := key.VerifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+		case *ecdsa.PublicKey:
+			jwk.Kty, jwk.Crv = "EC", "P-256"
+			jwk.X = base64.RawURLEncoding.EncodeToString(k.X.FillBytes(make([]byte, 32)))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(k.Y.FillBytes(make([]byte, 32)))
+		case ed25519.PublicKey:
+			jwk.Kty, jwk.Crv = "OKP", "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(k)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+
+	return set
+}
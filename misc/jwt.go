@@ -4,8 +4,15 @@ import (
 	"github.com/golang-jwt/jwt"
 )
 
+const (
+	JWT_TYPE_ACCESS = "access"
+	JWT_TYPE_MFA    = "mfa"
+	JWT_TYPE_CLIENT = "client"
+	SCOPE_ADMIN     = "admin"
+)
+
 type JWT struct {
-	SigningKey []byte
+	Keyset *JWTKeyset
 }
 
 type EmailJwtClaims struct {
@@ -13,24 +20,51 @@ type EmailJwtClaims struct {
 	Email    string `json:"email"`
 	Type     string `json:"type"`
 	Continue string `json:"continue"`
+	Locale   string `json:"locale,omitempty"`
+}
+
+// AccessJwtClaims is the access token of a user, or with JWT_TYPE_CLIENT the token a client got for itself, its
+// Subject being the client then.
+type AccessJwtClaims struct {
+	jwt.StandardClaims
+	Type      string `json:"type"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	SessionID string `json:"sid,omitempty"`
 }
 
-func NewJWT(signingKey []byte) *JWT {
+// HasScope looks the scope up in the space separated Scope claim.
+func (c *AccessJwtClaims) HasScope(scope string) bool {
+	return HasScope(c.Scope, scope)
+}
+
+// MfaJwtClaims is handed out after the first factor, it can only be exchanged for tokens by passing a second one.
+type MfaJwtClaims struct {
+	jwt.StandardClaims
+	Type string `json:"type"`
+}
+
+func NewJWT(keyset *JWTKeyset) *JWT {
 	return &JWT{
-		SigningKey: signingKey,
+		Keyset: keyset,
 	}
 }
 
-func (j *JWT) GenToken(method jwt.SigningMethod, claims jwt.Claims) (string, error) {
-	token := jwt.NewWithClaims(method, claims)
+// GenToken signs with the signing key of the keyset, its kid goes to the header.
+func (j *JWT) GenToken(claims jwt.Claims) (string, error) {
+	signer := j.Keyset.Signer
+	token := jwt.NewWithClaims(signer.Method, claims)
+	if signer.Kid != "" {
+		token.Header["kid"] = signer.Kid
+	}
 
-	return token.SignedString(j.SigningKey)
+	return token.SignedString(signer.SignKey)
 }
 
+// Parse verifies with the key named by the kid header, any alg other than the one of that key is rejected.
 func (j *JWT) Parse(tokenStr string, claims jwt.Claims) (*jwt.Token, error) {
-	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (i interface{}, err error) {
-		return j.SigningKey, nil
-	})
+	parser := &jwt.Parser{ValidMethods: j.Keyset.Algs()}
+	token, err := parser.ParseWithClaims(tokenStr, claims, j.Keyset.VerifyKey)
 
 	return token, err
 }
@@ -0,0 +1,150 @@
+package misc
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+const (
+	EMAIL_TYPE_SIGN_UP          = "signup"
+	EMAIL_TYPE_FORGET_PWD       = "forgetpwd"
+	EMAIL_TYPE_MAGIC_LOGIN      = "magiclogin"
+	EMAIL_TYPE_UNLOCK           = "unlock"
+	EMAIL_TYPE_ACCOUNT_EXISTS   = "accountexists"
+	EMAIL_TYPE_NO_ACCOUNT       = "noaccount"
+	EMAIL_TYPE_ACCOUNT_DISABLED = "accountdisabled"
+
+	DefaultLocale = "en"
+
+	// Texts of the emails added after the signup and forget password ones, their env is optional.
+	DefaultMagicLinkEmailSubject       = "Login Link"
+	DefaultMagicLinkEmailContent       = "This is your login link, please follow below link to log in."
+	DefaultMagicLinkEmailLinkText      = "Click to log in"
+	DefaultUnlockEmailSubject          = "Your account is locked"
+	DefaultUnlockEmailContent          = "Your account was locked after too many failed logins. If it was you, follow below link to unlock it."
+	DefaultUnlockEmailLinkText         = "Click to unlock your account"
+	DefaultAccountDisabledEmailSubject = "Account request"
+	DefaultAccountDisabledEmailContent = "Someone tried to log in with this email address, but its account is disabled. Please contact the support if you need it back."
+)
+
+type EmailTexts struct {
+	Subject  string `json:"subject"`
+	Content  string `json:"content"`
+	LinkText string `json:"linkText"`
+}
+
+// Localizer holds the email texts of every supported locale. Texts missing from a bundle fall back to the
+// default locale, then to the texts configured by env.
+type Localizer struct {
+	defaultLocale string
+	bundles       map[string]map[string]EmailTexts
+	fallback      map[string]EmailTexts
+	locales       []string
+	matcher       language.Matcher
+}
+
+func NewLocalizer(defaultLocale string, fallback map[string]EmailTexts) (*Localizer, error) {
+	tag, err := language.Parse(defaultLocale)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Localizer{
+		defaultLocale: tag.String(),
+		bundles:       map[string]map[string]EmailTexts{},
+		fallback:      fallback,
+	}
+	l.buildMatcher()
+
+	return l, nil
+}
+
+// LoadDir reads one <locale>.json per locale, e.g. zh-TW.json:
+// {"signup": {"subject": "...", "content": "...", "linkText": "..."}, "forgetpwd": {...}}
+func (l *Localizer) LoadDir(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	for _, p := range paths {
+		tag, err := language.Parse(strings.TrimSuffix(filepath.Base(p), ".json"))
+		if err != nil {
+			return err
+		}
+
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		bundle := map[string]EmailTexts{}
+		if err := json.Unmarshal(b, &bundle); err != nil {
+			return err
+		}
+
+		l.bundles[tag.String()] = bundle
+	}
+
+	l.buildMatcher()
+	return nil
+}
+
+func (l *Localizer) buildMatcher() {
+	l.locales = []string{l.defaultLocale}
+	for locale := range l.bundles {
+		if locale != l.defaultLocale {
+			l.locales = append(l.locales, locale)
+		}
+	}
+
+	tags := make([]language.Tag, len(l.locales))
+	for i, locale := range l.locales {
+		tags[i] = language.MustParse(locale)
+	}
+	l.matcher = language.NewMatcher(tags)
+}
+
+// Resolve returns the supported locale closest to the first preference that matches any, every preference is a
+// single tag or an Accept-Language value. It returns the default locale when nothing matches.
+func (l *Localizer) Resolve(preferences ...string) string {
+	for _, pref := range preferences {
+		if pref = strings.TrimSpace(pref); pref == "" {
+			continue
+		}
+
+		tags, _, err := language.ParseAcceptLanguage(pref)
+		if err != nil || len(tags) == 0 {
+			continue
+		}
+
+		if _, i, confidence := l.matcher.Match(tags...); confidence != language.No {
+			return l.locales[i]
+		}
+	}
+
+	return l.defaultLocale
+}
+
+// Texts returns the texts of an email type, field by field from the locale, the default locale and env.
+func (l *Localizer) Texts(locale, typ string) EmailTexts {
+	texts := EmailTexts{}
+
+	for _, source := range []EmailTexts{l.bundles[locale][typ], l.bundles[l.defaultLocale][typ], l.fallback[typ]} {
+		if texts.Subject == "" {
+			texts.Subject = source.Subject
+		}
+		if texts.Content == "" {
+			texts.Content = source.Content
+		}
+		if texts.LinkText == "" {
+			texts.LinkText = source.LinkText
+		}
+	}
+
+	return texts
+}
@@ -0,0 +1,37 @@
+package misc
+
+import "time"
+
+const (
+	DefaultLockoutThreshold = 5
+	DefaultLockoutBaseSecs  = 60
+	DefaultLockoutMaxSecs   = 3600
+	DefaultLockoutResetSecs = 86400
+)
+
+// LockoutPolicy locks an identity for BaseSecs after Threshold failed logins, every further lock doubles the
+// duration up to MaxSecs. Counters are forgotten once no failure happened for ResetSecs.
+type LockoutPolicy struct {
+	Threshold int
+	BaseSecs  int
+	MaxSecs   int
+	ResetSecs int
+}
+
+func NewLockoutPolicy() *LockoutPolicy {
+	return &LockoutPolicy{
+		Threshold: DefaultLockoutThreshold,
+		BaseSecs:  DefaultLockoutBaseSecs,
+		MaxSecs:   DefaultLockoutMaxSecs,
+		ResetSecs: DefaultLockoutResetSecs,
+	}
+}
+
+// Enabled is false when LOGIN_LOCKOUT_THRESHOLD is 0.
+func (p *LockoutPolicy) Enabled() bool {
+	return p.Threshold > 0
+}
+
+func (p *LockoutPolicy) ResetWindow() time.Duration {
+	return time.Duration(p.ResetSecs) * time.Second
+}
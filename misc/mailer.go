@@ -0,0 +1,118 @@
+package misc
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	MAIL_TRANSPORT_SMTP = "smtp"
+	MAIL_TRANSPORT_FILE = "file"
+	MAIL_TRANSPORT_LOG  = "log"
+)
+
+// Mailer delivers rendered emails, *Email sends them through SMTP.
+type Mailer interface {
+	Send(m *EmailMessage) error
+}
+
+// ================================================================
+// File
+// ================================================================
+
+// FileMailer writes every email as an .eml file into Dir, for development and for inspecting what would be sent.
+type FileMailer struct {
+	Dir string
+}
+
+func NewFileMailer(dir string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &FileMailer{Dir: dir}, nil
+}
+
+func (f *FileMailer) Send(m *EmailMessage) error {
+	msg, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+
+	name := strconv.FormatInt(time.Now().UnixNano(), 10) + "-" + uuid.New().String() + ".eml"
+	return os.WriteFile(filepath.Join(f.Dir, name), msg, 0644)
+}
+
+// ================================================================
+// Log
+// ================================================================
+
+// LogMailer only logs the recipients and the subject, the content (and so the tokens) never reaches the log.
+type LogMailer struct{}
+
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+func (l *LogMailer) Send(m *EmailMessage) error {
+	log.Printf("[mail] to: %v, subject: %s", m.To, m.Subject)
+	return nil
+}
+
+// ================================================================
+// Capture
+// ================================================================
+
+// CaptureMailer keeps the emails in memory, tests read them back instead of going through a mailbox.
+type CaptureMailer struct {
+	mu       sync.Mutex
+	messages []*EmailMessage
+}
+
+func NewCaptureMailer() *CaptureMailer {
+	return &CaptureMailer{}
+}
+
+func (c *CaptureMailer) Send(m *EmailMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.messages = append(c.messages, m)
+	return nil
+}
+
+func (c *CaptureMailer) Messages() []*EmailMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]*EmailMessage(nil), c.messages...)
+}
+
+// Last returns the latest email sent to the address, or nil.
+func (c *CaptureMailer) Last(to string) *EmailMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := len(c.messages) - 1; i >= 0; i-- {
+		for _, addr := range c.messages[i].To {
+			if addr == to {
+				return c.messages[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *CaptureMailer) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.messages = nil
+}
@@ -0,0 +1,90 @@
+package misc
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	SCOPE_OPENID         = "openid"
+	SCOPE_EMAIL          = "email"
+	SCOPE_USER_PROTOTYPE = "user.prototype"
+
+	OAUTH_RESPONSE_TYPE_CODE       = "code"
+	OAUTH_CODE_CHALLENGE_S256      = "S256"
+	OAUTH_GRANT_AUTHORIZATION_CODE = "authorization_code"
+	OAUTH_GRANT_REFRESH_TOKEN      = "refresh_token"
+	OAUTH_GRANT_CLIENT_CREDENTIALS = "client_credentials"
+
+	OAUTH_ERR_INVALID_REQUEST           = "invalid_request"
+	OAUTH_ERR_INVALID_CLIENT            = "invalid_client"
+	OAUTH_ERR_INVALID_GRANT             = "invalid_grant"
+	OAUTH_ERR_INVALID_SCOPE             = "invalid_scope"
+	OAUTH_ERR_UNAUTHORIZED_CLIENT       = "unauthorized_client"
+	OAUTH_ERR_UNSUPPORTED_GRANT_TYPE    = "unsupported_grant_type"
+	OAUTH_ERR_UNSUPPORTED_RESPONSE_TYPE = "unsupported_response_type"
+	OAUTH_ERR_ACCESS_DENIED             = "access_denied"
+	OAUTH_ERR_LOGIN_REQUIRED            = "login_required"
+	OAUTH_ERR_SERVER_ERROR              = "server_error"
+)
+
+// OIDCScopes are granted by a user through the authorization endpoint.
+var OIDCScopes = []string{SCOPE_OPENID, SCOPE_EMAIL}
+
+// ClientScopes are granted to the client itself by the client_credentials grant, they let internal services call
+// the API.
+var ClientScopes = []string{SCOPE_USER_PROTOTYPE}
+
+// OIDCConfig enables the OpenID Connect provider. The authorization endpoint has no user interface, it sends the
+// browser to LoginPageURL with the authorization request in the query string.
+type OIDCConfig struct {
+	Issuer       string
+	LoginPageURL string
+}
+
+// IDJwtClaims is the ID token handed to the client, Audience is its client_id.
+type IDJwtClaims struct {
+	jwt.StandardClaims
+	Nonce         string `json:"nonce,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+}
+
+// VerifyPKCE checks a code_verifier against the S256 code_challenge of the authorization request.
+func VerifyPKCE(verifier, challenge string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// ScopeIncludes tells whether every scope of the space separated requested is part of allowed.
+func ScopeIncludes(allowed, requested string) bool {
+	set := map[string]bool{}
+	for _, s := range strings.Fields(allowed) {
+		set[s] = true
+	}
+
+	for _, s := range strings.Fields(requested) {
+		if !set[s] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// HasScope looks the scope up in a space separated list of scopes.
+func HasScope(scopes, scope string) bool {
+	for _, s := range strings.Fields(scopes) {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
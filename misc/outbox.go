@@ -0,0 +1,66 @@
+package misc
+
+import "time"
+
+const (
+	DefaultEmailOutboxWorkers     = 4
+	DefaultEmailOutboxBatchSize   = 4
+	DefaultEmailOutboxMaxAttempts = 8
+	DefaultEmailOutboxBaseSecs    = 30
+	DefaultEmailOutboxMaxSecs     = 3600
+	DefaultEmailOutboxPollSecs    = 5
+	DefaultEmailOutboxLeaseSecs   = 300
+)
+
+// EmailOutboxPolicy controls the delivery of queued emails. A failed attempt is retried after BaseSecs, every
+// further failure doubles the delay up to MaxSecs, and a message is given up after MaxAttempts.
+type EmailOutboxPolicy struct {
+	Workers     int
+	BatchSize   int
+	MaxAttempts int
+	BaseSecs    int
+	MaxSecs     int
+	PollSecs    int
+	LeaseSecs   int
+}
+
+func NewEmailOutboxPolicy() *EmailOutboxPolicy {
+	return &EmailOutboxPolicy{
+		Workers:     DefaultEmailOutboxWorkers,
+		BatchSize:   DefaultEmailOutboxBatchSize,
+		MaxAttempts: DefaultEmailOutboxMaxAttempts,
+		BaseSecs:    DefaultEmailOutboxBaseSecs,
+		MaxSecs:     DefaultEmailOutboxMaxSecs,
+		PollSecs:    DefaultEmailOutboxPollSecs,
+		LeaseSecs:   DefaultEmailOutboxLeaseSecs,
+	}
+}
+
+// Backoff is the delay before the next attempt of a message that already failed attempts times.
+func (p *EmailOutboxPolicy) Backoff(attempts int) time.Duration {
+	secs := p.BaseSecs
+	for i := 1; i < attempts && secs < p.MaxSecs; i++ {
+		secs *= 2
+	}
+	if secs > p.MaxSecs {
+		secs = p.MaxSecs
+	}
+
+	return time.Duration(secs) * time.Second
+}
+
+func (p *EmailOutboxPolicy) PollInterval() time.Duration {
+	return time.Duration(p.PollSecs) * time.Second
+}
+
+// Lease is how long a claimed message stays with its worker, a message of a crashed worker is picked up again
+// after it.
+func (p *EmailOutboxPolicy) Lease() time.Duration {
+	return time.Duration(p.LeaseSecs) * time.Second
+}
+
+// MinLeaseSecs is the time a batch may take when every message runs into the SMTP timeout. A shorter lease expires
+// while the batch is still being sent, and another replica sends the same message a second time.
+func (p *EmailOutboxPolicy) MinLeaseSecs() int {
+	return p.BatchSize * int(SMTP_COMMAND_TIMEOUT/time.Second)
+}
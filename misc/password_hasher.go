@@ -0,0 +1,402 @@
+package misc
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	PWD_ALGO_BCRYPT   = "bcrypt"
+	PWD_ALGO_ARGON2ID = "argon2id"
+	PWD_ALGO_SCRYPT   = "scrypt"
+
+	DefaultArgon2MemoryKB    = 64 * 1024
+	DefaultArgon2Iterations  = 3
+	DefaultArgon2Parallelism = 2
+	DefaultScryptLogN        = 15
+	DefaultScryptR           = 8
+	DefaultScryptP           = 1
+
+	pwdHashSaltBytes = 16
+	pwdHashKeyBytes  = 32
+
+	// Stored and imported hashes carry their own parameters, they are bounded so a crafted hash can't make a single
+	// verification take the memory or the time of the whole service.
+	maxPwdHashKeyBytes   = 128
+	maxArgon2MemoryKB    = 1024 * 1024
+	maxArgon2Iterations  = 64
+	maxArgon2Parallelism = 16
+	maxScryptLogN        = 20
+	maxScryptR           = 32
+	maxScryptP           = 16
+	maxScryptMemoryBytes = 1 << 30
+	maxPbkdf2Iterations  = 10000000
+)
+
+var (
+	ErrInvalidPasswordHash = errors.New("Invalid password hash")
+	ErrUnknownPasswordAlgo = errors.New("Unknown password hash algorithm")
+)
+
+// PasswordHasher produces and checks encoded password hashes of a single algorithm.
+// salt is the per-user salt kept in the users table, algorithms that embed their own salt may ignore it.
+type PasswordHasher interface {
+	Algorithm() string
+	Hash(password, salt []byte) (string, error)
+	Verify(encoded string, password, salt []byte) (bool, error)
+	// NeedsRehash reports whether encoded, produced by this algorithm, uses weaker parameters than the hasher.
+	NeedsRehash(encoded string) bool
+}
+
+// ================================================================
+// Registry
+// ================================================================
+var (
+	passwordHashersMu sync.RWMutex
+	passwordHashers   = map[string]PasswordHasher{
+		PWD_ALGO_BCRYPT:   NewBcryptHasher(bcrypt.DefaultCost),
+		PWD_ALGO_ARGON2ID: NewArgon2idHasher(DefaultArgon2MemoryKB, DefaultArgon2Iterations, DefaultArgon2Parallelism),
+		PWD_ALGO_SCRYPT:   NewScryptHasher(DefaultScryptLogN, DefaultScryptR, DefaultScryptP),
+	}
+)
+
+// RegisterPasswordHasher makes an algorithm available to LookupPasswordHasher, replacing any previous one.
+func RegisterPasswordHasher(h PasswordHasher) {
+	passwordHashersMu.Lock()
+	defer passwordHashersMu.Unlock()
+	passwordHashers[h.Algorithm()] = h
+}
+
+func LookupPasswordHasher(algo string) (PasswordHasher, error) {
+	passwordHashersMu.RLock()
+	defer passwordHashersMu.RUnlock()
+	if h, ok := passwordHashers[algo]; ok {
+		return h, nil
+	}
+
+	return nil, ErrUnknownPasswordAlgo
+}
+
+// VerifyPassword checks password against an encoded hash stored with its algorithm tag.
+func VerifyPassword(algo, encoded string, password, salt []byte) (bool, error) {
+	h, err := LookupPasswordHasher(algo)
+	if err != nil {
+		return false, err
+	}
+
+	return h.Verify(encoded, password, salt)
+}
+
+var (
+	dummyHashesMu sync.Mutex
+	dummyHashes   = map[PasswordHasher]string{}
+)
+
+// DummyVerifyPassword spends the same time as checking a real password with h, it is used when the identity
+// does not exist so the response time doesn't tell that apart. The dummy hash is made once per hasher, a failed
+// attempt is not cached so the next call tries again.
+func DummyVerifyPassword(h PasswordHasher, password string) error {
+	dummyHashesMu.Lock()
+	encoded, ok := dummyHashes[h]
+	if !ok {
+		salt, err := randomBytes(pwdHashSaltBytes)
+		if err == nil {
+			encoded, err = h.Hash(salt, salt)
+		}
+		if err != nil {
+			dummyHashesMu.Unlock()
+			return err
+		}
+		dummyHashes[h] = encoded
+	}
+	dummyHashesMu.Unlock()
+
+	_, err := h.Verify(encoded, []byte(password), nil)
+	return err
+}
+
+// ================================================================
+// bcrypt
+// ================================================================
+// BcryptHasher keeps the original scheme of this service: the per-user salt is appended to the password.
+type BcryptHasher struct {
+	Cost int
+}
+
+func NewBcryptHasher(cost int) *BcryptHasher {
+	return &BcryptHasher{Cost: cost}
+}
+
+func (h *BcryptHasher) Algorithm() string {
+	return PWD_ALGO_BCRYPT
+}
+
+func (h *BcryptHasher) Hash(password, salt []byte) (string, error) {
+	hashBytes, err := bcrypt.GenerateFromPassword(saltedPassword(password, salt), h.Cost)
+	return string(hashBytes), err
+}
+
+func (h *BcryptHasher) Verify(encoded string, password, salt []byte) (bool, error) {
+	// Hashes written into the former BINARY(64) column are padded with NUL bytes.
+	encoded = strings.TrimRight(encoded, "\x00")
+	if err := bcrypt.CompareHashAndPassword([]byte(encoded), saltedPassword(password, salt)); err != nil {
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(strings.TrimRight(encoded, "\x00")))
+	return err != nil || cost < h.Cost
+}
+
+func saltedPassword(password, salt []byte) []byte {
+	salted := make([]byte, 0, len(password)+len(salt))
+	return append(append(salted, password...), salt...)
+}
+
+// ================================================================
+// Argon2id
+// ================================================================
+// Argon2idHasher encodes as $argon2id$v=19$m=<KiB>,t=<iterations>,p=<parallelism>$<salt>$<hash>.
+type Argon2idHasher struct {
+	MemoryKB    uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+func NewArgon2idHasher(memoryKB, iterations uint32, parallelism uint8) *Argon2idHasher {
+	return &Argon2idHasher{
+		MemoryKB:    memoryKB,
+		Iterations:  iterations,
+		Parallelism: parallelism,
+	}
+}
+
+func (h *Argon2idHasher) Algorithm() string {
+	return PWD_ALGO_ARGON2ID
+}
+
+func (h *Argon2idHasher) Hash(password, _ []byte) (string, error) {
+	salt, err := randomBytes(pwdHashSaltBytes)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey(password, salt, h.Iterations, h.MemoryKB, h.Parallelism, pwdHashKeyBytes)
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.MemoryKB, h.Iterations, h.Parallelism, encodePHC(salt), encodePHC(key),
+	), nil
+}
+
+func (h *Argon2idHasher) Verify(encoded string, password, _ []byte) (bool, error) {
+	p, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	key := argon2.IDKey(password, p.salt, p.iterations, p.memoryKB, p.parallelism, uint32(len(p.key)))
+	return subtle.ConstantTimeCompare(key, p.key) == 1, nil
+}
+
+// Validate checks the configured parameters against the bounds enforced on stored hashes.
+func (h *Argon2idHasher) Validate() error {
+	return checkArgon2idParams(h.MemoryKB, h.Iterations, h.Parallelism)
+}
+
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	p, err := decodeArgon2id(encoded)
+	return err != nil || p.memoryKB < h.MemoryKB || p.iterations < h.Iterations || p.parallelism < h.Parallelism
+}
+
+type argon2idParams struct {
+	memoryKB    uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+// argon2.IDKey panics on t=0 or p=0.
+func checkArgon2idParams(memoryKB, iterations uint32, parallelism uint8) error {
+	if iterations < 1 || iterations > maxArgon2Iterations || parallelism < 1 || parallelism > maxArgon2Parallelism {
+		return ErrInvalidPasswordHash
+	}
+	if memoryKB < 8*uint32(parallelism) || memoryKB > maxArgon2MemoryKB {
+		return ErrInvalidPasswordHash
+	}
+
+	return nil
+}
+
+func decodeArgon2id(encoded string) (*argon2idParams, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != PWD_ALGO_ARGON2ID {
+		return nil, ErrInvalidPasswordHash
+	}
+
+	var (
+		version int
+		p       argon2idParams
+		err     error
+	)
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, ErrInvalidPasswordHash
+	}
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memoryKB, &p.iterations, &p.parallelism); err != nil {
+		return nil, ErrInvalidPasswordHash
+	}
+	if err = checkArgon2idParams(p.memoryKB, p.iterations, p.parallelism); err != nil {
+		return nil, err
+	}
+	if p.salt, err = decodePHC(parts[4]); err != nil {
+		return nil, ErrInvalidPasswordHash
+	}
+	if p.key, err = decodePHC(parts[5]); err != nil || len(p.key) == 0 || len(p.key) > maxPwdHashKeyBytes {
+		return nil, ErrInvalidPasswordHash
+	}
+
+	return &p, nil
+}
+
+// ================================================================
+// scrypt
+// ================================================================
+// ScryptHasher encodes as $scrypt$ln=<log2 N>,r=<r>,p=<p>$<salt>$<hash>.
+type ScryptHasher struct {
+	LogN int
+	R    int
+	P    int
+}
+
+func NewScryptHasher(logN, r, p int) *ScryptHasher {
+	return &ScryptHasher{
+		LogN: logN,
+		R:    r,
+		P:    p,
+	}
+}
+
+func (h *ScryptHasher) Algorithm() string {
+	return PWD_ALGO_SCRYPT
+}
+
+func (h *ScryptHasher) Hash(password, _ []byte) (string, error) {
+	salt, err := randomBytes(pwdHashSaltBytes)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := scrypt.Key(password, salt, 1<<h.LogN, h.R, h.P, pwdHashKeyBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s", h.LogN, h.R, h.P, encodePHC(salt), encodePHC(key)), nil
+}
+
+func (h *ScryptHasher) Verify(encoded string, password, _ []byte) (bool, error) {
+	p, err := decodeScrypt(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	key, err := scrypt.Key(password, p.salt, 1<<p.logN, p.r, p.p, len(p.key))
+	if err != nil {
+		return false, err
+	}
+
+	return subtle.ConstantTimeCompare(key, p.key) == 1, nil
+}
+
+// Validate checks the configured parameters against the bounds enforced on stored hashes.
+func (h *ScryptHasher) Validate() error {
+	return checkScryptParams(h.LogN, h.R, h.P)
+}
+
+func (h *ScryptHasher) NeedsRehash(encoded string) bool {
+	p, err := decodeScrypt(encoded)
+	return err != nil || p.logN < h.LogN || p.r < h.R || p.p < h.P
+}
+
+type scryptParams struct {
+	logN int
+	r    int
+	p    int
+	salt []byte
+	key  []byte
+}
+
+// scrypt allocates 128 * r * N bytes.
+func checkScryptParams(logN, r, p int) error {
+	if logN < 1 || logN > maxScryptLogN || r < 1 || r > maxScryptR || p < 1 || p > maxScryptP {
+		return ErrInvalidPasswordHash
+	}
+	if 128*r<<logN > maxScryptMemoryBytes {
+		return ErrInvalidPasswordHash
+	}
+
+	return nil
+}
+
+func decodeScrypt(encoded string) (*scryptParams, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 || parts[1] != PWD_ALGO_SCRYPT {
+		return nil, ErrInvalidPasswordHash
+	}
+
+	var (
+		p   scryptParams
+		err error
+	)
+	if _, err = fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &p.logN, &p.r, &p.p); err != nil {
+		return nil, ErrInvalidPasswordHash
+	}
+	if err = checkScryptParams(p.logN, p.r, p.p); err != nil {
+		return nil, err
+	}
+	if p.salt, err = decodePHC(parts[3]); err != nil {
+		return nil, ErrInvalidPasswordHash
+	}
+	if p.key, err = decodePHC(parts[4]); err != nil || len(p.key) == 0 || len(p.key) > maxPwdHashKeyBytes {
+		return nil, ErrInvalidPasswordHash
+	}
+
+	return &p, nil
+}
+
+// ================================================================
+// Helpers
+// ================================================================
+func randomBytes(size int) ([]byte, error) {
+	b := make([]byte, size)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// PHC strings use standard base64 without padding.
+func encodePHC(b []byte) string {
+	return base64.RawStdEncoding.EncodeToString(b)
+}
+
+func decodePHC(s string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "="))
+}
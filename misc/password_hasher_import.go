@@ -0,0 +1,290 @@
+package misc
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	PWD_ALGO_PBKDF2          = "pbkdf2"
+	PWD_ALGO_DJANGO          = "django"
+	PWD_ALGO_FIREBASE_SCRYPT = "firebase-scrypt"
+)
+
+// ImportablePasswordHasher is implemented by hashers whose hashes can be brought in from another system.
+// They never use the per-user salt, the salt is part of the encoded hash.
+type ImportablePasswordHasher interface {
+	PasswordHasher
+	CheckEncoded(encoded string) error
+}
+
+func init() {
+	RegisterPasswordHasher(&Pbkdf2Hasher{})
+	RegisterPasswordHasher(&DjangoHasher{})
+}
+
+// LookupImportableHasher only returns hashers that accept foreign hashes.
+func LookupImportableHasher(algo string) (ImportablePasswordHasher, error) {
+	h, err := LookupPasswordHasher(algo)
+	if err != nil {
+		return nil, err
+	}
+
+	if ih, ok := h.(ImportablePasswordHasher); ok {
+		return ih, nil
+	}
+
+	return nil, ErrUnknownPasswordAlgo
+}
+
+func (h *Argon2idHasher) CheckEncoded(encoded string) error {
+	_, err := decodeArgon2id(encoded)
+	return err
+}
+
+func (h *ScryptHasher) CheckEncoded(encoded string) error {
+	_, err := decodeScrypt(encoded)
+	return err
+}
+
+// ================================================================
+// PBKDF2
+// ================================================================
+// Pbkdf2Hasher verifies the passlib format $pbkdf2[-sha256|-sha512]$<rounds>$<salt>$<hash>,
+// salt and hash use passlib's adapted base64 ('.' in place of '+'). The PHC variant with "i=<rounds>" is accepted too.
+// It is verify-only, new hashes are never produced with it.
+type Pbkdf2Hasher struct{}
+
+func (h *Pbkdf2Hasher) Algorithm() string {
+	return PWD_ALGO_PBKDF2
+}
+
+func (h *Pbkdf2Hasher) Hash(_, _ []byte) (string, error) {
+	return "", ErrUnknownPasswordAlgo
+}
+
+func (h *Pbkdf2Hasher) Verify(encoded string, password, _ []byte) (bool, error) {
+	p, err := decodePbkdf2(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	key := pbkdf2.Key(password, p.salt, p.iterations, len(p.key), p.digest)
+	return subtle.ConstantTimeCompare(key, p.key) == 1, nil
+}
+
+func (h *Pbkdf2Hasher) NeedsRehash(_ string) bool {
+	return true
+}
+
+func (h *Pbkdf2Hasher) CheckEncoded(encoded string) error {
+	_, err := decodePbkdf2(encoded)
+	return err
+}
+
+type pbkdf2Params struct {
+	digest     func() hash.Hash
+	iterations int
+	salt       []byte
+	key        []byte
+}
+
+func decodePbkdf2(encoded string) (*pbkdf2Params, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 || parts[0] != "" {
+		return nil, ErrInvalidPasswordHash
+	}
+
+	var (
+		p   pbkdf2Params
+		err error
+	)
+	switch parts[1] {
+	case "pbkdf2", "pbkdf2-sha1":
+		p.digest = sha1.New
+	case "pbkdf2-sha256":
+		p.digest = sha256.New
+	case "pbkdf2-sha512":
+		p.digest = sha512.New
+	default:
+		return nil, ErrInvalidPasswordHash
+	}
+
+	if p.iterations, err = strconv.Atoi(strings.TrimPrefix(parts[2], "i=")); err != nil || p.iterations <= 0 || p.iterations > maxPbkdf2Iterations {
+		return nil, ErrInvalidPasswordHash
+	}
+	if p.salt, err = decodeAdaptedBase64(parts[3]); err != nil {
+		return nil, ErrInvalidPasswordHash
+	}
+	if p.key, err = decodeAdaptedBase64(parts[4]); err != nil || len(p.key) == 0 || len(p.key) > maxPwdHashKeyBytes {
+		return nil, ErrInvalidPasswordHash
+	}
+
+	return &p, nil
+}
+
+func decodeAdaptedBase64(s string) ([]byte, error) {
+	return decodePHC(strings.ReplaceAll(s, ".", "+"))
+}
+
+// ================================================================
+// Django
+// ================================================================
+// DjangoHasher verifies Django's <algorithm>$<iterations>$<salt>$<base64 hash> for pbkdf2_sha256 and pbkdf2_sha1.
+// It is verify-only, new hashes are never produced with it.
+type DjangoHasher struct{}
+
+func (h *DjangoHasher) Algorithm() string {
+	return PWD_ALGO_DJANGO
+}
+
+func (h *DjangoHasher) Hash(_, _ []byte) (string, error) {
+	return "", ErrUnknownPasswordAlgo
+}
+
+func (h *DjangoHasher) Verify(encoded string, password, _ []byte) (bool, error) {
+	p, err := decodeDjango(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	key := pbkdf2.Key(password, p.salt, p.iterations, len(p.key), p.digest)
+	return subtle.ConstantTimeCompare(key, p.key) == 1, nil
+}
+
+func (h *DjangoHasher) NeedsRehash(_ string) bool {
+	return true
+}
+
+func (h *DjangoHasher) CheckEncoded(encoded string) error {
+	_, err := decodeDjango(encoded)
+	return err
+}
+
+func decodeDjango(encoded string) (*pbkdf2Params, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 {
+		return nil, ErrInvalidPasswordHash
+	}
+
+	var (
+		p   pbkdf2Params
+		err error
+	)
+	switch parts[0] {
+	case "pbkdf2_sha256":
+		p.digest = sha256.New
+	case "pbkdf2_sha1":
+		p.digest = sha1.New
+	default:
+		return nil, ErrInvalidPasswordHash
+	}
+
+	if p.iterations, err = strconv.Atoi(parts[1]); err != nil || p.iterations <= 0 || p.iterations > maxPbkdf2Iterations {
+		return nil, ErrInvalidPasswordHash
+	}
+	if p.salt = []byte(parts[2]); len(p.salt) == 0 {
+		return nil, ErrInvalidPasswordHash
+	}
+	if p.key, err = base64.StdEncoding.DecodeString(parts[3]); err != nil || len(p.key) == 0 || len(p.key) > maxPwdHashKeyBytes {
+		return nil, ErrInvalidPasswordHash
+	}
+
+	return &p, nil
+}
+
+// ================================================================
+// Firebase scrypt
+// ================================================================
+// FirebaseScryptHasher verifies hashes exported from Firebase Authentication. The project wide parameters come
+// from the hash config of the export, the stored value is <base64 salt>$<base64 passwordHash>.
+// It is verify-only, new hashes are never produced with it.
+type FirebaseScryptHasher struct {
+	SignerKey     []byte
+	SaltSeparator []byte
+	Rounds        int
+	MemCost       int
+}
+
+func NewFirebaseScryptHasher(signerKey, saltSeparator []byte, rounds, memCost int) *FirebaseScryptHasher {
+	return &FirebaseScryptHasher{
+		SignerKey:     signerKey,
+		SaltSeparator: saltSeparator,
+		Rounds:        rounds,
+		MemCost:       memCost,
+	}
+}
+
+func (h *FirebaseScryptHasher) Algorithm() string {
+	return PWD_ALGO_FIREBASE_SCRYPT
+}
+
+func (h *FirebaseScryptHasher) Hash(_, _ []byte) (string, error) {
+	return "", ErrUnknownPasswordAlgo
+}
+
+func (h *FirebaseScryptHasher) Verify(encoded string, password, _ []byte) (bool, error) {
+	salt, expected, err := decodeFirebaseScrypt(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	derivedKey, err := scrypt.Key(password, append(salt, h.SaltSeparator...), 1<<h.MemCost, h.Rounds, 1, 32)
+	if err != nil {
+		return false, err
+	}
+
+	block, err := aes.NewCipher(derivedKey)
+	if err != nil {
+		return false, err
+	}
+
+	signed := make([]byte, len(h.SignerKey))
+	cipher.NewCTR(block, make([]byte, aes.BlockSize)).XORKeyStream(signed, h.SignerKey)
+
+	return subtle.ConstantTimeCompare(signed, expected) == 1, nil
+}
+
+func (h *FirebaseScryptHasher) NeedsRehash(_ string) bool {
+	return true
+}
+
+func (h *FirebaseScryptHasher) CheckEncoded(encoded string) error {
+	_, _, err := decodeFirebaseScrypt(encoded)
+	return err
+}
+
+// EncodeFirebaseScrypt joins the separate salt and hash columns of a Firebase export into one stored value.
+func EncodeFirebaseScrypt(salt, passwordHash string) string {
+	return salt + "$" + passwordHash
+}
+
+func decodeFirebaseScrypt(encoded string) ([]byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 2 {
+		return nil, nil, ErrInvalidPasswordHash
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil || len(salt) == 0 {
+		return nil, nil, ErrInvalidPasswordHash
+	}
+
+	passwordHash, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil || len(passwordHash) == 0 {
+		return nil, nil, ErrInvalidPasswordHash
+	}
+
+	return salt, passwordHash, nil
+}
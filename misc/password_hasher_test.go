@@ -0,0 +1,56 @@
+package misc
+
+import "testing"
+
+func TestDecodeRejectsUnboundedParams(t *testing.T) {
+	const (
+		salt = "c29tZXNhbHRzb21lc2FsdA"
+		key  = "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+	)
+
+	cases := []struct {
+		name    string
+		hasher  ImportablePasswordHasher
+		encoded string
+	}{
+		{"argon2id t=0", &Argon2idHasher{}, "$argon2id$v=19$m=65536,t=0,p=2$" + salt + "$" + key},
+		{"argon2id p=0", &Argon2idHasher{}, "$argon2id$v=19$m=65536,t=3,p=0$" + salt + "$" + key},
+		{"argon2id huge m", &Argon2idHasher{}, "$argon2id$v=19$m=4294967295,t=3,p=2$" + salt + "$" + key},
+		{"argon2id huge t", &Argon2idHasher{}, "$argon2id$v=19$m=65536,t=100000,p=2$" + salt + "$" + key},
+		{"scrypt r=0", &ScryptHasher{}, "$scrypt$ln=15,r=0,p=1$" + salt + "$" + key},
+		{"scrypt p=0", &ScryptHasher{}, "$scrypt$ln=15,r=8,p=0$" + salt + "$" + key},
+		{"scrypt huge N", &ScryptHasher{}, "$scrypt$ln=31,r=8,p=1$" + salt + "$" + key},
+		{"scrypt huge memory", &ScryptHasher{}, "$scrypt$ln=20,r=32,p=1$" + salt + "$" + key},
+		{"scrypt huge p", &ScryptHasher{}, "$scrypt$ln=15,r=8,p=1000$" + salt + "$" + key},
+		{"pbkdf2 huge rounds", &Pbkdf2Hasher{}, "$pbkdf2-sha256$2000000000$" + salt + "$" + key},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.hasher.CheckEncoded(tc.encoded); err != ErrInvalidPasswordHash {
+				t.Fatalf("CheckEncoded = %v, want ErrInvalidPasswordHash", err)
+			}
+			if _, err := tc.hasher.Verify(tc.encoded, []byte("password"), nil); err != ErrInvalidPasswordHash {
+				t.Fatalf("Verify = %v, want ErrInvalidPasswordHash", err)
+			}
+		})
+	}
+}
+
+func TestDecodeAcceptsOwnHashes(t *testing.T) {
+	for _, h := range []ImportablePasswordHasher{
+		NewArgon2idHasher(8*1024, 1, 1),
+		NewScryptHasher(10, 8, 1),
+	} {
+		encoded, err := h.Hash([]byte("password"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := h.CheckEncoded(encoded); err != nil {
+			t.Fatalf("%s: CheckEncoded = %v", h.Algorithm(), err)
+		}
+		if ok, err := h.Verify(encoded, []byte("password"), nil); err != nil || !ok {
+			t.Fatalf("%s: Verify = %v, %v", h.Algorithm(), ok, err)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package misc
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+const (
+	PWD_RULE_MIN_LENGTH       = "minLength"
+	PWD_RULE_UPPER            = "upper"
+	PWD_RULE_LOWER            = "lower"
+	PWD_RULE_DIGIT            = "digit"
+	PWD_RULE_SYMBOL           = "symbol"
+	PWD_RULE_MAX_REPEATED     = "maxRepeatedChars"
+	PWD_RULE_BLOCKED_WORD     = "blockedWord"
+	PWD_RULE_NOT_EQ_IDENTITY  = "notEqualToIdentity"
+	DefaultPasswordMinLength  = 8
+	DefaultPasswordMaxRepeats = 0
+)
+
+type PasswordPolicy struct {
+	MinLength        int
+	RequireUpper     bool
+	RequireLower     bool
+	RequireDigit     bool
+	RequireSymbol    bool
+	MaxRepeatedChars int
+	BlockedWords     []string
+}
+
+type PasswordViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func NewPasswordPolicy() *PasswordPolicy {
+	return &PasswordPolicy{
+		MinLength:        DefaultPasswordMinLength,
+		MaxRepeatedChars: DefaultPasswordMaxRepeats,
+	}
+}
+
+// LoadBlockedWords reads one word per line, blank lines and lines starting with '#' are skipped.
+func (p *PasswordPolicy) LoadBlockedWords(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	words := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		word := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		words = append(words, word)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	p.BlockedWords = words
+	return nil
+}
+
+// Validate returns every rule the password fails, an empty result means the password is acceptable.
+func (p *PasswordPolicy) Validate(password, identity string) []PasswordViolation {
+	violations := []PasswordViolation{}
+
+	if len([]rune(password)) < p.MinLength {
+		violations = append(violations, PasswordViolation{
+			Rule:    PWD_RULE_MIN_LENGTH,
+			Message: fmt.Sprintf("Password must be at least %d characters long.", p.MinLength),
+		})
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+
+	if p.RequireUpper && !hasUpper {
+		violations = append(violations, PasswordViolation{Rule: PWD_RULE_UPPER, Message: "Password must contain an uppercase letter."})
+	}
+
+	if p.RequireLower && !hasLower {
+		violations = append(violations, PasswordViolation{Rule: PWD_RULE_LOWER, Message: "Password must contain a lowercase letter."})
+	}
+
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, PasswordViolation{Rule: PWD_RULE_DIGIT, Message: "Password must contain a digit."})
+	}
+
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, PasswordViolation{Rule: PWD_RULE_SYMBOL, Message: "Password must contain a symbol."})
+	}
+
+	if p.MaxRepeatedChars > 0 && maxRepeatedRun(password) > p.MaxRepeatedChars {
+		violations = append(violations, PasswordViolation{
+			Rule:    PWD_RULE_MAX_REPEATED,
+			Message: fmt.Sprintf("Password must not repeat the same character more than %d times in a row.", p.MaxRepeatedChars),
+		})
+	}
+
+	lowerPwd := strings.ToLower(password)
+	for _, word := range p.BlockedWords {
+		if strings.Contains(lowerPwd, word) {
+			violations = append(violations, PasswordViolation{Rule: PWD_RULE_BLOCKED_WORD, Message: "Password contains a commonly used word."})
+			break
+		}
+	}
+
+	if identity != "" {
+		lowerIdentity := strings.ToLower(identity)
+		localPart := lowerIdentity
+		if at := strings.LastIndex(lowerIdentity, "@"); at > 0 {
+			localPart = lowerIdentity[:at]
+		}
+
+		if lowerPwd == lowerIdentity || lowerPwd == localPart {
+			violations = append(violations, PasswordViolation{Rule: PWD_RULE_NOT_EQ_IDENTITY, Message: "Password must not be the same as the account."})
+		}
+	}
+
+	return violations
+}
+
+func maxRepeatedRun(s string) int {
+	var (
+		max, run int
+		prev     rune
+	)
+
+	for i, r := range s {
+		if i > 0 && r == prev {
+			run += 1
+		} else {
+			run = 1
+		}
+		if run > max {
+			max = run
+		}
+		prev = r
+	}
+
+	return max
+}
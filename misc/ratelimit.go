@@ -0,0 +1,265 @@
+package misc
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	RATE_LIMIT_KEY_IP    = "ip"
+	RATE_LIMIT_KEY_EMAIL = "email"
+
+	RATE_LIMIT_BACKEND_MEMORY = "memory"
+	RATE_LIMIT_BACKEND_REDIS  = "redis"
+
+	// The routes sending emails are throttled hardest, they could be used to spam any inbox through our SMTP account.
+	// Every other route taking a secret to guess gets an ip bucket.
+	DefaultRateLimitRules = "POST /auth/v1/signup/confirmation=ip:10/1h,email:3/1h;" +
+		"POST /auth/v1/forgetpassword/confirmation=ip:10/1h,email:3/1h;" +
+		"POST /auth/v1/magiclink/confirmation=ip:10/1h,email:3/1h;" +
+		"POST /auth/v1/login=ip:30/1m,email:10/1m;" +
+		"POST /auth/v1/mfa/verify=ip:30/1m;" +
+		"DELETE /auth/v1/mfa/totp=ip:10/1m;" +
+		"POST /auth/v1/magiclink/login=ip:30/1m;" +
+		"POST /auth/v1/unlock=ip:30/1m;" +
+		"POST /auth/v1/webauthn/login/options=ip:30/1m;" +
+		"POST /auth/v1/webauthn/login=ip:30/1m;" +
+		"POST /oauth/token=ip:60/1m"
+
+	rateLimitSweepInterval = time.Minute
+)
+
+var ErrInvalidRateLimitRule = errors.New("Invalid rate limit rule, it should look like \"POST /auth/v1/login=ip:30/1m,email:10/1m\"")
+
+// ================================================================
+// Rules
+// ================================================================
+// RateLimit is a token bucket holding Capacity tokens, refilled at Capacity per Period.
+type RateLimit struct {
+	Key      string
+	Capacity int
+	Period   time.Duration
+}
+
+type RateLimitRule struct {
+	Method string
+	Path   string
+	Limits []RateLimit
+}
+
+// ParseRateLimitRules reads rules separated by ';', each one is "METHOD PATH=key:capacity/period[,...]".
+// The path is the route pattern as registered, e.g. /auth/v1/webauthn/credentials/:id.
+func ParseRateLimitRules(s string) ([]*RateLimitRule, error) {
+	rules := []*RateLimitRule{}
+
+	for _, ruleStr := range strings.Split(s, ";") {
+		if ruleStr = strings.TrimSpace(ruleStr); ruleStr == "" {
+			continue
+		}
+
+		route, limitsStr, ok := cut(ruleStr, "=")
+		if !ok {
+			return nil, ErrInvalidRateLimitRule
+		}
+
+		routeFields := strings.Fields(route)
+		if len(routeFields) != 2 {
+			return nil, ErrInvalidRateLimitRule
+		}
+
+		rule := &RateLimitRule{Method: strings.ToUpper(routeFields[0]), Path: routeFields[1]}
+		for _, limitStr := range strings.Split(limitsStr, ",") {
+			key, bucket, ok := cut(strings.TrimSpace(limitStr), ":")
+			if !ok || (key != RATE_LIMIT_KEY_IP && key != RATE_LIMIT_KEY_EMAIL) {
+				return nil, ErrInvalidRateLimitRule
+			}
+
+			capacityStr, periodStr, ok := cut(bucket, "/")
+			if !ok {
+				return nil, ErrInvalidRateLimitRule
+			}
+
+			capacity, err := strconv.Atoi(capacityStr)
+			if err != nil || capacity <= 0 {
+				return nil, ErrInvalidRateLimitRule
+			}
+
+			period, err := time.ParseDuration(periodStr)
+			if err != nil || period <= 0 {
+				return nil, ErrInvalidRateLimitRule
+			}
+
+			rule.Limits = append(rule.Limits, RateLimit{Key: key, Capacity: capacity, Period: period})
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func cut(s, sep string) (string, string, bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// ================================================================
+// Stores
+// ================================================================
+// RateLimitStore takes a token from the bucket of every key, limits[i] being the limit of keys[i]. Either every
+// bucket gives one or none does, so a request refused by one limit does not spend the others. It returns how long
+// to wait for the emptiest bucket.
+type RateLimitStore interface {
+	Take(ctx context.Context, keys []string, limits []RateLimit) (bool, time.Duration, error)
+}
+
+// MemoryRateLimitStore keeps buckets in process, so every replica counts on its own.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type memoryBucket struct {
+	tokens  float64
+	updated time.Time
+	period  time.Duration
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets: map[string]*memoryBucket{},
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryRateLimitStore) Take(ctx context.Context, keys []string, limits []RateLimit) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nowTime := s.now()
+	s.sweep(nowTime)
+
+	allowed, wait := true, time.Duration(0)
+	buckets := make([]*memoryBucket, len(keys))
+	for i, key := range keys {
+		capacity := float64(limits[i].Capacity)
+		rate := capacity / float64(limits[i].Period)
+
+		b, ok := s.buckets[key]
+		if !ok {
+			b = &memoryBucket{tokens: capacity, updated: nowTime}
+			s.buckets[key] = b
+		}
+		b.period = limits[i].Period
+		b.tokens = math.Min(capacity, b.tokens+float64(nowTime.Sub(b.updated))*rate)
+		b.updated = nowTime
+		buckets[i] = b
+
+		if b.tokens < 1 {
+			allowed = false
+			if w := time.Duration(math.Ceil((1 - b.tokens) / rate)); w > wait {
+				wait = w
+			}
+		}
+	}
+
+	if allowed {
+		for _, b := range buckets {
+			b.tokens -= 1
+		}
+	}
+
+	return allowed, wait, nil
+}
+
+// sweep drops buckets that have been full again for a while, they are the same as a missing bucket.
+func (s *MemoryRateLimitStore) sweep(nowTime time.Time) {
+	if nowTime.Sub(s.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	s.lastSweep = nowTime
+
+	for key, b := range s.buckets {
+		if nowTime.Sub(b.updated) > b.period {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// RedisRateLimitStore shares buckets between replicas. The refill and the take of every bucket run in one script,
+// so they are atomic on the Redis side.
+type RedisRateLimitStore struct {
+	client redis.Scripter
+	prefix string
+}
+
+func NewRedisRateLimitStore(client redis.Scripter, prefix string) *RedisRateLimitStore {
+	return &RedisRateLimitStore{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+var redisTakeScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local allowed, wait = 1, 0
+local tokens, periods = {}, {}
+
+for i, key in ipairs(KEYS) do
+	local capacity = tonumber(ARGV[2 * i])
+	local period = tonumber(ARGV[2 * i + 1])
+	local rate = capacity / period
+
+	local state = redis.call('HMGET', key, 'tokens', 'ts')
+	local t = tonumber(state[1])
+	local ts = tonumber(state[2])
+	if t == nil or ts == nil then
+		t = capacity
+		ts = now
+	end
+
+	t = math.min(capacity, t + math.max(0, now - ts) * rate)
+	if t < 1 then
+		allowed = 0
+		wait = math.max(wait, math.ceil((1 - t) / rate))
+	end
+	tokens[i], periods[i] = t, period
+end
+
+for i, key in ipairs(KEYS) do
+	if allowed == 1 then
+		tokens[i] = tokens[i] - 1
+	end
+	redis.call('HMSET', key, 'tokens', tostring(tokens[i]), 'ts', now)
+	redis.call('PEXPIRE', key, periods[i])
+end
+
+return {allowed, wait}
+`)
+
+func (s *RedisRateLimitStore) Take(ctx context.Context, keys []string, limits []RateLimit) (bool, time.Duration, error) {
+	redisKeys := make([]string, len(keys))
+	args := []interface{}{time.Now().UnixMilli()}
+	for i, key := range keys {
+		redisKeys[i] = s.prefix + key
+		args = append(args, limits[i].Capacity, limits[i].Period.Milliseconds())
+	}
+
+	res, err := redisTakeScript.Run(ctx, s.client, redisKeys, args...).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+
+	return res[0] == 1, time.Duration(res[1]) * time.Millisecond, nil
+}
@@ -0,0 +1,164 @@
+package misc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// takeOne takes from a single bucket.
+func takeOne(store RateLimitStore, key string, limit RateLimit) (bool, time.Duration, error) {
+	return store.Take(context.Background(), []string{key}, []RateLimit{limit})
+}
+
+// checkTakeAll checks that a request refused by one bucket does not spend the others.
+func checkTakeAll(t *testing.T, store RateLimitStore) {
+	t.Helper()
+
+	ip := RateLimit{Key: RATE_LIMIT_KEY_IP, Capacity: 2, Period: time.Hour}
+	email := RateLimit{Key: RATE_LIMIT_KEY_EMAIL, Capacity: 1, Period: time.Hour}
+	keys, limits := []string{"all|ip", "all|email"}, []RateLimit{ip, email}
+
+	if allowed, _, err := store.Take(context.Background(), keys, limits); err != nil || !allowed {
+		t.Fatalf("1st take: allowed = %v, err = %v", allowed, err)
+	}
+	for i := 0; i < 3; i++ {
+		if allowed, wait, err := store.Take(context.Background(), keys, limits); err != nil || allowed || wait <= 59*time.Minute {
+			t.Fatalf("take %d: allowed = %v, wait = %v, err = %v", i+2, allowed, wait, err)
+		}
+	}
+
+	// The ip bucket still holds the token the refused requests did not spend.
+	if allowed, _, _ := takeOne(store, "all|ip", ip); !allowed {
+		t.Fatal("a refused request spent the ip bucket")
+	}
+	if allowed, _, _ := takeOne(store, "all|ip", ip); allowed {
+		t.Fatal("the ip bucket gave more than its capacity")
+	}
+}
+
+func TestParseRateLimitRules(t *testing.T) {
+	rules, err := ParseRateLimitRules(DefaultRateLimitRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 11 {
+		t.Fatalf("got %d rules, want 11", len(rules))
+	}
+
+	login := rules[3]
+	if login.Method != "POST" || login.Path != "/auth/v1/login" || len(login.Limits) != 2 {
+		t.Fatalf("unexpected rule %+v", login)
+	}
+	if login.Limits[1] != (RateLimit{Key: RATE_LIMIT_KEY_EMAIL, Capacity: 10, Period: time.Minute}) {
+		t.Fatalf("unexpected limit %+v", login.Limits[1])
+	}
+
+	for _, s := range []string{
+		"POST /auth/v1/login",
+		"/auth/v1/login=ip:1/1m",
+		"POST /auth/v1/login=user:1/1m",
+		"POST /auth/v1/login=ip:0/1m",
+		"POST /auth/v1/login=ip:1/0s",
+		"POST /auth/v1/login=ip:1",
+	} {
+		if _, err := ParseRateLimitRules(s); err != ErrInvalidRateLimitRule {
+			t.Fatalf("%q: err = %v, want ErrInvalidRateLimitRule", s, err)
+		}
+	}
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	nowTime := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryRateLimitStore()
+	store.now = func() time.Time { return nowTime }
+
+	limit := RateLimit{Key: RATE_LIMIT_KEY_IP, Capacity: 3, Period: time.Minute}
+
+	for i := 0; i < 3; i++ {
+		if allowed, _, err := takeOne(store, "a", limit); err != nil || !allowed {
+			t.Fatalf("take %d: allowed = %v, err = %v", i, allowed, err)
+		}
+	}
+
+	allowed, wait, err := takeOne(store, "a", limit)
+	if err != nil || allowed {
+		t.Fatalf("4th take: allowed = %v, err = %v", allowed, err)
+	}
+	if wait != 20*time.Second {
+		t.Fatalf("wait = %v, want 20s", wait)
+	}
+
+	// Buckets are per key.
+	if allowed, _, _ := takeOne(store, "b", limit); !allowed {
+		t.Fatal("another key was throttled")
+	}
+
+	// One token comes back every Period / Capacity.
+	nowTime = nowTime.Add(20 * time.Second)
+	if allowed, _, _ := takeOne(store, "a", limit); !allowed {
+		t.Fatal("the refilled token was refused")
+	}
+	if allowed, _, _ := takeOne(store, "a", limit); allowed {
+		t.Fatal("more than the refill was allowed")
+	}
+
+	checkTakeAll(t, store)
+
+	// Full buckets are swept.
+	nowTime = nowTime.Add(2 * time.Minute)
+	takeOne(store, "c", limit)
+	if _, ok := store.buckets["a"]; ok {
+		t.Fatal("the idle bucket was not swept")
+	}
+}
+
+func TestRedisRateLimitStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	store := NewRedisRateLimitStore(client, "ratelimit:")
+	limit := RateLimit{Key: RATE_LIMIT_KEY_EMAIL, Capacity: 2, Period: time.Hour}
+
+	for i := 0; i < 2; i++ {
+		if allowed, _, err := takeOne(store, "a", limit); err != nil || !allowed {
+			t.Fatalf("take %d: allowed = %v, err = %v", i, allowed, err)
+		}
+	}
+
+	allowed, wait, err := takeOne(store, "a", limit)
+	if err != nil || allowed {
+		t.Fatalf("3rd take: allowed = %v, err = %v", allowed, err)
+	}
+	if wait <= 29*time.Minute || wait > 30*time.Minute {
+		t.Fatalf("wait = %v, want about 30m", wait)
+	}
+
+	if allowed, _, _ := takeOne(store, "b", limit); !allowed {
+		t.Fatal("another key was throttled")
+	}
+
+	checkTakeAll(t, store)
+
+	// The state lives under the prefix and expires with the period.
+	if !mr.Exists("ratelimit:a") {
+		t.Fatal("the bucket is not stored under the prefix")
+	}
+	if ttl := mr.TTL("ratelimit:a"); ttl != time.Hour {
+		t.Fatalf("ttl = %v, want 1h", ttl)
+	}
+
+	mr.FastForward(time.Hour)
+	if allowed, _, _ := takeOne(store, "a", limit); !allowed {
+		t.Fatal("the expired bucket was not full again")
+	}
+
+	mr.Close()
+	if _, _, err := takeOne(store, "a", limit); err == nil {
+		t.Fatal("an unreachable Redis did not fail")
+	}
+}
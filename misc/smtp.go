@@ -0,0 +1,271 @@
+package misc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/smtp"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	SMTP_TLS_MODE_NONE     = "none"
+	SMTP_TLS_MODE_STARTTLS = "starttls"
+	SMTP_TLS_MODE_TLS      = "tls"
+
+	SMTP_DIAL_TIMEOUT    = 30 * time.Second
+	SMTP_COMMAND_TIMEOUT = 60 * time.Second
+	SMTP_IDLE_TIMEOUT    = 30 * time.Second
+	SMTP_MAX_IDLE_CONN   = 4
+)
+
+var (
+	ErrSMTPNoStartTLS = errors.New("The SMTP server does not support STARTTLS.")
+	ErrSMTPNoAuth     = errors.New("The SMTP server does not support AUTH.")
+)
+
+func IsValidSMTPTLSMode(mode string) bool {
+	switch mode {
+	case SMTP_TLS_MODE_NONE, SMTP_TLS_MODE_STARTTLS, SMTP_TLS_MODE_TLS:
+		return true
+	default:
+		return false
+	}
+}
+
+// NewSMTPTLSConfig verifies the server against the system roots, or only against the PEM certificates of caFile
+// when it is given. skipVerify is meant for development servers with self-signed certificates.
+func NewSMTPTLSConfig(host, caFile string, skipVerify bool) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         host,
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: skipVerify,
+	}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("No certificate found in " + caFile)
+		}
+	}
+
+	return cfg, nil
+}
+
+type smtpConn struct {
+	conn     net.Conn
+	client   *smtp.Client
+	timeout  time.Duration
+	lastUsed time.Time
+}
+
+// deadline gives the next command its own timeout, a server that stops answering fails the delivery instead of
+// holding the worker.
+func (c *smtpConn) deadline() {
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+}
+
+// Email delivers through SMTP. Connections are kept open and reused by the following messages, so a batch of the
+// outbox doesn't pay the handshake for every message. Messages are DKIM signed when DKIM is set.
+type Email struct {
+	SmtpHost  string
+	SmtpPort  string
+	Username  string
+	Password  string
+	TLSMode   string
+	TLSConfig *tls.Config
+	DKIM      *DKIMSigner
+	// CommandTimeout bounds every command and its answer, the DATA transfer included.
+	CommandTimeout time.Duration
+
+	mu   sync.Mutex
+	idle []*smtpConn
+}
+
+// NewEmail authenticates with PLAIN when username is given. The STARTTLS mode is the default, with TLS verified
+// against the system roots. The none mode can't authenticate, PLAIN is refused over an unencrypted connection.
+func NewEmail(smtpHost string, smtpPort string, username string, password string) *Email {
+	return &Email{
+		SmtpHost:       smtpHost,
+		SmtpPort:       smtpPort,
+		Username:       username,
+		Password:       password,
+		TLSMode:        SMTP_TLS_MODE_STARTTLS,
+		TLSConfig:      &tls.Config{ServerName: smtpHost, MinVersion: tls.VersionTLS12},
+		CommandTimeout: SMTP_COMMAND_TIMEOUT,
+	}
+}
+
+func (e *Email) Send(m *EmailMessage) error {
+	msg, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+
+	if e.DKIM != nil {
+		if msg, err = e.DKIM.Sign(msg); err != nil {
+			return err
+		}
+	}
+
+	conn, err := e.take()
+	if err != nil {
+		return err
+	}
+
+	if err := conn.deliver(m.From, m.To, msg); err != nil {
+		conn.client.Close()
+		return err
+	}
+
+	e.put(conn)
+	return nil
+}
+
+// Close quits every idle connection.
+func (e *Email) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, conn := range e.idle {
+		conn.deadline()
+		conn.client.Quit()
+	}
+	e.idle = nil
+}
+
+// take reuses an idle connection the server still answers, or dials a new one.
+func (e *Email) take() (*smtpConn, error) {
+	for {
+		e.mu.Lock()
+		if len(e.idle) == 0 {
+			e.mu.Unlock()
+			break
+		}
+		conn := e.idle[len(e.idle)-1]
+		e.idle = e.idle[:len(e.idle)-1]
+		e.mu.Unlock()
+
+		if time.Since(conn.lastUsed) < SMTP_IDLE_TIMEOUT {
+			if conn.deadline(); conn.client.Reset() == nil {
+				return conn, nil
+			}
+		}
+		conn.client.Close()
+	}
+
+	return e.dial()
+}
+
+func (e *Email) put(conn *smtpConn) {
+	conn.lastUsed = time.Now()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.idle) < SMTP_MAX_IDLE_CONN {
+		e.idle = append(e.idle, conn)
+	} else {
+		conn.deadline()
+		conn.client.Quit()
+	}
+}
+
+func (e *Email) dial() (*smtpConn, error) {
+	addr := net.JoinHostPort(e.SmtpHost, e.SmtpPort)
+	dialer := &net.Dialer{Timeout: SMTP_DIAL_TIMEOUT}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if e.TLSMode == SMTP_TLS_MODE_TLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, e.TLSConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &smtpConn{conn: conn, timeout: e.CommandTimeout}
+	if c.timeout <= 0 {
+		c.timeout = SMTP_COMMAND_TIMEOUT
+	}
+
+	c.deadline()
+	if c.client, err = smtp.NewClient(conn, e.SmtpHost); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := e.handshake(c); err != nil {
+		c.client.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// handshake never falls back to plain text in the STARTTLS mode, a server that doesn't offer it is an error.
+func (e *Email) handshake(c *smtpConn) error {
+	if e.TLSMode == SMTP_TLS_MODE_STARTTLS {
+		c.deadline()
+		if ok, _ := c.client.Extension("STARTTLS"); !ok {
+			return ErrSMTPNoStartTLS
+		}
+		c.deadline()
+		if err := c.client.StartTLS(e.TLSConfig); err != nil {
+			return err
+		}
+	}
+
+	if e.Username != "" {
+		c.deadline()
+		if ok, _ := c.client.Extension("AUTH"); !ok {
+			return ErrSMTPNoAuth
+		}
+		c.deadline()
+		if err := c.client.Auth(smtp.PlainAuth("", e.Username, e.Password, e.SmtpHost)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *smtpConn) deliver(from string, to []string, msg []byte) error {
+	c.deadline()
+	if err := c.client.Mail(from); err != nil {
+		return err
+	}
+
+	for _, addr := range to {
+		c.deadline()
+		if err := c.client.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+
+	c.deadline()
+	w, err := c.client.Data()
+	if err != nil {
+		return err
+	}
+
+	c.deadline()
+	if _, err := w.Write(msg); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
@@ -0,0 +1,430 @@
+package misc
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+type testSMTPMessage struct {
+	from string
+	to   []string
+	data []byte
+	tls  bool
+}
+
+// testSMTPServer speaks just enough SMTP for the client of Email: EHLO, STARTTLS, AUTH PLAIN, MAIL, RCPT, DATA,
+// RSET and QUIT.
+type testSMTPServer struct {
+	ln        net.Listener
+	tlsConfig *tls.Config
+	startTLS  bool
+	username  string
+	password  string
+	stall     bool
+
+	mu       sync.Mutex
+	conns    int
+	resets   int
+	messages []testSMTPMessage
+}
+
+// start listens on 127.0.0.1, implicit wraps every connection in TLS like a port 465 server.
+func (s *testSMTPServer) start(t *testing.T, cert tls.Certificate, implicit bool) *testSMTPServer {
+	s.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	var err error
+	if implicit {
+		s.ln, err = tls.Listen("tcp", "127.0.0.1:0", s.tlsConfig)
+	} else {
+		s.ln, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.ln.Close() })
+
+	go func() {
+		for {
+			conn, err := s.ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns++
+			s.mu.Unlock()
+			go s.serve(conn, implicit)
+		}
+	}()
+
+	return s
+}
+
+func (s *testSMTPServer) port() string {
+	return s.ln.Addr().(*net.TCPAddr).String()[len("127.0.0.1:"):]
+}
+
+func (s *testSMTPServer) serve(conn net.Conn, isTLS bool) {
+	defer conn.Close()
+
+	if s.stall {
+		io.Copy(io.Discard, conn)
+		return
+	}
+
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ESMTP")
+
+	var msg testSMTPMessage
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			verb, arg = line[:i], line[i+1:]
+		}
+
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			exts := []string{"localhost", "8BITMIME"}
+			if s.startTLS && !isTLS {
+				exts = append(exts, "STARTTLS")
+			}
+			if s.username != "" {
+				exts = append(exts, "AUTH PLAIN")
+			}
+			for i, ext := range exts {
+				sep := "-"
+				if i == len(exts)-1 {
+					sep = " "
+				}
+				tp.PrintfLine("250%s%s", sep, ext)
+			}
+		case "STARTTLS":
+			tp.PrintfLine("220 Ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, isTLS = tlsConn, true
+			tp = textproto.NewConn(conn)
+		case "AUTH":
+			creds, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(arg, "PLAIN "))
+			if !isTLS {
+				tp.PrintfLine("538 Encryption required")
+			} else if err != nil || string(creds) != "\x00"+s.username+"\x00"+s.password {
+				tp.PrintfLine("535 Authentication failed")
+			} else {
+				tp.PrintfLine("235 Authenticated")
+			}
+		case "MAIL":
+			msg = testSMTPMessage{from: between(arg, "<", ">"), tls: isTLS}
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			msg.to = append(msg.to, between(arg, "<", ">"))
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 Go ahead")
+			if msg.data, err = readSMTPData(tp.R); err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			tp.PrintfLine("250 Queued")
+		case "RSET":
+			s.mu.Lock()
+			s.resets++
+			s.mu.Unlock()
+			tp.PrintfLine("250 OK")
+		case "NOOP":
+			tp.PrintfLine("250 OK")
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("502 Not implemented")
+		}
+	}
+}
+
+// readSMTPData keeps the CRLF line endings, the DKIM signature covers them.
+func readSMTPData(r *bufio.Reader) ([]byte, error) {
+	var data []byte
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		if line == ".\r\n" {
+			return data, nil
+		}
+		data = append(data, strings.TrimPrefix(line, ".")...)
+	}
+}
+
+func between(s, open, close string) string {
+	i, j := strings.Index(s, open), strings.Index(s, close)
+	if i < 0 || j < i {
+		return ""
+	}
+	return s[i+1 : j]
+}
+
+func (s *testSMTPServer) stats() (int, int, []testSMTPMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns, s.resets, append([]testSMTPMessage{}, s.messages...)
+}
+
+// testSMTPCert is a self-signed certificate of 127.0.0.1, and the pool trusting it.
+func testSMTPCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func testSMTPMessageOf(to string) *EmailMessage {
+	return &EmailMessage{
+		FromName: "Accounts",
+		From:     "no-reply@example.com",
+		To:       []string{to},
+		Subject:  "Login Link",
+		HTML:     "<p>Hello</p>",
+		Text:     "Hello",
+	}
+}
+
+func TestEmailTLSModes(t *testing.T) {
+	cert, pool := testSMTPCert(t)
+
+	cases := []struct {
+		name     string
+		mode     string
+		startTLS bool
+		username string
+		wantTLS  bool
+		want     error
+	}{
+		{"none", SMTP_TLS_MODE_NONE, false, "", false, nil},
+		{"starttls", SMTP_TLS_MODE_STARTTLS, true, "user", true, nil},
+		{"starttls without auth", SMTP_TLS_MODE_STARTTLS, true, "", true, nil},
+		{"starttls not offered", SMTP_TLS_MODE_STARTTLS, false, "", false, ErrSMTPNoStartTLS},
+		{"tls", SMTP_TLS_MODE_TLS, false, "user", true, nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := (&testSMTPServer{startTLS: tc.startTLS, username: tc.username, password: "secret"}).start(t, cert, tc.mode == SMTP_TLS_MODE_TLS)
+
+			email := NewEmail("127.0.0.1", server.port(), tc.username, "secret")
+			email.TLSMode = tc.mode
+			email.TLSConfig.RootCAs = pool
+			defer email.Close()
+
+			if err := email.Send(testSMTPMessageOf("user@example.com")); err != tc.want {
+				t.Fatalf("err = %v, want %v", err, tc.want)
+			} else if err != nil {
+				return
+			}
+
+			_, _, messages := server.stats()
+			if len(messages) != 1 || messages[0].from != "no-reply@example.com" || messages[0].to[0] != "user@example.com" || messages[0].tls != tc.wantTLS {
+				t.Fatalf("unexpected messages %+v", messages)
+			}
+			if !bytes.Contains(messages[0].data, []byte("Subject: Login Link\r\n")) {
+				t.Fatalf("unexpected data %q", messages[0].data)
+			}
+		})
+	}
+}
+
+func TestEmailUntrustedCertificate(t *testing.T) {
+	cert, _ := testSMTPCert(t)
+	server := (&testSMTPServer{}).start(t, cert, true)
+
+	email := NewEmail("127.0.0.1", server.port(), "", "")
+	email.TLSMode = SMTP_TLS_MODE_TLS
+
+	var unknownAuthority x509.UnknownAuthorityError
+	if err := email.Send(testSMTPMessageOf("user@example.com")); !errors.As(err, &unknownAuthority) {
+		t.Fatalf("err = %v, want an unknown authority", err)
+	}
+}
+
+func TestEmailConnectionReuse(t *testing.T) {
+	cert, pool := testSMTPCert(t)
+	server := (&testSMTPServer{startTLS: true}).start(t, cert, false)
+
+	email := NewEmail("127.0.0.1", server.port(), "", "")
+	email.TLSConfig.RootCAs = pool
+
+	// A batch goes through one connection, every message after the first starts with RSET.
+	for _, to := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		if err := email.Send(testSMTPMessageOf(to)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conns, resets, messages := server.stats()
+	if conns != 1 || resets != 2 || len(messages) != 3 {
+		t.Fatalf("conns = %d, resets = %d, messages = %d", conns, resets, len(messages))
+	}
+
+	// A connection idle for too long is not reused.
+	email.idle[0].lastUsed = time.Now().Add(-SMTP_IDLE_TIMEOUT)
+	if err := email.Send(testSMTPMessageOf("d@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if conns, _, _ := server.stats(); conns != 2 {
+		t.Fatalf("conns = %d, want 2", conns)
+	}
+
+	email.Close()
+	if len(email.idle) != 0 {
+		t.Fatal("Close left idle connections")
+	}
+}
+
+func TestEmailCommandTimeout(t *testing.T) {
+	cert, _ := testSMTPCert(t)
+	server := (&testSMTPServer{stall: true}).start(t, cert, false)
+
+	email := NewEmail("127.0.0.1", server.port(), "", "")
+	email.TLSMode = SMTP_TLS_MODE_NONE
+	email.CommandTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	var netErr net.Error
+	if err := email.Send(testSMTPMessageOf("user@example.com")); !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("err = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("the silent server held the delivery for %v", elapsed)
+	}
+}
+
+func TestEmailDKIM(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPublic, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		key    crypto.Signer
+		record string
+	}{
+		{"rsa", rsaKey, "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(rsaPublic)},
+		{"ed25519", edKey, "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(edPublic)},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := (&testSMTPServer{}).start(t, tls.Certificate{}, false)
+
+			email := NewEmail("127.0.0.1", server.port(), "", "")
+			email.TLSMode = SMTP_TLS_MODE_NONE
+			email.DKIM = &DKIMSigner{Domain: "example.com", Selector: "mail", Key: tc.key}
+			defer email.Close()
+
+			m := testSMTPMessageOf("user@example.com")
+			m.FromName, m.Subject = "帳號中心", "登入連結 — Login Link"
+			m.ListUnsubscribe = "mailto:unsubscribe@example.com"
+			if err := email.Send(m); err != nil {
+				t.Fatal(err)
+			}
+
+			_, _, messages := server.stats()
+			if len(messages) != 1 {
+				t.Fatalf("got %d messages", len(messages))
+			}
+
+			lookup := func(domain string) ([]string, error) {
+				if domain != "mail._domainkey.example.com" {
+					return nil, errors.New("no record for " + domain)
+				}
+				return []string{tc.record}, nil
+			}
+
+			verifications, err := dkim.VerifyWithOptions(bytes.NewReader(messages[0].data), &dkim.VerifyOptions{LookupTXT: lookup})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(verifications) != 1 || verifications[0].Err != nil || verifications[0].Domain != "example.com" {
+				t.Fatalf("unexpected verifications %+v", verifications)
+			}
+			for _, h := range []string{"from", "subject", "list-unsubscribe"} {
+				if !containsFold(verifications[0].HeaderKeys, h) {
+					t.Fatalf("%s is not signed: %v", h, verifications[0].HeaderKeys)
+				}
+			}
+
+			// A body changed on the way fails the signature.
+			tampered := bytes.Replace(messages[0].data, []byte("Hello"), []byte("Hallo"), 1)
+			if verifications, err := dkim.VerifyWithOptions(bytes.NewReader(tampered), &dkim.VerifyOptions{LookupTXT: lookup}); err != nil || verifications[0].Err == nil {
+				t.Fatal("the tampered message was verified")
+			}
+		})
+	}
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
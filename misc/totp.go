@@ -0,0 +1,119 @@
+package misc
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	TOTP_SECRET_BYTES = 20
+	TOTP_DIGITS       = 6
+	TOTP_PERIOD_SECS  = 30
+	TOTP_SKEW_STEPS   = 1
+
+	RECOVERY_CODE_COUNT = 10
+	RECOVERY_CODE_BYTES = 5
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenTOTPSecret returns a RFC 4648 base32 secret, the form authenticator apps expect.
+func GenTOTPSecret() (string, error) {
+	b, err := randomBytes(TOTP_SECRET_BYTES)
+	if err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(b), nil
+}
+
+func TOTPURI(issuer, account, secret string) string {
+	vals := url.Values{}
+	vals.Set("secret", secret)
+	vals.Set("issuer", issuer)
+	vals.Set("algorithm", "SHA1")
+	vals.Set("digits", fmt.Sprint(TOTP_DIGITS))
+	vals.Set("period", fmt.Sprint(TOTP_PERIOD_SECS))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + vals.Encode()
+}
+
+// TOTPCode computes the RFC 6238 code of the time step counter.
+func TOTPCode(secret string, counter int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", err
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < TOTP_DIGITS; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", TOTP_DIGITS, value%mod), nil
+}
+
+func TOTPCounter(t time.Time) int64 {
+	return t.Unix() / TOTP_PERIOD_SECS
+}
+
+// ValidateTOTP accepts a code from the adjacent time steps as well, it returns the matched counter so callers
+// can refuse a code that was already used.
+func ValidateTOTP(secret, code string, t time.Time) (bool, int64, error) {
+	code = strings.TrimSpace(code)
+	if len(code) != TOTP_DIGITS {
+		return false, 0, nil
+	}
+
+	current := TOTPCounter(t)
+	for step := int64(-TOTP_SKEW_STEPS); step <= TOTP_SKEW_STEPS; step++ {
+		expected, err := TOTPCode(secret, current+step)
+		if err != nil {
+			return false, 0, err
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return true, current + step, nil
+		}
+	}
+
+	return false, 0, nil
+}
+
+// GenRecoveryCodes returns codes formatted as xxxx-xxxx for readability.
+func GenRecoveryCodes() ([]string, error) {
+	codes := make([]string, RECOVERY_CODE_COUNT)
+	for i := range codes {
+		b, err := randomBytes(RECOVERY_CODE_BYTES)
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(b))
+		codes[i] = code[:4] + "-" + code[4:]
+	}
+
+	return codes, nil
+}
+
+// HashRecoveryCode normalizes the user input before hashing, so case and dashes don't matter.
+func HashRecoveryCode(code string) []byte {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	return HashToken(normalized)
+}
@@ -0,0 +1,106 @@
+package misc
+
+import (
+	"errors"
+	"net/url"
+	"path"
+	"strings"
+)
+
+var ErrInvalidAllowedURL = errors.New("Invalid allowed URL, it should look like https://app.example.com/path or https://*.example.com")
+
+// URLAllowlist accepts URLs below one of its entries. An entry is an origin with an optional path prefix, a host
+// starting with "*." matches every subdomain but not the domain itself. An empty allowlist accepts no URL.
+type URLAllowlist struct {
+	entries []*allowedURL
+}
+
+type allowedURL struct {
+	scheme     string
+	host       string
+	port       string
+	wildcard   bool
+	pathPrefix string
+}
+
+func NewURLAllowlist(rawURLs []string) (*URLAllowlist, error) {
+	l := &URLAllowlist{}
+
+	for _, raw := range rawURLs {
+		if raw = strings.TrimSpace(raw); raw == "" {
+			continue
+		}
+
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+			return nil, ErrInvalidAllowedURL
+		}
+
+		entry := &allowedURL{
+			scheme:     u.Scheme,
+			host:       strings.ToLower(u.Hostname()),
+			port:       portOrDefault(u),
+			pathPrefix: strings.TrimSuffix(u.Path, "/"),
+		}
+		if strings.HasPrefix(entry.host, "*.") {
+			entry.wildcard, entry.host = true, entry.host[1:]
+		}
+		if strings.Contains(entry.host, "*") {
+			return nil, ErrInvalidAllowedURL
+		}
+
+		l.entries = append(l.entries, entry)
+	}
+
+	return l, nil
+}
+
+func (l *URLAllowlist) IsEmpty() bool {
+	return len(l.entries) == 0
+}
+
+// Allows reports whether rawURL may be emailed or redirected to. URLs with credentials or a dot segment in the
+// path are refused, whatever the allowlist says.
+func (l *URLAllowlist) Allows(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.User != nil {
+		return false
+	}
+
+	for _, seg := range strings.Split(u.Path, "/") {
+		if seg == "." || seg == ".." {
+			return false
+		}
+	}
+
+	host, port, reqPath := strings.ToLower(u.Hostname()), portOrDefault(u), path.Clean("/"+u.Path)
+	for _, e := range l.entries {
+		if e.scheme != u.Scheme || e.port != port {
+			continue
+		}
+
+		if e.wildcard {
+			if !strings.HasSuffix(host, e.host) || len(host) == len(e.host) {
+				continue
+			}
+		} else if host != e.host {
+			continue
+		}
+
+		if e.pathPrefix == "" || reqPath == e.pathPrefix || strings.HasPrefix(reqPath, e.pathPrefix+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func portOrDefault(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	if u.Scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
@@ -0,0 +1,135 @@
+package misc
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	USER_IMPORT_FORMAT_JSONL = "jsonl"
+	USER_IMPORT_FORMAT_CSV   = "csv"
+
+	USER_IMPORT_RESULT_CREATED = "created"
+	USER_IMPORT_RESULT_FAILED  = "failed"
+)
+
+var ErrUnknownImportFormat = errors.New("Unknown import format, it should be jsonl or csv")
+
+// UserImportRecord is a single user with a password hash produced by another system.
+// Salt is only used by firebase-scrypt, where the export keeps salt and hash apart.
+type UserImportRecord struct {
+	Line         int    `json:"-"`
+	Identity     string `json:"identity"`
+	Status       string `json:"status"`
+	Algorithm    string `json:"algorithm"`
+	PasswordHash string `json:"passwordHash"`
+	Salt         string `json:"salt"`
+}
+
+// EncodedHash returns the value stored in the users table for the record.
+func (r *UserImportRecord) EncodedHash() string {
+	if r.Algorithm == PWD_ALGO_FIREBASE_SCRYPT {
+		return EncodeFirebaseScrypt(r.Salt, r.PasswordHash)
+	}
+
+	return r.PasswordHash
+}
+
+type UserImportResult struct {
+	Line     int    `json:"line"`
+	Identity string `json:"identity"`
+	Result   string `json:"result"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ParseUserImport reads JSON lines or a CSV file with the header identity,status,algorithm,passwordHash[,salt].
+func ParseUserImport(r io.Reader, format string) ([]*UserImportRecord, error) {
+	switch format {
+	case USER_IMPORT_FORMAT_JSONL:
+		return parseUserImportJSONL(r)
+	case USER_IMPORT_FORMAT_CSV:
+		return parseUserImportCSV(r)
+	default:
+		return nil, ErrUnknownImportFormat
+	}
+}
+
+func parseUserImportJSONL(r io.Reader) ([]*UserImportRecord, error) {
+	records := []*UserImportRecord{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line += 1 {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		record := &UserImportRecord{}
+		if err := json.Unmarshal([]byte(text), record); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		record.Line = line
+		records = append(records, record)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func parseUserImportCSV(r io.Reader) ([]*UserImportRecord, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"identity", "algorithm", "passwordHash"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("csv header is missing column %s", name)
+		}
+	}
+
+	column := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	records := []*UserImportRecord{}
+	for line := 2; ; line += 1 {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		records = append(records, &UserImportRecord{
+			Line:         line,
+			Identity:     column(row, "identity"),
+			Status:       column(row, "status"),
+			Algorithm:    column(row, "algorithm"),
+			PasswordHash: column(row, "passwordHash"),
+			Salt:         column(row, "salt"),
+		})
+	}
+
+	return records, nil
+}
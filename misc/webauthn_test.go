@@ -0,0 +1,317 @@
+package misc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const (
+	testRPID   = "accounts.example.com"
+	testOrigin = "https://accounts.example.com"
+)
+
+var testWebAuthnConfig = &WebAuthnConfig{
+	RPID:        testRPID,
+	RPName:      "Example",
+	Origins:     []string{testOrigin},
+	Attestation: WEBAUTHN_ATTESTATION_DIRECT,
+}
+
+// softAuthenticator is an ES256 authenticator held in memory.
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	aaguid       []byte
+	signCount    uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &softAuthenticator{
+		key:          key,
+		credentialID: []byte("soft-credential-id"),
+		aaguid:       []byte("0123456789abcdef"),
+	}
+}
+
+func (a *softAuthenticator) coseKey() []byte {
+	return cborItem(map[interface{}]interface{}{
+		int64(1):  int64(2),
+		int64(3):  int64(COSE_ALG_ES256),
+		int64(-1): int64(1),
+		int64(-2): padTo32(a.key.X.Bytes()),
+		int64(-3): padTo32(a.key.Y.Bytes()),
+	})
+}
+
+func (a *softAuthenticator) authData(rpID string, flags byte, withCredential bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	b := append([]byte{}, rpIDHash[:]...)
+	b = append(b, flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(b[33:37], a.signCount)
+
+	if withCredential {
+		b = append(b, a.aaguid...)
+		b = append(b, byte(len(a.credentialID)>>8), byte(len(a.credentialID)))
+		b = append(b, a.credentialID...)
+		b = append(b, a.coseKey()...)
+	}
+
+	return b
+}
+
+func (a *softAuthenticator) sign(t *testing.T, key *ecdsa.PrivateKey, authData, clientDataJSON []byte) []byte {
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sig
+}
+
+func clientData(t *testing.T, typ string, challenge []byte, origin string) []byte {
+	b, err := json.Marshal(collectedClientData{Type: typ, Challenge: EncodeBase64URL(challenge), Origin: origin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func attestationObject(fmtName string, attStmt map[interface{}]interface{}, authData []byte) []byte {
+	return cborItem(map[interface{}]interface{}{
+		"fmt":      fmtName,
+		"attStmt":  attStmt,
+		"authData": authData,
+	})
+}
+
+// attestationCert issues a packed attestation certificate for the AAGUID, signed by itself.
+func attestationCert(t *testing.T, key *ecdsa.PrivateKey, aaguid []byte) []byte {
+	aaguidExt, err := asn1.Marshal(aaguid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			Country:            []string{"TW"},
+			Organization:       []string{"Example Authenticators"},
+			OrganizationalUnit: []string{"Authenticator Attestation"},
+			CommonName:         "Example Attestation",
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		ExtraExtensions:       []pkix.Extension{{Id: oidAAGUIDExtension, Value: aaguidExt}},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return der
+}
+
+func padTo32(b []byte) []byte {
+	return append(make([]byte, 32-len(b)), b...)
+}
+
+func TestVerifyRegistration(t *testing.T) {
+	challenge := []byte("registration-challenge")
+
+	attKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		fmtName string
+		attStmt func(a *softAuthenticator, authData, cd []byte) map[interface{}]interface{}
+	}{
+		{"none", ATTESTATION_FMT_NONE, func(_ *softAuthenticator, _, _ []byte) map[interface{}]interface{} {
+			return map[interface{}]interface{}{}
+		}},
+		{"packed self", ATTESTATION_FMT_PACKED, func(a *softAuthenticator, authData, cd []byte) map[interface{}]interface{} {
+			return map[interface{}]interface{}{
+				"alg": int64(COSE_ALG_ES256),
+				"sig": a.sign(t, a.key, authData, cd),
+			}
+		}},
+		{"packed x5c", ATTESTATION_FMT_PACKED, func(a *softAuthenticator, authData, cd []byte) map[interface{}]interface{} {
+			return map[interface{}]interface{}{
+				"alg": int64(COSE_ALG_ES256),
+				"sig": a.sign(t, attKey, authData, cd),
+				"x5c": []interface{}{attestationCert(t, attKey, a.aaguid)},
+			}
+		}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := newSoftAuthenticator(t)
+			cd := clientData(t, WEBAUTHN_TYPE_CREATE, challenge, testOrigin)
+			authData := a.authData(testRPID, authDataFlagUP|authDataFlagUV|authDataFlagAT, true)
+
+			cred, err := testWebAuthnConfig.VerifyRegistration(challenge, cd, attestationObject(tc.fmtName, tc.attStmt(a, authData, cd), authData), true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(cred.CredentialID) != string(a.credentialID) || cred.AttestationFmt != tc.fmtName || !cred.UserVerified {
+				t.Fatalf("unexpected credential %+v", cred)
+			}
+			if _, _, err := ParseCOSEKey(cred.PublicKey); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestVerifyRegistrationRejects(t *testing.T) {
+	challenge := []byte("registration-challenge")
+	a := newSoftAuthenticator(t)
+	cd := clientData(t, WEBAUTHN_TYPE_CREATE, challenge, testOrigin)
+	authData := a.authData(testRPID, authDataFlagUP|authDataFlagAT, true)
+	none := map[interface{}]interface{}{}
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name      string
+		challenge []byte
+		cd        []byte
+		attObj    []byte
+		requireUV bool
+		want      error
+	}{
+		{"malformed CBOR", challenge, cd, []byte{0xa3, 0x63, 'f', 'm', 't'}, false, ErrWebAuthnAttestation},
+		{"not a map", challenge, cd, cborItem("none"), false, ErrWebAuthnAttestation},
+		{"truncated authData", challenge, cd, attestationObject(ATTESTATION_FMT_NONE, none, authData[:len(authData)-3]), false, ErrWebAuthnAuthData},
+		{"authData under 37 bytes", challenge, cd, attestationObject(ATTESTATION_FMT_NONE, none, authData[:36]), false, ErrWebAuthnAuthData},
+		{"trailing authData", challenge, cd, attestationObject(ATTESTATION_FMT_NONE, none, append(authData, 0x00)), false, ErrWebAuthnAuthData},
+		{"wrong rpIdHash", challenge, cd, attestationObject(ATTESTATION_FMT_NONE, none, a.authData("evil.example.com", authDataFlagUP|authDataFlagAT, true)), false, ErrWebAuthnAuthData},
+		{"no user presence", challenge, cd, attestationObject(ATTESTATION_FMT_NONE, none, a.authData(testRPID, authDataFlagAT, true)), false, ErrWebAuthnAuthData},
+		{"user verification required", challenge, cd, attestationObject(ATTESTATION_FMT_NONE, none, authData), true, ErrWebAuthnAuthData},
+		{"no credential", challenge, cd, attestationObject(ATTESTATION_FMT_NONE, none, a.authData(testRPID, authDataFlagUP, false)), false, ErrWebAuthnAuthData},
+		{"wrong challenge", []byte("other"), cd, attestationObject(ATTESTATION_FMT_NONE, none, authData), false, ErrWebAuthnClientData},
+		{"wrong origin", challenge, clientData(t, WEBAUTHN_TYPE_CREATE, challenge, "https://evil.example.com"), attestationObject(ATTESTATION_FMT_NONE, none, authData), false, ErrWebAuthnClientData},
+		{"wrong type", challenge, clientData(t, WEBAUTHN_TYPE_GET, challenge, testOrigin), attestationObject(ATTESTATION_FMT_NONE, none, authData), false, ErrWebAuthnClientData},
+		{"none with statement", challenge, cd, attestationObject(ATTESTATION_FMT_NONE, map[interface{}]interface{}{"alg": int64(COSE_ALG_ES256)}, authData), false, ErrWebAuthnAttestation},
+		{"unknown format", challenge, cd, attestationObject("fido-u2f", none, authData), false, ErrWebAuthnAttestation},
+		{"packed bad signature", challenge, cd, attestationObject(ATTESTATION_FMT_PACKED, map[interface{}]interface{}{
+			"alg": int64(COSE_ALG_ES256),
+			"sig": a.sign(t, otherKey, authData, cd),
+		}, authData), false, ErrWebAuthnSignature},
+		{"packed x5c for another AAGUID", challenge, cd, attestationObject(ATTESTATION_FMT_PACKED, map[interface{}]interface{}{
+			"alg": int64(COSE_ALG_ES256),
+			"sig": a.sign(t, otherKey, authData, cd),
+			"x5c": []interface{}{attestationCert(t, otherKey, []byte("fedcba9876543210"))},
+		}, authData), false, ErrWebAuthnAttestation},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := testWebAuthnConfig.VerifyRegistration(tc.challenge, tc.cd, tc.attObj, tc.requireUV); err != tc.want {
+				t.Fatalf("err = %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	challenge := []byte("assertion-challenge")
+	a := newSoftAuthenticator(t)
+	publicKey := a.coseKey()
+	cd := clientData(t, WEBAUTHN_TYPE_GET, challenge, testOrigin)
+
+	a.signCount = 7
+	authData := a.authData(testRPID, authDataFlagUP|authDataFlagUV, false)
+	signature := a.sign(t, a.key, authData, cd)
+
+	signCount, err := testWebAuthnConfig.VerifyAssertion(challenge, cd, authData, signature, publicKey, 6, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signCount != 7 {
+		t.Fatalf("signCount = %d, want 7", signCount)
+	}
+
+	// Authenticators without a counter always report 0.
+	a.signCount = 0
+	zeroAuthData := a.authData(testRPID, authDataFlagUP, false)
+	if _, err := testWebAuthnConfig.VerifyAssertion(challenge, cd, zeroAuthData, a.sign(t, a.key, zeroAuthData, cd), publicKey, 0, false); err != nil {
+		t.Fatal(err)
+	}
+
+	wrongRP := a.authData("evil.example.com", authDataFlagUP|authDataFlagUV, false)
+
+	cases := []struct {
+		name      string
+		authData  []byte
+		signature []byte
+		stored    uint32
+		want      error
+	}{
+		{"sign count regression", authData, signature, 9, ErrWebAuthnSignCount},
+		{"sign count replay", authData, signature, 7, ErrWebAuthnSignCount},
+		{"wrong rpIdHash", wrongRP, a.sign(t, a.key, wrongRP, cd), 0, ErrWebAuthnAuthData},
+		{"truncated authData", authData[:30], signature, 0, ErrWebAuthnAuthData},
+		{"tampered authData", append(append([]byte{}, authData[:36]...), 0x08), signature, 0, ErrWebAuthnSignature},
+		{"bad signature", authData, []byte{0x30, 0x00}, 0, ErrWebAuthnSignature},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := testWebAuthnConfig.VerifyAssertion(challenge, cd, tc.authData, tc.signature, publicKey, tc.stored, true); err != tc.want {
+				t.Fatalf("err = %v, want %v", err, tc.want)
+			}
+		})
+	}
+
+	if _, err := testWebAuthnConfig.VerifyAssertion(challenge, cd, authData, signature, []byte{0xa5, 0x01}, 0, true); err != ErrWebAuthnCOSEKey {
+		t.Fatalf("malformed COSE key: err = %v, want %v", err, ErrWebAuthnCOSEKey)
+	}
+}
+
+func TestDecoyDescriptors(t *testing.T) {
+	cfg := &WebAuthnConfig{RPID: testRPID, DecoySecret: []byte("secret")}
+
+	first := cfg.DecoyDescriptors("a@example.com")
+	if len(first) == 0 || len(first) > 2 {
+		t.Fatalf("got %d decoys", len(first))
+	}
+	if again := cfg.DecoyDescriptors("A@Example.com"); !reflect.DeepEqual(again, first) {
+		t.Fatalf("decoys changed between requests: %v, %v", first, again)
+	}
+	if other := cfg.DecoyDescriptors("b@example.com"); reflect.DeepEqual(other, first) {
+		t.Fatal("two identities got the same decoys")
+	}
+
+	rekeyed := &WebAuthnConfig{RPID: testRPID, DecoySecret: []byte("another secret")}
+	if reflect.DeepEqual(rekeyed.DecoyDescriptors("a@example.com"), first) {
+		t.Fatal("the decoys do not depend on the secret")
+	}
+}
@@ -0,0 +1,251 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hexcraft-biz/base-accounts-service/misc"
+	"github.com/hexcraft-biz/model"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	EMAIL_OUTBOX_STATUS_PENDING = "pending"
+	EMAIL_OUTBOX_STATUS_SENT    = "sent"
+	EMAIL_OUTBOX_STATUS_DEAD    = "dead"
+
+	EMAIL_OUTBOX_SENT_RETENTION = 7 * 24 * time.Hour
+)
+
+func IsValidEmailOutboxStatus(status string) bool {
+	switch status {
+	case EMAIL_OUTBOX_STATUS_PENDING, EMAIL_OUTBOX_STATUS_SENT, EMAIL_OUTBOX_STATUS_DEAD:
+		return true
+	default:
+		return false
+	}
+}
+
+// ================================================================
+// Data Struct
+// ================================================================
+type EntityEmailOutbox struct {
+	*model.Prototype `dive:""`
+	Type             string     `db:"type"`
+	SenderName       string     `db:"sender_name"`
+	Sender           string     `db:"sender"`
+	Recipient        string     `db:"recipient"`
+	Subject          string     `db:"subject"`
+	HTML             string     `db:"html"`
+	Text             string     `db:"text"`
+	Status           string     `db:"status"`
+	Attempts         int        `db:"attempts"`
+	NextAttemptAt    *time.Time `db:"next_attempt_at"`
+	ClaimID          *uuid.UUID `db:"claim_id"`
+	LastError        *string    `db:"last_error"`
+	SentAt           *time.Time `db:"sent_at"`
+}
+
+// GetMessage keeps Date and Message-ID of the queued row, a retried delivery is the same message.
+func (o *EntityEmailOutbox) GetMessage() *misc.EmailMessage {
+	domain := o.Sender[strings.LastIndex(o.Sender, "@")+1:]
+
+	return &misc.EmailMessage{
+		FromName:  o.SenderName,
+		From:      o.Sender,
+		To:        []string{o.Recipient},
+		Subject:   o.Subject,
+		HTML:      o.HTML,
+		Text:      o.Text,
+		Date:      *o.Ctime,
+		MessageID: o.ID.String() + "@" + domain,
+	}
+}
+
+// GetAbsEmailOutbox leaves the bodies out, they carry the single-use links.
+func (o *EntityEmailOutbox) GetAbsEmailOutbox() *AbsEmailOutbox {
+	return &AbsEmailOutbox{
+		ID:            o.ID.String(),
+		Type:          o.Type,
+		Recipient:     o.Recipient,
+		Subject:       o.Subject,
+		Status:        o.Status,
+		Attempts:      o.Attempts,
+		NextAttemptAt: o.NextAttemptAt,
+		LastError:     o.LastError,
+		SentAt:        o.SentAt,
+		CreatedAt:     o.Ctime,
+	}
+}
+
+type AbsEmailOutbox struct {
+	ID            string     `json:"id"`
+	Type          string     `json:"type"`
+	Recipient     string     `json:"recipient"`
+	Subject       string     `json:"subject"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `json:"nextAttemptAt"`
+	LastError     *string    `json:"lastError"`
+	SentAt        *time.Time `json:"sentAt"`
+	CreatedAt     *time.Time `json:"createdAt"`
+}
+
+// ================================================================
+// Engine
+// ================================================================
+type EmailOutboxTableEngine struct {
+	*model.Engine
+}
+
+func NewEmailOutboxTableEngine(db *sqlx.DB) *EmailOutboxTableEngine {
+	return &EmailOutboxTableEngine{
+		Engine: model.NewEngine(db, "email_outbox"),
+	}
+}
+
+// Insert queues one row per recipient, the worker picks them up on its next poll. It runs within the transaction of
+// the write the email tells about, so a rolled back write sends nothing and a committed one always gets its email.
+func (e *EmailOutboxTableEngine) Insert(tx *sqlx.Tx, typ string, m *misc.EmailMessage) ([]*EntityEmailOutbox, error) {
+	nowTime := time.Now().UTC().Truncate(time.Second)
+	rows := []*EntityEmailOutbox{}
+
+	for _, to := range m.To {
+		o := &EntityEmailOutbox{
+			Prototype:     model.NewPrototype(),
+			Type:          typ,
+			SenderName:    m.FromName,
+			Sender:        m.From,
+			Recipient:     to,
+			Subject:       m.Subject,
+			HTML:          m.HTML,
+			Text:          m.Text,
+			Status:        EMAIL_OUTBOX_STATUS_PENDING,
+			NextAttemptAt: &nowTime,
+		}
+
+		if err := insertTx(tx, e.TblName, o); err != nil {
+			return rows, err
+		}
+		rows = append(rows, o)
+	}
+
+	return rows, nil
+}
+
+// GetByID returns nil for an id that is not a UUID, like for an unknown message.
+func (e *EmailOutboxTableEngine) GetByID(id string) (*EntityEmailOutbox, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, nil
+	}
+
+	row := EntityEmailOutbox{}
+	q := `SELECT * FROM ` + e.TblName + ` WHERE id = UUID_TO_BIN(?);`
+	if err := e.Engine.Get(&row, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		} else {
+			return nil, err
+		}
+	}
+
+	return &row, nil
+}
+
+// List returns the messages of a status, the most recent first. An empty status lists every message.
+func (e *EmailOutboxTableEngine) List(status string, offset, length int) ([]*EntityEmailOutbox, error) {
+	rows := []*EntityEmailOutbox{}
+	if status == "" {
+		q := `SELECT * FROM ` + e.TblName + ` ORDER BY ctime DESC LIMIT ?, ?;`
+		if err := e.Engine.Select(&rows, q, offset, length); err != nil {
+			return nil, err
+		}
+	} else {
+		q := `SELECT * FROM ` + e.TblName + ` WHERE status = ? ORDER BY ctime DESC LIMIT ?, ?;`
+		if err := e.Engine.Select(&rows, q, status, offset, length); err != nil {
+			return nil, err
+		}
+	}
+
+	return rows, nil
+}
+
+// Claim leases up to limit due messages to the caller. The single UPDATE keeps concurrent workers, even of other
+// replicas, from claiming the same message; the lease moves next_attempt_at so an unfinished claim expires. Every
+// claim counts as an attempt, so a message whose lease keeps expiring is given up like one that keeps failing.
+func (e *EmailOutboxTableEngine) Claim(limit int, lease time.Duration, maxAttempts int) ([]*EntityEmailOutbox, error) {
+	nowTime := time.Now().UTC()
+	claimID := uuid.New()
+
+	q := `UPDATE ` + e.TblName + ` SET status = ?, last_error = ?, claim_id = NULL
+		WHERE status = ? AND next_attempt_at <= ? AND attempts >= ?;`
+	if _, err := e.Exec(q, EMAIL_OUTBOX_STATUS_DEAD, "lease expired", EMAIL_OUTBOX_STATUS_PENDING, nowTime, maxAttempts); err != nil {
+		return nil, err
+	}
+
+	q = `UPDATE ` + e.TblName + ` SET claim_id = UUID_TO_BIN(?), attempts = attempts + 1, next_attempt_at = ?
+		WHERE status = ? AND next_attempt_at <= ? ORDER BY next_attempt_at LIMIT ?;`
+	if rst, err := e.Exec(q, &claimID, nowTime.Add(lease), EMAIL_OUTBOX_STATUS_PENDING, nowTime, limit); err != nil {
+		return nil, err
+	} else if affected, err := rst.RowsAffected(); err != nil || affected == 0 {
+		return nil, err
+	}
+
+	rows := []*EntityEmailOutbox{}
+	q = `SELECT * FROM ` + e.TblName + ` WHERE claim_id = UUID_TO_BIN(?) AND status = ?;`
+	if err := e.Engine.Select(&rows, q, &claimID, EMAIL_OUTBOX_STATUS_PENDING); err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// MarkSent empties the bodies, a delivered link must not stay readable in the database.
+func (e *EmailOutboxTableEngine) MarkSent(o *EntityEmailOutbox) (int64, error) {
+	q := `UPDATE ` + e.TblName + ` SET status = ?, html = '', text = '', last_error = NULL, sent_at = ?
+		WHERE id = UUID_TO_BIN(?) AND claim_id = UUID_TO_BIN(?);`
+	if rst, err := e.Exec(q, EMAIL_OUTBOX_STATUS_SENT, time.Now().UTC(), o.ID, o.ClaimID); err != nil {
+		return 0, err
+	} else {
+		return rst.RowsAffected()
+	}
+}
+
+// MarkFailed schedules the next attempt with the backoff of the policy, or gives the message up once it failed
+// MaxAttempts times. Attempts already counts the claim of this delivery.
+func (e *EmailOutboxTableEngine) MarkFailed(o *EntityEmailOutbox, sendErr error, policy *misc.EmailOutboxPolicy) (int64, error) {
+	status := EMAIL_OUTBOX_STATUS_PENDING
+	if o.Attempts >= policy.MaxAttempts {
+		status = EMAIL_OUTBOX_STATUS_DEAD
+	}
+
+	q := `UPDATE ` + e.TblName + ` SET status = ?, last_error = ?, next_attempt_at = ?, claim_id = NULL
+		WHERE id = UUID_TO_BIN(?) AND claim_id = UUID_TO_BIN(?);`
+	if rst, err := e.Exec(q, status, sendErr.Error(), time.Now().UTC().Add(policy.Backoff(o.Attempts)), o.ID, o.ClaimID); err != nil {
+		return 0, err
+	} else {
+		return rst.RowsAffected()
+	}
+}
+
+// Retry puts a dead message back in the queue with a fresh attempt budget.
+func (e *EmailOutboxTableEngine) Retry(id string) (int64, error) {
+	q := `UPDATE ` + e.TblName + ` SET status = ?, attempts = 0, next_attempt_at = ?, claim_id = NULL
+		WHERE id = UUID_TO_BIN(?) AND status = ?;`
+	if rst, err := e.Exec(q, EMAIL_OUTBOX_STATUS_PENDING, time.Now().UTC(), id, EMAIL_OUTBOX_STATUS_DEAD); err != nil {
+		return 0, err
+	} else {
+		return rst.RowsAffected()
+	}
+}
+
+func (e *EmailOutboxTableEngine) DeleteSent(before time.Time) (int64, error) {
+	q := `DELETE FROM ` + e.TblName + ` WHERE status = ? AND sent_at < ?;`
+	if rst, err := e.Exec(q, EMAIL_OUTBOX_STATUS_SENT, before.UTC()); err != nil {
+		return 0, err
+	} else {
+		return rst.RowsAffected()
+	}
+}
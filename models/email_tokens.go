@@ -0,0 +1,87 @@
+package models
+
+import (
+	"time"
+
+	"github.com/hexcraft-biz/model"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	EMAIL_TOKEN_STATUS_PENDING  = "pending"
+	EMAIL_TOKEN_STATUS_CONSUMED = "consumed"
+	EMAIL_TOKEN_STATUS_REVOKED  = "revoked"
+)
+
+// ================================================================
+// Data Struct
+// ================================================================
+type EntityEmailToken struct {
+	*model.Prototype `dive:""`
+	Email            string     `db:"email"`
+	Type             string     `db:"type"`
+	Status           string     `db:"status"`
+	ExpiresAt        *time.Time `db:"expires_at"`
+}
+
+// ================================================================
+// Engine
+// ================================================================
+type EmailTokensTableEngine struct {
+	*model.Engine
+}
+
+func NewEmailTokensTableEngine(db *sqlx.DB) *EmailTokensTableEngine {
+	return &EmailTokensTableEngine{
+		Engine: model.NewEngine(db, "email_tokens"),
+	}
+}
+
+// Insert runs within the transaction that queues the email carrying the token.
+func (e *EmailTokensTableEngine) Insert(tx *sqlx.Tx, email string, typ string, expiresAt time.Time) (*EntityEmailToken, error) {
+	expiresAt = expiresAt.UTC().Truncate(time.Second)
+
+	t := &EntityEmailToken{
+		Prototype: model.NewPrototype(),
+		Email:     email,
+		Type:      typ,
+		Status:    EMAIL_TOKEN_STATUS_PENDING,
+		ExpiresAt: &expiresAt,
+	}
+
+	return t, insertTx(tx, e.TblName, t)
+}
+
+// RevokePending invalidates every outstanding token of the same type previously sent to the email.
+func (e *EmailTokensTableEngine) RevokePending(tx *sqlx.Tx, email string, typ string) (int64, error) {
+	q := `UPDATE ` + e.TblName + ` SET status = ? WHERE email = ? AND type = ? AND status = ?;`
+	if rst, err := tx.Exec(q, EMAIL_TOKEN_STATUS_REVOKED, email, typ, EMAIL_TOKEN_STATUS_PENDING); err != nil {
+		return 0, err
+	} else {
+		return rst.RowsAffected()
+	}
+}
+
+func (e *EmailTokensTableEngine) IsPending(id string, typ string) (bool, error) {
+	isPending := false
+	q := `SELECT EXISTS (SELECT 1 FROM ` + e.TblName + ` WHERE id = UUID_TO_BIN(?) AND type = ? AND status = ? AND expires_at > ?);`
+	if err := e.Engine.Get(&isPending, q, id, typ, EMAIL_TOKEN_STATUS_PENDING, time.Now().UTC()); err != nil {
+		return false, err
+	}
+
+	return isPending, nil
+}
+
+// Consume atomically flips a pending token to consumed, it returns false when the token was already used,
+// revoked or expired. It runs within the transaction of the write the token grants, so a failed write leaves the
+// token pending.
+func (e *EmailTokensTableEngine) Consume(tx *sqlx.Tx, id string, typ string) (bool, error) {
+	q := `UPDATE ` + e.TblName + ` SET status = ? WHERE id = UUID_TO_BIN(?) AND type = ? AND status = ? AND expires_at > ?;`
+	if rst, err := tx.Exec(q, EMAIL_TOKEN_STATUS_CONSUMED, id, typ, EMAIL_TOKEN_STATUS_PENDING, time.Now().UTC()); err != nil {
+		return false, err
+	} else if affected, err := rst.RowsAffected(); err != nil {
+		return false, err
+	} else {
+		return affected == 1, nil
+	}
+}
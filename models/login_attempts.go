@@ -0,0 +1,120 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hexcraft-biz/base-accounts-service/misc"
+	"github.com/hexcraft-biz/model"
+	"github.com/jmoiron/sqlx"
+)
+
+// ================================================================
+// Data Struct
+// ================================================================
+type EntityLoginAttempt struct {
+	*model.Prototype `dive:""`
+	Identity         string     `db:"identity"`
+	FailedCount      int        `db:"failed_count"`
+	LockCount        int        `db:"lock_count"`
+	LockedUntil      *time.Time `db:"locked_until"`
+	LastFailedAt     *time.Time `db:"last_failed_at"`
+}
+
+func (a *EntityLoginAttempt) IsLocked() bool {
+	return a.LockedUntil != nil && time.Now().Before(*a.LockedUntil)
+}
+
+// RetryAfterSecs is rounded up, so a client waiting that long is never refused again.
+func (a *EntityLoginAttempt) RetryAfterSecs() int {
+	if !a.IsLocked() {
+		return 0
+	}
+	return int(time.Until(*a.LockedUntil).Seconds()) + 1
+}
+
+// ================================================================
+// Engine
+// ================================================================
+type LoginAttemptsTableEngine struct {
+	*model.Engine
+}
+
+func NewLoginAttemptsTableEngine(db *sqlx.DB) *LoginAttemptsTableEngine {
+	return &LoginAttemptsTableEngine{
+		Engine: model.NewEngine(db, "login_attempts"),
+	}
+}
+
+func (e *LoginAttemptsTableEngine) GetByIdentity(identity string) (*EntityLoginAttempt, error) {
+	row := EntityLoginAttempt{}
+	q := `SELECT * FROM ` + e.TblName + ` WHERE identity = ?;`
+	if err := e.Engine.Get(&row, q, identity); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		} else {
+			return nil, err
+		}
+	}
+
+	return &row, nil
+}
+
+// RecordFailure counts a failed login and locks the identity once the threshold is reached. Both statements are
+// single row updates, so concurrent failures can't skip the lock. It returns the row and whether this very
+// failure caused the lock.
+func (e *LoginAttemptsTableEngine) RecordFailure(identity string, policy *misc.LockoutPolicy) (*EntityLoginAttempt, bool, error) {
+	nowTime := time.Now().UTC().Truncate(time.Second)
+	resetBefore := nowTime.Add(-policy.ResetWindow())
+	id := uuid.New()
+
+	// MySQL evaluates the assignments from left to right, last_failed_at must be updated last.
+	q := `INSERT INTO ` + e.TblName + ` (id, identity, failed_count, lock_count, last_failed_at) VALUES (UUID_TO_BIN(?), ?, 1, 0, ?)
+		ON DUPLICATE KEY UPDATE
+			failed_count = IF(last_failed_at < ?, 1, failed_count + 1),
+			lock_count = IF(last_failed_at < ?, 0, lock_count),
+			last_failed_at = ?;`
+	if _, err := e.Exec(q, &id, identity, nowTime, resetBefore, resetBefore, nowTime); err != nil {
+		return nil, false, err
+	}
+
+	q = `UPDATE ` + e.TblName + ` SET
+			locked_until = DATE_ADD(?, INTERVAL LEAST(? * POW(2, lock_count), ?) SECOND),
+			lock_count = lock_count + 1,
+			failed_count = 0
+		WHERE identity = ? AND failed_count >= ?;`
+	rst, err := e.Exec(q, nowTime, policy.BaseSecs, policy.MaxSecs, identity, policy.Threshold)
+	if err != nil {
+		return nil, false, err
+	}
+
+	affected, err := rst.RowsAffected()
+	if err != nil {
+		return nil, false, err
+	}
+
+	row, err := e.GetByIdentity(identity)
+	return row, affected == 1, err
+}
+
+// Clear forgets every failure of the identity, it is used after a successful login and to unlock an account.
+func (e *LoginAttemptsTableEngine) Clear(tx *sqlx.Tx, identity string) (int64, error) {
+	q := `DELETE FROM ` + e.TblName + ` WHERE identity = ?;`
+	if rst, err := tx.Exec(q, identity); err != nil {
+		return 0, err
+	} else {
+		return rst.RowsAffected()
+	}
+}
+
+// DeleteStale removes rows that are neither locked nor inside the reset window any more.
+func (e *LoginAttemptsTableEngine) DeleteStale(policy *misc.LockoutPolicy) (int64, error) {
+	nowTime := time.Now().UTC()
+	q := `DELETE FROM ` + e.TblName + ` WHERE last_failed_at < ? AND (locked_until IS NULL OR locked_until < ?);`
+	if rst, err := e.Exec(q, nowTime.Add(-policy.ResetWindow()), nowTime); err != nil {
+		return 0, err
+	} else {
+		return rst.RowsAffected()
+	}
+}
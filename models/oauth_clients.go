@@ -0,0 +1,151 @@
+package models
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/hexcraft-biz/base-accounts-service/misc"
+	"github.com/hexcraft-biz/model"
+	"github.com/jmoiron/sqlx"
+)
+
+// ================================================================
+// Data Struct
+// ================================================================
+type EntityOAuthClient struct {
+	*model.Prototype `dive:""`
+	Name             string `db:"name"`
+	SecretHash       []byte `db:"secret_hash"`
+	RedirectURIs     string `db:"redirect_uris"`
+	Scopes           string `db:"scopes"`
+}
+
+// IsPublic is a client without secret, e.g. a single page or mobile app, it relies on PKCE alone.
+func (c *EntityOAuthClient) IsPublic() bool {
+	return c.SecretHash == nil
+}
+
+func (c *EntityOAuthClient) VerifySecret(secret string) bool {
+	return !c.IsPublic() && subtle.ConstantTimeCompare(c.SecretHash, misc.HashToken(secret)) == 1
+}
+
+// HasRedirectURI compares exactly, no prefix nor wildcard is accepted.
+func (c *EntityOAuthClient) HasRedirectURI(uri string) bool {
+	for _, u := range strings.Fields(c.RedirectURIs) {
+		if u == uri {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *EntityOAuthClient) GetAbsOAuthClient() *AbsOAuthClient {
+	return &AbsOAuthClient{
+		ClientID:     *c.ID,
+		Name:         c.Name,
+		Public:       c.IsPublic(),
+		RedirectURIs: strings.Fields(c.RedirectURIs),
+		Scopes:       strings.Fields(c.Scopes),
+		CreatedAt:    c.Ctime.Format("2006-01-02 15:04:05"),
+		UpdatedAt:    c.Mtime.Format("2006-01-02 15:04:05"),
+	}
+}
+
+type AbsOAuthClient struct {
+	ClientID     uuid.UUID `json:"clientId"`
+	Name         string    `json:"name"`
+	Public       bool      `json:"public"`
+	RedirectURIs []string  `json:"redirectUris"`
+	Scopes       []string  `json:"scopes"`
+	CreatedAt    string    `json:"createdAt"`
+	UpdatedAt    string    `json:"updatedAt"`
+}
+
+// ================================================================
+// Engine
+// ================================================================
+type OAuthClientsTableEngine struct {
+	*model.Engine
+}
+
+func NewOAuthClientsTableEngine(db *sqlx.DB) *OAuthClientsTableEngine {
+	return &OAuthClientsTableEngine{
+		Engine: model.NewEngine(db, "oauth_clients"),
+	}
+}
+
+// Insert registers a client, secretHash is nil for a public client.
+func (e *OAuthClientsTableEngine) Insert(name string, secretHash []byte, redirectURIs []string, scopes []string) (*EntityOAuthClient, error) {
+	c := &EntityOAuthClient{
+		Prototype:    model.NewPrototype(),
+		Name:         name,
+		SecretHash:   secretHash,
+		RedirectURIs: strings.Join(redirectURIs, " "),
+		Scopes:       strings.Join(scopes, " "),
+	}
+
+	_, err := e.Engine.Insert(c)
+	return c, err
+}
+
+// GetByID returns nil for an id that is not a UUID, like for an unknown client.
+func (e *OAuthClientsTableEngine) GetByID(id string) (*EntityOAuthClient, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, nil
+	}
+
+	row := EntityOAuthClient{}
+	q := `SELECT * FROM ` + e.TblName + ` WHERE id = UUID_TO_BIN(?);`
+	if err := e.Engine.Get(&row, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		} else {
+			return nil, err
+		}
+	}
+
+	return &row, nil
+}
+
+// List pages through the clients, the newest first.
+func (e *OAuthClientsTableEngine) List(offset, length int) ([]*EntityOAuthClient, error) {
+	rows := []*EntityOAuthClient{}
+	q := `SELECT * FROM ` + e.TblName + ` ORDER BY ctime DESC, id LIMIT ?, ?;`
+	if err := e.Engine.Select(&rows, q, offset, length); err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+func (e *OAuthClientsTableEngine) Update(id *uuid.UUID, name string, redirectURIs []string, scopes []string) (int64, error) {
+	q := `UPDATE ` + e.TblName + ` SET name = ?, redirect_uris = ?, scopes = ? WHERE id = UUID_TO_BIN(?);`
+	if rst, err := e.Exec(q, name, strings.Join(redirectURIs, " "), strings.Join(scopes, " "), &id); err != nil {
+		return 0, err
+	} else {
+		return rst.RowsAffected()
+	}
+}
+
+// UpdateSecret replaces the secret of a confidential client, the previous one stops working at once.
+func (e *OAuthClientsTableEngine) UpdateSecret(id *uuid.UUID, secretHash []byte) (int64, error) {
+	q := `UPDATE ` + e.TblName + ` SET secret_hash = ? WHERE id = UUID_TO_BIN(?) AND secret_hash IS NOT NULL;`
+	if rst, err := e.Exec(q, secretHash, &id); err != nil {
+		return 0, err
+	} else {
+		return rst.RowsAffected()
+	}
+}
+
+// Delete removes the client, its codes and refresh tokens go with it through their foreign keys.
+func (e *OAuthClientsTableEngine) Delete(id *uuid.UUID) (int64, error) {
+	q := `DELETE FROM ` + e.TblName + ` WHERE id = UUID_TO_BIN(?);`
+	if rst, err := e.Exec(q, &id); err != nil {
+		return 0, err
+	} else {
+		return rst.RowsAffected()
+	}
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hexcraft-biz/model"
+	"github.com/jmoiron/sqlx"
+)
+
+// ================================================================
+// Data Struct
+// ================================================================
+type EntityOAuthCode struct {
+	*model.Prototype `dive:""`
+	CodeHash         []byte     `db:"code_hash"`
+	ClientID         *uuid.UUID `db:"client_id"`
+	UserID           *uuid.UUID `db:"user_id"`
+	RedirectURI      string     `db:"redirect_uri"`
+	Scope            string     `db:"scope"`
+	Nonce            *string    `db:"nonce"`
+	CodeChallenge    string     `db:"code_challenge"`
+	ExpiresAt        *time.Time `db:"expires_at"`
+}
+
+// ================================================================
+// Engine
+// ================================================================
+type OAuthCodesTableEngine struct {
+	*model.Engine
+}
+
+func NewOAuthCodesTableEngine(db *sqlx.DB) *OAuthCodesTableEngine {
+	return &OAuthCodesTableEngine{
+		Engine: model.NewEngine(db, "oauth_codes"),
+	}
+}
+
+func (e *OAuthCodesTableEngine) Insert(codeHash []byte, clientID, userID *uuid.UUID, redirectURI, scope, nonce, codeChallenge string, expiresAt time.Time) (*EntityOAuthCode, error) {
+	expiresAt = expiresAt.UTC().Truncate(time.Second)
+
+	c := &EntityOAuthCode{
+		Prototype:     model.NewPrototype(),
+		CodeHash:      codeHash,
+		ClientID:      clientID,
+		UserID:        userID,
+		RedirectURI:   redirectURI,
+		Scope:         scope,
+		CodeChallenge: codeChallenge,
+		ExpiresAt:     &expiresAt,
+	}
+	if nonce != "" {
+		c.Nonce = &nonce
+	}
+
+	_, err := e.Engine.Insert(c)
+	return c, err
+}
+
+// GetByCodeHash returns nil when the code does not exist or has expired. Reading leaves the code in place, the
+// caller checks it against the request before Consume.
+func (e *OAuthCodesTableEngine) GetByCodeHash(codeHash []byte) (*EntityOAuthCode, error) {
+	row := EntityOAuthCode{}
+	q := `SELECT * FROM ` + e.TblName + ` WHERE code_hash = ? AND expires_at > ?;`
+	if err := e.Engine.Get(&row, q, codeHash, time.Now().UTC()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		} else {
+			return nil, err
+		}
+	}
+
+	return &row, nil
+}
+
+// Consume deletes the code, so a code is exchanged once only. It returns false when the code was consumed
+// concurrently.
+func (e *OAuthCodesTableEngine) Consume(id *uuid.UUID) (bool, error) {
+	q := `DELETE FROM ` + e.TblName + ` WHERE id = UUID_TO_BIN(?);`
+	if rst, err := e.Exec(q, id); err != nil {
+		return false, err
+	} else if affected, err := rst.RowsAffected(); err != nil {
+		return false, err
+	} else {
+		return affected == 1, nil
+	}
+}
+
+func (e *OAuthCodesTableEngine) DeleteExpired() (int64, error) {
+	q := `DELETE FROM ` + e.TblName + ` WHERE expires_at <= ?;`
+	if rst, err := e.Exec(q, time.Now().UTC()); err != nil {
+		return 0, err
+	} else {
+		return rst.RowsAffected()
+	}
+}
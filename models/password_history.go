@@ -0,0 +1,88 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"github.com/hexcraft-biz/base-accounts-service/misc"
+	"github.com/hexcraft-biz/model"
+	"github.com/jmoiron/sqlx"
+)
+
+// ================================================================
+// Data Struct
+// ================================================================
+type EntityPasswordHistory struct {
+	*model.Prototype `dive:""`
+	UserID           *uuid.UUID `db:"user_id"`
+	Password         []byte     `db:"password"`
+	PasswordAlgo     string     `db:"password_algo"`
+	Salt             []byte     `db:"salt"`
+}
+
+func (h *EntityPasswordHistory) VerifyPassword(password string) (bool, error) {
+	return misc.VerifyPassword(h.PasswordAlgo, string(h.Password), []byte(password), h.Salt)
+}
+
+// ================================================================
+// Engine
+// ================================================================
+type PasswordHistoryTableEngine struct {
+	*model.Engine
+}
+
+func NewPasswordHistoryTableEngine(db *sqlx.DB) *PasswordHistoryTableEngine {
+	return &PasswordHistoryTableEngine{
+		Engine: model.NewEngine(db, "password_history"),
+	}
+}
+
+// Insert runs within the transaction writing the password of the user, so the history never misses a hash.
+func (e *PasswordHistoryTableEngine) Insert(tx *sqlx.Tx, userID *uuid.UUID, password []byte, passwordAlgo string, salt []byte) (*EntityPasswordHistory, error) {
+	h := &EntityPasswordHistory{
+		Prototype:    model.NewPrototype(),
+		UserID:       userID,
+		Password:     password,
+		PasswordAlgo: passwordAlgo,
+		Salt:         salt,
+	}
+
+	err := insertTx(tx, e.TblName, h)
+	return h, err
+}
+
+func (e *PasswordHistoryTableEngine) ListRecentByUserID(userID *uuid.UUID, limit int) ([]EntityPasswordHistory, error) {
+	rows := []EntityPasswordHistory{}
+	q := `SELECT * FROM ` + e.TblName + ` WHERE user_id = UUID_TO_BIN(?) ORDER BY ctime DESC LIMIT ?;`
+	if err := e.Engine.Select(&rows, q, &userID, limit); err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// Prune keeps the newest keep entries of every user and deletes the rest.
+func (e *PasswordHistoryTableEngine) Prune(keep int) (int64, error) {
+	q := `DELETE FROM ` + e.TblName + ` WHERE id IN (
+		SELECT id FROM (
+			SELECT id, ROW_NUMBER() OVER (PARTITION BY user_id ORDER BY ctime DESC) AS rn FROM ` + e.TblName + `
+		) AS ranked WHERE ranked.rn > ?
+	);`
+	if rst, err := e.Exec(q, keep); err != nil {
+		return 0, err
+	} else {
+		return rst.RowsAffected()
+	}
+}
+
+// PruneByUserID is Prune for a single user, it runs right after Insert within the same transaction.
+func (e *PasswordHistoryTableEngine) PruneByUserID(tx *sqlx.Tx, userID *uuid.UUID, keep int) (int64, error) {
+	q := `DELETE FROM ` + e.TblName + ` WHERE id IN (
+		SELECT id FROM (
+			SELECT id, ROW_NUMBER() OVER (ORDER BY ctime DESC) AS rn FROM ` + e.TblName + ` WHERE user_id = UUID_TO_BIN(?)
+		) AS ranked WHERE ranked.rn > ?
+	);`
+	if rst, err := tx.Exec(q, &userID, keep); err != nil {
+		return 0, err
+	} else {
+		return rst.RowsAffected()
+	}
+}
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hexcraft-biz/model"
+	"github.com/jmoiron/sqlx"
+)
+
+// ================================================================
+// Data Struct
+// ================================================================
+type EntityRefreshToken struct {
+	*model.Prototype `dive:""`
+	UserID           *uuid.UUID `db:"user_id"`
+	ClientID         *uuid.UUID `db:"client_id"`
+	SessionID        *uuid.UUID `db:"session_id"`
+	TokenHash        []byte     `db:"token_hash"`
+	Scope            *string    `db:"scope"`
+	ExpiresAt        *time.Time `db:"expires_at"`
+	RevokedAt        *time.Time `db:"revoked_at"`
+}
+
+func (t *EntityRefreshToken) IsExpired() bool {
+	return t.ExpiresAt == nil || time.Now().After(*t.ExpiresAt)
+}
+
+func (t *EntityRefreshToken) IsRevoked() bool {
+	return t.RevokedAt != nil
+}
+
+// IsIssuedTo tells whether the token belongs to the client, nil being /auth/v1.
+func (t *EntityRefreshToken) IsIssuedTo(clientID *uuid.UUID) bool {
+	if t.ClientID == nil || clientID == nil {
+		return t.ClientID == nil && clientID == nil
+	}
+
+	return *t.ClientID == *clientID
+}
+
+// ================================================================
+// Engine
+// ================================================================
+type RefreshTokensTableEngine struct {
+	*model.Engine
+}
+
+func NewRefreshTokensTableEngine(db *sqlx.DB) *RefreshTokensTableEngine {
+	return &RefreshTokensTableEngine{
+		Engine: model.NewEngine(db, "refresh_tokens"),
+	}
+}
+
+// Insert stores a refresh token, clientID is nil and scope empty for the tokens of /auth/v1 which belong to a session
+// instead. A token issued to an OAuth client keeps its client and scope, it can only be refreshed by that client.
+func (e *RefreshTokensTableEngine) Insert(tx *sqlx.Tx, userID *uuid.UUID, clientID *uuid.UUID, sessionID *uuid.UUID, scope string, tokenHash []byte, expiresAt time.Time) (*EntityRefreshToken, error) {
+	expiresAt = expiresAt.UTC().Truncate(time.Second)
+
+	t := &EntityRefreshToken{
+		Prototype: model.NewPrototype(),
+		UserID:    userID,
+		ClientID:  clientID,
+		SessionID: sessionID,
+		TokenHash: tokenHash,
+		ExpiresAt: &expiresAt,
+	}
+	if scope != "" {
+		t.Scope = &scope
+	}
+
+	return t, insertTx(tx, e.TblName, t)
+}
+
+func (e *RefreshTokensTableEngine) GetByTokenHash(tokenHash []byte) (*EntityRefreshToken, error) {
+	row := EntityRefreshToken{}
+	q := `SELECT * FROM ` + e.TblName + ` WHERE token_hash = ?;`
+	if err := e.Engine.Get(&row, q, tokenHash); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		} else {
+			return nil, err
+		}
+	}
+
+	return &row, nil
+}
+
+// Revoke marks a single token as revoked. It only affects tokens that are still active,
+// so a result of 0 rows tells the caller somebody else already used or revoked it.
+func (e *RefreshTokensTableEngine) Revoke(id *uuid.UUID) (int64, error) {
+	q := `UPDATE ` + e.TblName + ` SET revoked_at = CURRENT_TIMESTAMP WHERE id = UUID_TO_BIN(?) AND revoked_at IS NULL;`
+	if rst, err := e.Exec(q, &id); err != nil {
+		return 0, err
+	} else {
+		return rst.RowsAffected()
+	}
+}
+
+func (e *RefreshTokensTableEngine) RevokeByUserID(tx *sqlx.Tx, userID *uuid.UUID) (int64, error) {
+	q := `UPDATE ` + e.TblName + ` SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = UUID_TO_BIN(?) AND revoked_at IS NULL;`
+	if rst, err := tx.Exec(q, &userID); err != nil {
+		return 0, err
+	} else {
+		return rst.RowsAffected()
+	}
+}
+
+// RevokeUnboundByUserID revokes the tokens of /auth/v1 issued before sessions were recorded, they belong to no session.
+func (e *RefreshTokensTableEngine) RevokeUnboundByUserID(tx *sqlx.Tx, userID *uuid.UUID) (int64, error) {
+	q := `UPDATE ` + e.TblName + ` SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = UUID_TO_BIN(?) AND client_id IS NULL AND session_id IS NULL AND revoked_at IS NULL;`
+	if rst, err := tx.Exec(q, &userID); err != nil {
+		return 0, err
+	} else {
+		return rst.RowsAffected()
+	}
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hexcraft-biz/model"
+	"github.com/jmoiron/sqlx"
+)
+
+// ================================================================
+// Data Struct
+// ================================================================
+// EntityRevokedToken is a JWT revoked before its expiry, its ID is the jti of the token.
+type EntityRevokedToken struct {
+	*model.Prototype `dive:""`
+	ExpiresAt        *time.Time `db:"expires_at"`
+}
+
+// ================================================================
+// Engine
+// ================================================================
+type RevokedTokensTableEngine struct {
+	*model.Engine
+}
+
+func NewRevokedTokensTableEngine(db *sqlx.DB) *RevokedTokensTableEngine {
+	return &RevokedTokensTableEngine{
+		Engine: model.NewEngine(db, "revoked_tokens"),
+	}
+}
+
+// Insert revokes the jti until expiresAt, revoking a token twice is not an error.
+func (e *RevokedTokensTableEngine) Insert(jti *uuid.UUID, expiresAt time.Time) (int64, error) {
+	nowTime := time.Now().UTC().Truncate(time.Second)
+	q := `INSERT IGNORE INTO ` + e.TblName + ` (id, expires_at, ctime, mtime) VALUES (UUID_TO_BIN(?), ?, ?, ?);`
+	if rst, err := e.Exec(q, jti, expiresAt.UTC().Truncate(time.Second), nowTime, nowTime); err != nil {
+		return 0, err
+	} else {
+		return rst.RowsAffected()
+	}
+}
+
+// IsRevoked looks the jti up, a jti that is not a UUID was never issued by us and can not be revoked.
+func (e *RevokedTokensTableEngine) IsRevoked(jti string) (bool, error) {
+	if _, err := uuid.Parse(jti); err != nil {
+		return false, nil
+	}
+
+	var count int
+	q := `SELECT COUNT(*) FROM ` + e.TblName + ` WHERE id = UUID_TO_BIN(?);`
+	if err := e.Engine.Get(&count, q, jti); err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// DeleteExpired forgets the tokens that expired since, their signature check rejects them anyway.
+func (e *RevokedTokensTableEngine) DeleteExpired() (int64, error) {
+	q := `DELETE FROM ` + e.TblName + ` WHERE expires_at <= ?;`
+	if rst, err := e.Exec(q, time.Now().UTC()); err != nil {
+		return 0, err
+	} else {
+		return rst.RowsAffected()
+	}
+}
@@ -0,0 +1,174 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hexcraft-biz/model"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	SESSION_USER_AGENT_MAX_LEN = 512
+)
+
+// ================================================================
+// Data Struct
+// ================================================================
+// EntitySession is a login to /auth/v1 on one device, its refresh tokens go with it.
+type EntitySession struct {
+	*model.Prototype `dive:""`
+	UserID           *uuid.UUID `db:"user_id"`
+	UserAgent        string     `db:"user_agent"`
+	IP               string     `db:"ip"`
+	DeviceLabel      string     `db:"device_label"`
+	LastSeenAt       *time.Time `db:"last_seen_at"`
+}
+
+func (s *EntitySession) GetAbsSession(currentID string) *AbsSession {
+	return &AbsSession{
+		ID:          *s.ID,
+		DeviceLabel: s.DeviceLabel,
+		UserAgent:   s.UserAgent,
+		IP:          s.IP,
+		Current:     s.ID.String() == currentID,
+		CreatedAt:   s.Ctime.Format("2006-01-02 15:04:05"),
+		LastSeenAt:  s.LastSeenAt.Format("2006-01-02 15:04:05"),
+	}
+}
+
+type AbsSession struct {
+	ID          uuid.UUID `json:"id"`
+	DeviceLabel string    `json:"deviceLabel"`
+	UserAgent   string    `json:"userAgent"`
+	IP          string    `json:"ip"`
+	Current     bool      `json:"current"`
+	CreatedAt   string    `json:"createdAt"`
+	LastSeenAt  string    `json:"lastSeenAt"`
+}
+
+// ================================================================
+// Engine
+// ================================================================
+type SessionsTableEngine struct {
+	*model.Engine
+}
+
+func NewSessionsTableEngine(db *sqlx.DB) *SessionsTableEngine {
+	return &SessionsTableEngine{
+		Engine: model.NewEngine(db, "sessions"),
+	}
+}
+
+func (e *SessionsTableEngine) Insert(tx *sqlx.Tx, userID *uuid.UUID, userAgent, ip, deviceLabel string) (*EntitySession, error) {
+	if r := []rune(userAgent); len(r) > SESSION_USER_AGENT_MAX_LEN {
+		userAgent = string(r[:SESSION_USER_AGENT_MAX_LEN])
+	}
+
+	s := &EntitySession{
+		Prototype:   model.NewPrototype(),
+		UserID:      userID,
+		UserAgent:   userAgent,
+		IP:          ip,
+		DeviceLabel: deviceLabel,
+	}
+	s.LastSeenAt = s.Ctime
+
+	return s, insertTx(tx, e.TblName, s)
+}
+
+// Exists returns false for an id that is not a UUID, like for a session signed out.
+func (e *SessionsTableEngine) Exists(id string) (bool, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return false, nil
+	}
+
+	var count int
+	q := `SELECT COUNT(*) FROM ` + e.TblName + ` WHERE id = UUID_TO_BIN(?);`
+	if err := e.Engine.Get(&count, q, id); err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// GetByID only returns a session of the user, nil for an id that is not a UUID.
+func (e *SessionsTableEngine) GetByID(userID *uuid.UUID, id string) (*EntitySession, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, nil
+	}
+
+	row := EntitySession{}
+	q := `SELECT * FROM ` + e.TblName + ` WHERE id = UUID_TO_BIN(?) AND user_id = UUID_TO_BIN(?);`
+	if err := e.Engine.Get(&row, q, id, &userID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		} else {
+			return nil, err
+		}
+	}
+
+	return &row, nil
+}
+
+// ListByUserID returns the sessions seen since idleSince, the most recently seen first.
+func (e *SessionsTableEngine) ListByUserID(userID *uuid.UUID, idleSince time.Time) ([]*EntitySession, error) {
+	rows := []*EntitySession{}
+	q := `SELECT * FROM ` + e.TblName + ` WHERE user_id = UUID_TO_BIN(?) AND last_seen_at > ? ORDER BY last_seen_at DESC, id;`
+	if err := e.Engine.Select(&rows, q, &userID, idleSince.UTC()); err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// Touch moves last_seen_at to now, it is called whenever the session refreshes its tokens.
+func (e *SessionsTableEngine) Touch(tx *sqlx.Tx, id *uuid.UUID) (int64, error) {
+	q := `UPDATE ` + e.TblName + ` SET last_seen_at = ? WHERE id = UUID_TO_BIN(?);`
+	if rst, err := tx.Exec(q, time.Now().UTC().Truncate(time.Second), &id); err != nil {
+		return 0, err
+	} else {
+		return rst.RowsAffected()
+	}
+}
+
+// Delete signs the session out, its refresh tokens go with it through their foreign key.
+func (e *SessionsTableEngine) Delete(id *uuid.UUID) (int64, error) {
+	q := `DELETE FROM ` + e.TblName + ` WHERE id = UUID_TO_BIN(?);`
+	if rst, err := e.Exec(q, &id); err != nil {
+		return 0, err
+	} else {
+		return rst.RowsAffected()
+	}
+}
+
+// DeleteByUserID signs out every session of the user but exceptID, which can be nil.
+func (e *SessionsTableEngine) DeleteByUserID(tx *sqlx.Tx, userID *uuid.UUID, exceptID *uuid.UUID) (int64, error) {
+	var (
+		rst sql.Result
+		err error
+	)
+	if exceptID == nil {
+		q := `DELETE FROM ` + e.TblName + ` WHERE user_id = UUID_TO_BIN(?);`
+		rst, err = tx.Exec(q, &userID)
+	} else {
+		q := `DELETE FROM ` + e.TblName + ` WHERE user_id = UUID_TO_BIN(?) AND id <> UUID_TO_BIN(?);`
+		rst, err = tx.Exec(q, &userID, &exceptID)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return rst.RowsAffected()
+}
+
+// DeleteIdle removes the sessions not seen since before, their refresh tokens have expired by then.
+func (e *SessionsTableEngine) DeleteIdle(before time.Time) (int64, error) {
+	q := `DELETE FROM ` + e.TblName + ` WHERE last_seen_at <= ?;`
+	if rst, err := e.Exec(q, before.UTC()); err != nil {
+		return 0, err
+	} else {
+		return rst.RowsAffected()
+	}
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// WithTx runs fn in one transaction, it is committed when fn returns nil and rolled back otherwise. Engine methods
+// taking part in a transaction take the *sqlx.Tx as their first argument.
+func WithTx(db *sqlx.DB, fn func(tx *sqlx.Tx) error) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertTx is model.Engine.Insert within the transaction, nil pointers are left to the column defaults.
+func insertTx(tx *sqlx.Tx, tblName string, ams interface{}) error {
+	fields, placeholders := []string{}, []string{}
+	insertAssignments(ams, &fields, &placeholders)
+
+	q := `INSERT INTO ` + tblName + ` (` + strings.Join(fields, ",") + `) VALUES (` + strings.Join(placeholders, ",") + `);`
+	_, err := tx.NamedExec(q, ams)
+	return err
+}
+
+func insertAssignments(ams interface{}, fields, placeholders *[]string) {
+	v := reflect.ValueOf(ams)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+
+	for i := 0; i < v.NumField(); i++ {
+		val, struF := v.Field(i), v.Type().Field(i)
+		if val.Kind() == reflect.Ptr {
+			if val.IsNil() {
+				continue
+			}
+			val = val.Elem()
+		}
+
+		if _, ok := struF.Tag.Lookup("dive"); ok {
+			insertAssignments(val.Interface(), fields, placeholders)
+		} else if ctag := struF.Tag.Get("db"); ctag != "" && ctag != "-" {
+			*fields = append(*fields, ctag)
+			if val.Type().String() == "uuid.UUID" {
+				*placeholders = append(*placeholders, "UUID_TO_BIN(:"+ctag+")")
+			} else {
+				*placeholders = append(*placeholders, ":"+ctag)
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hexcraft-biz/model"
+	"github.com/jmoiron/sqlx"
+)
+
+// ================================================================
+// Data Struct
+// ================================================================
+type EntityUserRecoveryCode struct {
+	*model.Prototype `dive:""`
+	UserID           *uuid.UUID `db:"user_id"`
+	CodeHash         []byte     `db:"code_hash"`
+	UsedAt           *time.Time `db:"used_at"`
+}
+
+// ================================================================
+// Engine
+// ================================================================
+type UserRecoveryCodesTableEngine struct {
+	*model.Engine
+}
+
+func NewUserRecoveryCodesTableEngine(db *sqlx.DB) *UserRecoveryCodesTableEngine {
+	return &UserRecoveryCodesTableEngine{
+		Engine: model.NewEngine(db, "user_recovery_codes"),
+	}
+}
+
+// Replace drops every previous code of the user before storing the new set, in one transaction so the user never
+// ends up with a partial set or none at all.
+func (e *UserRecoveryCodesTableEngine) Replace(userID *uuid.UUID, codeHashes [][]byte) error {
+	return WithTx(e.Engine.DB, func(tx *sqlx.Tx) error {
+		q := `DELETE FROM ` + e.TblName + ` WHERE user_id = UUID_TO_BIN(?);`
+		if _, err := tx.Exec(q, &userID); err != nil {
+			return err
+		}
+
+		for _, codeHash := range codeHashes {
+			if err := insertTx(tx, e.TblName, &EntityUserRecoveryCode{
+				Prototype: model.NewPrototype(),
+				UserID:    userID,
+				CodeHash:  codeHash,
+			}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Consume marks a matching unused code as used, it returns false when nothing matched.
+func (e *UserRecoveryCodesTableEngine) Consume(userID *uuid.UUID, codeHash []byte) (bool, error) {
+	q := `UPDATE ` + e.TblName + ` SET used_at = ? WHERE user_id = UUID_TO_BIN(?) AND code_hash = ? AND used_at IS NULL LIMIT 1;`
+	if rst, err := e.Exec(q, time.Now().UTC(), &userID, codeHash); err != nil {
+		return false, err
+	} else if affected, err := rst.RowsAffected(); err != nil {
+		return false, err
+	} else {
+		return affected == 1, nil
+	}
+}
+
+func (e *UserRecoveryCodesTableEngine) DeleteByUserID(tx *sqlx.Tx, userID *uuid.UUID) (int64, error) {
+	q := `DELETE FROM ` + e.TblName + ` WHERE user_id = UUID_TO_BIN(?);`
+	if rst, err := tx.Exec(q, &userID); err != nil {
+		return 0, err
+	} else {
+		return rst.RowsAffected()
+	}
+}
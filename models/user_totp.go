@@ -0,0 +1,105 @@
+package models
+
+import (
+	"database/sql"
+
+	"github.com/google/uuid"
+	"github.com/hexcraft-biz/model"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	TOTP_STATUS_PENDING = "pending"
+	TOTP_STATUS_ENABLED = "enabled"
+)
+
+// ================================================================
+// Data Struct
+// ================================================================
+type EntityUserTOTP struct {
+	*model.Prototype `dive:""`
+	UserID           *uuid.UUID `db:"user_id"`
+	Secret           string     `db:"secret"`
+	Status           string     `db:"status"`
+	LastCounter      *int64     `db:"last_counter"`
+}
+
+func (t *EntityUserTOTP) IsEnabled() bool {
+	return t.Status == TOTP_STATUS_ENABLED
+}
+
+// ================================================================
+// Engine
+// ================================================================
+type UserTOTPTableEngine struct {
+	*model.Engine
+}
+
+func NewUserTOTPTableEngine(db *sqlx.DB) *UserTOTPTableEngine {
+	return &UserTOTPTableEngine{
+		Engine: model.NewEngine(db, "user_totp"),
+	}
+}
+
+// InsertPending replaces any unconfirmed enrollment of the user.
+func (e *UserTOTPTableEngine) InsertPending(userID *uuid.UUID, secret string) (*EntityUserTOTP, error) {
+	q := `DELETE FROM ` + e.TblName + ` WHERE user_id = UUID_TO_BIN(?) AND status = ?;`
+	if _, err := e.Exec(q, &userID, TOTP_STATUS_PENDING); err != nil {
+		return nil, err
+	}
+
+	t := &EntityUserTOTP{
+		Prototype: model.NewPrototype(),
+		UserID:    userID,
+		Secret:    secret,
+		Status:    TOTP_STATUS_PENDING,
+	}
+
+	_, err := e.Engine.Insert(t)
+	return t, err
+}
+
+func (e *UserTOTPTableEngine) GetByUserID(userID *uuid.UUID) (*EntityUserTOTP, error) {
+	row := EntityUserTOTP{}
+	q := `SELECT * FROM ` + e.TblName + ` WHERE user_id = UUID_TO_BIN(?);`
+	if err := e.Engine.Get(&row, q, &userID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		} else {
+			return nil, err
+		}
+	}
+
+	return &row, nil
+}
+
+func (e *UserTOTPTableEngine) Enable(id *uuid.UUID, counter int64) (int64, error) {
+	q := `UPDATE ` + e.TblName + ` SET status = ?, last_counter = ? WHERE id = UUID_TO_BIN(?) AND status = ?;`
+	if rst, err := e.Exec(q, TOTP_STATUS_ENABLED, counter, &id, TOTP_STATUS_PENDING); err != nil {
+		return 0, err
+	} else {
+		return rst.RowsAffected()
+	}
+}
+
+// UseCounter records the time step of an accepted code, it returns false when that step or a later one was
+// already used, which stops a code from being replayed.
+func (e *UserTOTPTableEngine) UseCounter(id *uuid.UUID, counter int64) (bool, error) {
+	q := `UPDATE ` + e.TblName + ` SET last_counter = ? WHERE id = UUID_TO_BIN(?) AND (last_counter IS NULL OR last_counter < ?);`
+	if rst, err := e.Exec(q, counter, &id, counter); err != nil {
+		return false, err
+	} else if affected, err := rst.RowsAffected(); err != nil {
+		return false, err
+	} else {
+		return affected == 1, nil
+	}
+}
+
+func (e *UserTOTPTableEngine) DeleteByUserID(tx *sqlx.Tx, userID *uuid.UUID) (int64, error) {
+	q := `DELETE FROM ` + e.TblName + ` WHERE user_id = UUID_TO_BIN(?);`
+	if rst, err := tx.Exec(q, &userID); err != nil {
+		return 0, err
+	} else {
+		return rst.RowsAffected()
+	}
+}
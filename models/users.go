@@ -3,27 +3,62 @@ package models
 import (
 	"crypto/rand"
 	"database/sql"
+	"errors"
 	"io"
+	"net/mail"
+	"strings"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/google/uuid"
+	"github.com/hexcraft-biz/base-accounts-service/misc"
 	"github.com/hexcraft-biz/model"
 	"github.com/jmoiron/sqlx"
-	"golang.org/x/crypto/bcrypt"
 )
 
 const (
 	PW_SALT_BYTES = 16
+
+	USER_STATUS_ENABLED   = "enabled"
+	USER_STATUS_DISABLED  = "disabled"
+	USER_STATUS_SUSPENDED = "suspended"
+
+	USERS_ORDER_ASC  = "asc"
+	USERS_ORDER_DESC = "desc"
 )
 
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func IsValidUserStatus(status string) bool {
+	switch status {
+	case USER_STATUS_ENABLED, USER_STATUS_DISABLED, USER_STATUS_SUSPENDED:
+		return true
+	default:
+		return false
+	}
+}
+
 // ================================================================
 // Data Struct
 // ================================================================
 type EntityUser struct {
-	*model.Prototype `dive:""`
-	Identity         string `db:"identity"`
-	Password         []byte `db:"password"`
-	Salt             []byte `db:"salt"`
-	Status           string `db:"status"`
+	*model.Prototype      `dive:""`
+	Identity              string  `db:"identity"`
+	Password              []byte  `db:"password"`
+	PasswordAlgo          string  `db:"password_algo"`
+	Salt                  []byte  `db:"salt"`
+	Status                string  `db:"status"`
+	StatusReason          *string `db:"status_reason"`
+	PasswordResetRequired bool    `db:"password_reset_required"`
+	Locale                *string `db:"locale"`
+}
+
+func (u *EntityUser) VerifyPassword(password string) (bool, error) {
+	return misc.VerifyPassword(u.PasswordAlgo, string(u.Password), []byte(password), u.Salt)
+}
+
+// NeedsRehash reports whether the stored hash should be upgraded to the preferred hasher.
+func (u *EntityUser) NeedsRehash(preferred misc.PasswordHasher) bool {
+	return u.PasswordAlgo != preferred.Algorithm() || preferred.NeedsRehash(string(u.Password))
 }
 
 func (u *EntityUser) GetAbsUser() (*AbsUser, error) {
@@ -38,11 +73,36 @@ func (u *EntityUser) GetAbsUser() (*AbsUser, error) {
 	}, nil
 }
 
+// GetAdminUser is the view of the admin API, it adds what only an administrator should see.
+func (u *EntityUser) GetAdminUser() *AdminUser {
+	return &AdminUser{
+		ID:                    *u.ID,
+		Identity:              u.Identity,
+		Status:                u.Status,
+		StatusReason:          u.StatusReason,
+		PasswordResetRequired: u.PasswordResetRequired,
+		Locale:                u.Locale,
+		CreatedAt:             u.Ctime.Format("2006-01-02 15:04:05"),
+		UpdatedAt:             u.Mtime.Format("2006-01-02 15:04:05"),
+	}
+}
+
+type AdminUser struct {
+	ID                    uuid.UUID `json:"id"`
+	Identity              string    `json:"identity"`
+	Status                string    `json:"status"`
+	StatusReason          *string   `json:"statusReason"`
+	PasswordResetRequired bool      `json:"passwordResetRequired"`
+	Locale                *string   `json:"locale"`
+	CreatedAt             string    `json:"createdAt"`
+	UpdatedAt             string    `json:"updatedAt"`
+}
+
 type AbsUser struct {
 	ID        uuid.UUID `json:"id"`
 	Identity  string    `json:"identity"`
-	Password  string    `json:"_"`
-	Salt      string    `json:"_"`
+	Password  string    `json:"-"`
+	Salt      string    `json:"-"`
 	Status    string    `json:"status"`
 	CreatedAt string    `json:"createdAt"`
 	UpdatedAt string    `json:"updatedAt"`
@@ -61,32 +121,117 @@ func NewUsersTableEngine(db *sqlx.DB) *UsersTableEngine {
 	}
 }
 
-func (e *UsersTableEngine) Insert(identity string, password string, status string) (*EntityUser, error) {
+// Insert creates a user with the first entry of its password history, an empty locale is stored as NULL so the
+// emails keep following the request.
+func (e *UsersTableEngine) Insert(tx *sqlx.Tx, hasher misc.PasswordHasher, identity string, password string, status string, locale string) (*EntityUser, error) {
 	saltBytes := make([]byte, PW_SALT_BYTES)
 	if _, err := io.ReadFull(rand.Reader, saltBytes); err != nil {
 		return nil, err
 	}
-	salt := string(saltBytes)
-
-	pwdBytes := []byte(password + salt)
 
-	hashBytes, hashErr := bcrypt.GenerateFromPassword(pwdBytes, bcrypt.DefaultCost)
+	encoded, hashErr := hasher.Hash([]byte(password), saltBytes)
 	if hashErr != nil {
 		return nil, hashErr
 	}
 
 	u := &EntityUser{
-		Prototype: model.NewPrototype(),
-		Identity:  identity,
-		Password:  hashBytes,
-		Salt:      saltBytes,
-		Status:    status,
+		Prototype:    model.NewPrototype(),
+		Identity:     identity,
+		Password:     []byte(encoded),
+		PasswordAlgo: hasher.Algorithm(),
+		Salt:         saltBytes,
+		Status:       status,
+	}
+	if locale != "" {
+		u.Locale = &locale
+	}
+
+	if err := insertTx(tx, e.TblName, u); err != nil {
+		return u, err
 	}
 
-	_, err := e.Engine.Insert(u)
+	_, err := NewPasswordHistoryTableEngine(e.Engine.DB).Insert(tx, u.ID, u.Password, u.PasswordAlgo, saltBytes)
 	return u, err
 }
 
+// InsertWithHash stores a hash produced elsewhere as it is. A fresh salt is still generated so the user can be
+// moved onto the native hasher after the first successful login.
+func (e *UsersTableEngine) InsertWithHash(identity string, passwordAlgo string, encoded string, status string) (*EntityUser, error) {
+	saltBytes := make([]byte, PW_SALT_BYTES)
+	if _, err := io.ReadFull(rand.Reader, saltBytes); err != nil {
+		return nil, err
+	}
+
+	u := &EntityUser{
+		Prototype:    model.NewPrototype(),
+		Identity:     identity,
+		Password:     []byte(encoded),
+		PasswordAlgo: passwordAlgo,
+		Salt:         saltBytes,
+		Status:       status,
+	}
+
+	err := WithTx(e.Engine.DB, func(tx *sqlx.Tx) error {
+		if err := insertTx(tx, e.TblName, u); err != nil {
+			return err
+		}
+
+		_, err := NewPasswordHistoryTableEngine(e.Engine.DB).Insert(tx, u.ID, u.Password, u.PasswordAlgo, saltBytes)
+		return err
+	})
+	return u, err
+}
+
+// Import inserts every record independently, a bad record is reported and never stops the rest.
+func (e *UsersTableEngine) Import(records []*misc.UserImportRecord) []*misc.UserImportResult {
+	results := make([]*misc.UserImportResult, len(records))
+
+	for i, r := range records {
+		results[i] = &misc.UserImportResult{
+			Line:     r.Line,
+			Identity: r.Identity,
+			Result:   misc.USER_IMPORT_RESULT_CREATED,
+		}
+
+		if err := e.importOne(r); err != nil {
+			results[i].Result = misc.USER_IMPORT_RESULT_FAILED
+			results[i].Error = err.Error()
+		}
+	}
+
+	return results
+}
+
+func (e *UsersTableEngine) importOne(r *misc.UserImportRecord) error {
+	if addr, err := mail.ParseAddress(r.Identity); err != nil || addr.Address != r.Identity || len(r.Identity) > 127 {
+		return errors.New("Invalid identity")
+	}
+
+	if r.Status == "" {
+		r.Status = USER_STATUS_ENABLED
+	} else if !IsValidUserStatus(r.Status) {
+		return errors.New("Invalid status")
+	}
+
+	hasher, err := misc.LookupImportableHasher(r.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	encoded := r.EncodedHash()
+	if err := hasher.CheckEncoded(encoded); err != nil {
+		return err
+	}
+
+	if _, err = e.InsertWithHash(r.Identity, r.Algorithm, encoded, r.Status); err != nil {
+		if myErr, ok := err.(*mysql.MySQLError); ok && myErr.Number == 1062 {
+			return errors.New("This Email is already exist.")
+		}
+	}
+
+	return err
+}
+
 func (e *UsersTableEngine) GetByID(id string) (*EntityUser, error) {
 	row := EntityUser{}
 	q := `SELECT * FROM ` + e.TblName + ` WHERE id = UUID_TO_BIN(?);`
@@ -115,27 +260,125 @@ func (e *UsersTableEngine) GetByIdentity(identity string) (*EntityUser, error) {
 	return &row, nil
 }
 
-func (e *UsersTableEngine) ResetPwd(id *uuid.UUID, password string, saltBytes []byte) (int64, error) {
-	salt := string(saltBytes)
+// ResetPwd writes the new password, adds it to the history and prunes the history of the user down to historySize
+// entries, all within the transaction.
+func (e *UsersTableEngine) ResetPwd(tx *sqlx.Tx, hasher misc.PasswordHasher, id *uuid.UUID, password string, saltBytes []byte, historySize int) (int64, error) {
+	encoded, hashErr := hasher.Hash([]byte(password), saltBytes)
+	if hashErr != nil {
+		return 0, hashErr
+	}
+
+	q := `UPDATE ` + e.TblName + ` SET password = ?, password_algo = ?, password_reset_required = 0 WHERE id = UUID_TO_BIN(?);`
+	rst, err := tx.Exec(q, []byte(encoded), hasher.Algorithm(), &id)
+	if err != nil {
+		return 0, err
+	}
+
+	historyEngine := NewPasswordHistoryTableEngine(e.Engine.DB)
+	if _, err := historyEngine.Insert(tx, id, []byte(encoded), hasher.Algorithm(), saltBytes); err != nil {
+		return 0, err
+	} else if _, err := historyEngine.PruneByUserID(tx, id, historySize); err != nil {
+		return 0, err
+	}
 
-	pwdBytes := []byte(password + salt)
+	return rst.RowsAffected()
+}
 
-	hashBytes, hashErr := bcrypt.GenerateFromPassword(pwdBytes, bcrypt.DefaultCost)
+// Rehash replaces the stored hash of the same password with one from the preferred hasher,
+// it is not a password change so nothing is added to the history.
+func (e *UsersTableEngine) Rehash(hasher misc.PasswordHasher, id *uuid.UUID, password string, saltBytes []byte) (int64, error) {
+	encoded, hashErr := hasher.Hash([]byte(password), saltBytes)
 	if hashErr != nil {
 		return 0, hashErr
 	}
 
-	q := `UPDATE ` + e.TblName + ` SET password = ? WHERE id = UUID_TO_BIN(?);`
-	if rst, err := e.Exec(q, hashBytes, &id); err != nil {
+	q := `UPDATE ` + e.TblName + ` SET password = ?, password_algo = ? WHERE id = UUID_TO_BIN(?);`
+	if rst, err := e.Exec(q, []byte(encoded), hasher.Algorithm(), &id); err != nil {
+		return 0, err
+	} else {
+		return rst.RowsAffected()
+	}
+}
+
+// UpdateStatus stores the reason next to the status, an empty reason is stored as NULL.
+func (e *UsersTableEngine) UpdateStatus(tx *sqlx.Tx, id *uuid.UUID, status string, reason string) (int64, error) {
+	var statusReason *string
+	if reason != "" {
+		statusReason = &reason
+	}
+
+	q := `UPDATE ` + e.TblName + ` SET status = ?, status_reason = ? WHERE id = UUID_TO_BIN(?);`
+	if rst, err := tx.Exec(q, status, statusReason, &id); err != nil {
+		return 0, err
+	} else {
+		return rst.RowsAffected()
+	}
+}
+
+// RequirePasswordReset refuses password logins until the password is changed through /auth/v1/password.
+func (e *UsersTableEngine) RequirePasswordReset(tx *sqlx.Tx, id *uuid.UUID) (int64, error) {
+	q := `UPDATE ` + e.TblName + ` SET password_reset_required = 1 WHERE id = UUID_TO_BIN(?);`
+	if rst, err := tx.Exec(q, &id); err != nil {
+		return 0, err
+	} else {
+		return rst.RowsAffected()
+	}
+}
+
+func (e *UsersTableEngine) UpdateLocale(id *uuid.UUID, locale string) (int64, error) {
+	q := `UPDATE ` + e.TblName + ` SET locale = ? WHERE id = UUID_TO_BIN(?);`
+	if rst, err := e.Exec(q, locale, &id); err != nil {
 		return 0, err
 	} else {
 		return rst.RowsAffected()
 	}
 }
 
-func (e *UsersTableEngine) UpdateStatus(id *uuid.UUID, status string) (int64, error) {
-	q := `UPDATE ` + e.TblName + ` SET status = ? WHERE id = UUID_TO_BIN(?);`
-	if rst, err := e.Exec(q, status, &id); err != nil {
+type UsersListFilter struct {
+	Status   string
+	Identity string
+	Order    string
+	Offset   int
+	Length   int
+}
+
+// List pages through the users ordered by ctime, Identity matches any part of the identity. It also returns the
+// number of users matching the filter.
+func (e *UsersTableEngine) List(f *UsersListFilter) ([]*EntityUser, int, error) {
+	where, args := []string{"1 = 1"}, []interface{}{}
+	if f.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, f.Status)
+	}
+	if f.Identity != "" {
+		where = append(where, `identity LIKE ? ESCAPE '\\'`)
+		args = append(args, "%"+likeEscaper.Replace(f.Identity)+"%")
+	}
+
+	total := 0
+	q := `SELECT COUNT(*) FROM ` + e.TblName + ` WHERE ` + strings.Join(where, " AND ") + `;`
+	if err := e.Engine.Get(&total, q, args...); err != nil {
+		return nil, 0, err
+	}
+
+	order := "DESC"
+	if f.Order == USERS_ORDER_ASC {
+		order = "ASC"
+	}
+
+	rows := []*EntityUser{}
+	q = `SELECT * FROM ` + e.TblName + ` WHERE ` + strings.Join(where, " AND ") + ` ORDER BY ctime ` + order + `, id LIMIT ?, ?;`
+	if err := e.Engine.Select(&rows, q, append(args, f.Offset, f.Length)...); err != nil {
+		return nil, 0, err
+	}
+
+	return rows, total, nil
+}
+
+// Delete removes the user, the tables referencing it are cleaned by their foreign keys.
+func (e *UsersTableEngine) Delete(tx *sqlx.Tx, id *uuid.UUID) (int64, error) {
+	q := `DELETE FROM ` + e.TblName + ` WHERE id = UUID_TO_BIN(?);`
+	if rst, err := tx.Exec(q, &id); err != nil {
 		return 0, err
 	} else {
 		return rst.RowsAffected()
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hexcraft-biz/model"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	WEBAUTHN_CHALLENGE_TYPE_REGISTER = "register"
+	WEBAUTHN_CHALLENGE_TYPE_LOGIN    = "login"
+	WEBAUTHN_CHALLENGE_TYPE_MFA      = "mfa"
+)
+
+// ================================================================
+// Data Struct
+// ================================================================
+type EntityWebAuthnChallenge struct {
+	*model.Prototype `dive:""`
+	UserID           *uuid.UUID `db:"user_id"`
+	Type             string     `db:"type"`
+	Challenge        []byte     `db:"challenge"`
+	ExpiresAt        *time.Time `db:"expires_at"`
+}
+
+// ================================================================
+// Engine
+// ================================================================
+type WebAuthnChallengesTableEngine struct {
+	*model.Engine
+}
+
+func NewWebAuthnChallengesTableEngine(db *sqlx.DB) *WebAuthnChallengesTableEngine {
+	return &WebAuthnChallengesTableEngine{
+		Engine: model.NewEngine(db, "webauthn_challenges"),
+	}
+}
+
+// Insert stores the challenge of a ceremony, userID is nil for a passkey login where the user is not known yet.
+func (e *WebAuthnChallengesTableEngine) Insert(userID *uuid.UUID, typ string, challenge []byte, expiresAt time.Time) (*EntityWebAuthnChallenge, error) {
+	expiresAt = expiresAt.UTC().Truncate(time.Second)
+
+	c := &EntityWebAuthnChallenge{
+		Prototype: model.NewPrototype(),
+		UserID:    userID,
+		Type:      typ,
+		Challenge: challenge,
+		ExpiresAt: &expiresAt,
+	}
+
+	_, err := e.Engine.Insert(c)
+	return c, err
+}
+
+// Consume returns the challenge and deletes it, so every challenge answers one ceremony only.
+// It returns nil when the challenge does not exist, has expired or was consumed concurrently.
+func (e *WebAuthnChallengesTableEngine) Consume(id string, typ string) (*EntityWebAuthnChallenge, error) {
+	row := EntityWebAuthnChallenge{}
+	q := `SELECT * FROM ` + e.TblName + ` WHERE id = UUID_TO_BIN(?) AND type = ? AND expires_at > ?;`
+	if err := e.Engine.Get(&row, q, id, typ, time.Now().UTC()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		} else {
+			return nil, err
+		}
+	}
+
+	q = `DELETE FROM ` + e.TblName + ` WHERE id = UUID_TO_BIN(?);`
+	if rst, err := e.Exec(q, id); err != nil {
+		return nil, err
+	} else if affected, err := rst.RowsAffected(); err != nil {
+		return nil, err
+	} else if affected != 1 {
+		return nil, nil
+	}
+
+	return &row, nil
+}
+
+func (e *WebAuthnChallengesTableEngine) DeleteExpired() (int64, error) {
+	q := `DELETE FROM ` + e.TblName + ` WHERE expires_at <= ?;`
+	if rst, err := e.Exec(q, time.Now().UTC()); err != nil {
+		return 0, err
+	} else {
+		return rst.RowsAffected()
+	}
+}
@@ -0,0 +1,138 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hexcraft-biz/model"
+	"github.com/jmoiron/sqlx"
+)
+
+// ================================================================
+// Data Struct
+// ================================================================
+type EntityWebAuthnCredential struct {
+	*model.Prototype `dive:""`
+	UserID           *uuid.UUID `db:"user_id"`
+	CredentialID     []byte     `db:"credential_id"`
+	PublicKey        []byte     `db:"public_key"`
+	SignCount        uint32     `db:"sign_count"`
+	AAGUID           []byte     `db:"aaguid"`
+	AttestationFmt   string     `db:"attestation_fmt"`
+	Transports       string     `db:"transports"`
+	Label            string     `db:"label"`
+	LastUsedAt       *time.Time `db:"last_used_at"`
+}
+
+func (c *EntityWebAuthnCredential) TransportList() []string {
+	if c.Transports == "" {
+		return nil
+	}
+	return strings.Split(c.Transports, ",")
+}
+
+type AbsWebAuthnCredential struct {
+	ID         string     `json:"id"`
+	Label      string     `json:"label"`
+	Transports []string   `json:"transports"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CreatedAt  *time.Time `json:"createdAt"`
+}
+
+func (c *EntityWebAuthnCredential) GetAbsCredential() *AbsWebAuthnCredential {
+	return &AbsWebAuthnCredential{
+		ID:         c.ID.String(),
+		Label:      c.Label,
+		Transports: c.TransportList(),
+		LastUsedAt: c.LastUsedAt,
+		CreatedAt:  c.Ctime,
+	}
+}
+
+// ================================================================
+// Engine
+// ================================================================
+type WebAuthnCredentialsTableEngine struct {
+	*model.Engine
+}
+
+func NewWebAuthnCredentialsTableEngine(db *sqlx.DB) *WebAuthnCredentialsTableEngine {
+	return &WebAuthnCredentialsTableEngine{
+		Engine: model.NewEngine(db, "webauthn_credentials"),
+	}
+}
+
+func (e *WebAuthnCredentialsTableEngine) Insert(userID *uuid.UUID, credentialID, publicKey []byte, signCount uint32, aaguid []byte, attestationFmt string, transports []string, label string) (*EntityWebAuthnCredential, error) {
+	c := &EntityWebAuthnCredential{
+		Prototype:      model.NewPrototype(),
+		UserID:         userID,
+		CredentialID:   credentialID,
+		PublicKey:      publicKey,
+		SignCount:      signCount,
+		AAGUID:         aaguid,
+		AttestationFmt: attestationFmt,
+		Transports:     strings.Join(transports, ","),
+		Label:          label,
+	}
+
+	_, err := e.Engine.Insert(c)
+	return c, err
+}
+
+func (e *WebAuthnCredentialsTableEngine) GetByCredentialID(credentialID []byte) (*EntityWebAuthnCredential, error) {
+	row := EntityWebAuthnCredential{}
+	q := `SELECT * FROM ` + e.TblName + ` WHERE credential_id = ?;`
+	if err := e.Engine.Get(&row, q, credentialID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		} else {
+			return nil, err
+		}
+	}
+
+	return &row, nil
+}
+
+func (e *WebAuthnCredentialsTableEngine) ListByUserID(userID *uuid.UUID) ([]*EntityWebAuthnCredential, error) {
+	rows := []*EntityWebAuthnCredential{}
+	q := `SELECT * FROM ` + e.TblName + ` WHERE user_id = UUID_TO_BIN(?) ORDER BY ctime;`
+	if err := e.Engine.Select(&rows, q, &userID); err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+func (e *WebAuthnCredentialsTableEngine) HasAny(userID *uuid.UUID) (bool, error) {
+	var exists bool
+	q := `SELECT EXISTS(SELECT 1 FROM ` + e.TblName + ` WHERE user_id = UUID_TO_BIN(?));`
+	if err := e.Engine.Get(&exists, q, &userID); err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+// UseSignCount stores the counter of an accepted assertion. The condition makes two concurrent assertions with
+// the same counter fail, unless the authenticator does not implement a counter at all.
+func (e *WebAuthnCredentialsTableEngine) UseSignCount(id *uuid.UUID, signCount uint32) (bool, error) {
+	q := `UPDATE ` + e.TblName + ` SET sign_count = ?, last_used_at = ? WHERE id = UUID_TO_BIN(?) AND (sign_count < ? OR (sign_count = 0 AND ? = 0));`
+	if rst, err := e.Exec(q, signCount, time.Now().UTC(), &id, signCount, signCount); err != nil {
+		return false, err
+	} else if affected, err := rst.RowsAffected(); err != nil {
+		return false, err
+	} else {
+		return affected == 1, nil
+	}
+}
+
+func (e *WebAuthnCredentialsTableEngine) DeleteByUserID(userID *uuid.UUID, id string) (int64, error) {
+	q := `DELETE FROM ` + e.TblName + ` WHERE id = UUID_TO_BIN(?) AND user_id = UUID_TO_BIN(?);`
+	if rst, err := e.Exec(q, id, &userID); err != nil {
+		return 0, err
+	} else {
+		return rst.RowsAffected()
+	}
+}
@@ -12,8 +12,13 @@ func New(cfg config.ConfigInterface) *gin.Engine {
 
 	// base features
 	features.LoadCommon(engine, cfg)
+	features.LoadWellKnown(engine, cfg)
 	// auth
 	features.LoadAuth(engine, cfg)
+	// oauth
+	features.LoadOAuth(engine, cfg)
+	// admin
+	features.LoadAdmin(engine, cfg)
 
 	return engine
 }
@@ -0,0 +1,3 @@
+ALTER TABLE users
+    ADD COLUMN `status_reason` VARCHAR(255) NULL DEFAULT NULL AFTER `status`,
+    ADD COLUMN `password_reset_required` TINYINT(1) NOT NULL DEFAULT 0 AFTER `password_algo`;
@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS oauth_clients(
+    `id` BINARY(16) NOT NULL,
+    `name` VARCHAR(128) NOT NULL,
+    `secret_hash` BINARY(32) NULL DEFAULT NULL,
+    `redirect_uris` TEXT NOT NULL,
+    `scopes` VARCHAR(255) NOT NULL DEFAULT '',
+    `ctime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `mtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY(`id`)
+) ENGINE InnoDB COLLATE 'utf8mb4_unicode_ci' CHARACTER SET 'utf8mb4';
+
+CREATE TABLE IF NOT EXISTS oauth_codes(
+    `id` BINARY(16) NOT NULL,
+    `code_hash` BINARY(32) NOT NULL,
+    `client_id` BINARY(16) NOT NULL,
+    `user_id` BINARY(16) NOT NULL,
+    `redirect_uri` VARCHAR(2048) NOT NULL,
+    `scope` VARCHAR(255) NOT NULL,
+    `nonce` VARCHAR(255) NULL DEFAULT NULL,
+    `code_challenge` VARCHAR(128) NOT NULL,
+    `expires_at` TIMESTAMP NOT NULL,
+    `ctime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `mtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY(`id`),
+    UNIQUE(`code_hash`),
+    INDEX(`expires_at`),
+    FOREIGN KEY(`client_id`) REFERENCES oauth_clients(`id`) ON DELETE CASCADE,
+    FOREIGN KEY(`user_id`) REFERENCES users(`id`) ON DELETE CASCADE
+) ENGINE InnoDB COLLATE 'utf8mb4_unicode_ci' CHARACTER SET 'utf8mb4';
+
+ALTER TABLE refresh_tokens
+    ADD COLUMN `client_id` BINARY(16) NULL DEFAULT NULL AFTER `user_id`,
+    ADD COLUMN `scope` VARCHAR(255) NULL DEFAULT NULL AFTER `token_hash`,
+    ADD FOREIGN KEY(`client_id`) REFERENCES oauth_clients(`id`) ON DELETE CASCADE;
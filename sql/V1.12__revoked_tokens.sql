@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS revoked_tokens(
+    `id` BINARY(16) NOT NULL,
+    `expires_at` TIMESTAMP NOT NULL,
+    `ctime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `mtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY(`id`),
+    INDEX(`expires_at`)
+) ENGINE InnoDB COLLATE 'utf8mb4_unicode_ci' CHARACTER SET 'utf8mb4';
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS sessions(
+    `id` BINARY(16) NOT NULL,
+    `user_id` BINARY(16) NOT NULL,
+    `user_agent` VARCHAR(512) NOT NULL DEFAULT '',
+    `ip` VARCHAR(45) NOT NULL DEFAULT '',
+    `device_label` VARCHAR(128) NOT NULL DEFAULT '',
+    `last_seen_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `ctime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `mtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY(`id`),
+    INDEX(`user_id`),
+    INDEX(`last_seen_at`),
+    FOREIGN KEY(`user_id`) REFERENCES users(`id`) ON DELETE CASCADE
+) ENGINE InnoDB COLLATE 'utf8mb4_unicode_ci' CHARACTER SET 'utf8mb4';
+
+ALTER TABLE refresh_tokens
+    ADD COLUMN `session_id` BINARY(16) NULL DEFAULT NULL AFTER `client_id`,
+    ADD FOREIGN KEY(`session_id`) REFERENCES sessions(`id`) ON DELETE CASCADE;
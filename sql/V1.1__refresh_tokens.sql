@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS refresh_tokens(
+    `id` BINARY(16) NOT NULL,
+    `user_id` BINARY(16) NOT NULL,
+    `token_hash` BINARY(32) NOT NULL,
+    `expires_at` TIMESTAMP NOT NULL,
+    `revoked_at` TIMESTAMP NULL DEFAULT NULL,
+    `ctime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `mtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY(`id`),
+    UNIQUE(`token_hash`),
+    INDEX(`user_id`),
+    FOREIGN KEY(`user_id`) REFERENCES users(`id`) ON DELETE CASCADE
+) ENGINE InnoDB COLLATE 'utf8mb4_unicode_ci' CHARACTER SET 'utf8mb4';
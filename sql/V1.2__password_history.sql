@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS password_history(
+    `id` BINARY(16) NOT NULL,
+    `user_id` BINARY(16) NOT NULL,
+    `password` BINARY(64) NOT NULL,
+    `salt` BINARY(16) NOT NULL,
+    `ctime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `mtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY(`id`),
+    INDEX(`user_id`, `ctime`),
+    FOREIGN KEY(`user_id`) REFERENCES users(`id`) ON DELETE CASCADE
+) ENGINE InnoDB COLLATE 'utf8mb4_unicode_ci' CHARACTER SET 'utf8mb4';
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS email_tokens(
+    `id` BINARY(16) NOT NULL,
+    `email` VARCHAR(127) NOT NULL,
+    `type` VARCHAR(16) NOT NULL,
+    `status` ENUM('pending', 'consumed', 'revoked') NOT NULL,
+    `expires_at` TIMESTAMP NOT NULL,
+    `ctime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `mtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY(`id`),
+    INDEX(`email`, `type`, `status`)
+) ENGINE InnoDB COLLATE 'utf8mb4_unicode_ci' CHARACTER SET 'utf8mb4';
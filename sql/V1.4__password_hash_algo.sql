@@ -0,0 +1,11 @@
+ALTER TABLE users
+    MODIFY `password` VARBINARY(255) NOT NULL,
+    ADD COLUMN `password_algo` VARCHAR(32) NOT NULL DEFAULT 'bcrypt' AFTER `password`;
+
+UPDATE users SET `password` = TRIM(TRAILING CHAR(0) FROM `password`);
+
+ALTER TABLE password_history
+    MODIFY `password` VARBINARY(255) NOT NULL,
+    ADD COLUMN `password_algo` VARCHAR(32) NOT NULL DEFAULT 'bcrypt' AFTER `password`;
+
+UPDATE password_history SET `password` = TRIM(TRAILING CHAR(0) FROM `password`);
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS user_totp(
+    `id` BINARY(16) NOT NULL,
+    `user_id` BINARY(16) NOT NULL,
+    `secret` VARCHAR(64) NOT NULL,
+    `status` ENUM('pending', 'enabled') NOT NULL,
+    `last_counter` BIGINT NULL DEFAULT NULL,
+    `ctime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `mtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY(`id`),
+    UNIQUE(`user_id`),
+    FOREIGN KEY(`user_id`) REFERENCES users(`id`) ON DELETE CASCADE
+) ENGINE InnoDB COLLATE 'utf8mb4_unicode_ci' CHARACTER SET 'utf8mb4';
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes(
+    `id` BINARY(16) NOT NULL,
+    `user_id` BINARY(16) NOT NULL,
+    `code_hash` BINARY(32) NOT NULL,
+    `used_at` TIMESTAMP NULL DEFAULT NULL,
+    `ctime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `mtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY(`id`),
+    INDEX(`user_id`, `code_hash`),
+    FOREIGN KEY(`user_id`) REFERENCES users(`id`) ON DELETE CASCADE
+) ENGINE InnoDB COLLATE 'utf8mb4_unicode_ci' CHARACTER SET 'utf8mb4';
@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS webauthn_credentials(
+    `id` BINARY(16) NOT NULL,
+    `user_id` BINARY(16) NOT NULL,
+    `credential_id` VARBINARY(1023) NOT NULL,
+    `public_key` BLOB NOT NULL,
+    `sign_count` INT UNSIGNED NOT NULL DEFAULT 0,
+    `aaguid` BINARY(16) NULL DEFAULT NULL,
+    `attestation_fmt` VARCHAR(32) NOT NULL,
+    `transports` VARCHAR(255) NOT NULL DEFAULT '',
+    `label` VARCHAR(64) NOT NULL DEFAULT '',
+    `last_used_at` TIMESTAMP NULL DEFAULT NULL,
+    `ctime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `mtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY(`id`),
+    UNIQUE(`credential_id`),
+    INDEX(`user_id`),
+    FOREIGN KEY(`user_id`) REFERENCES users(`id`) ON DELETE CASCADE
+) ENGINE InnoDB COLLATE 'utf8mb4_unicode_ci' CHARACTER SET 'utf8mb4';
+
+CREATE TABLE IF NOT EXISTS webauthn_challenges(
+    `id` BINARY(16) NOT NULL,
+    `user_id` BINARY(16) NULL DEFAULT NULL,
+    `type` ENUM('register', 'login', 'mfa') NOT NULL,
+    `challenge` BINARY(32) NOT NULL,
+    `expires_at` TIMESTAMP NOT NULL,
+    `ctime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `mtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY(`id`),
+    INDEX(`expires_at`),
+    FOREIGN KEY(`user_id`) REFERENCES users(`id`) ON DELETE CASCADE
+) ENGINE InnoDB COLLATE 'utf8mb4_unicode_ci' CHARACTER SET 'utf8mb4';
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS login_attempts(
+    `id` BINARY(16) NOT NULL,
+    `identity` VARCHAR(128) NOT NULL,
+    `failed_count` INT UNSIGNED NOT NULL DEFAULT 0,
+    `lock_count` INT UNSIGNED NOT NULL DEFAULT 0,
+    `locked_until` TIMESTAMP NULL DEFAULT NULL,
+    `last_failed_at` TIMESTAMP NOT NULL,
+    `ctime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `mtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY(`id`),
+    UNIQUE(`identity`),
+    INDEX(`last_failed_at`)
+) ENGINE InnoDB COLLATE 'utf8mb4_unicode_ci' CHARACTER SET 'utf8mb4';
@@ -0,0 +1,2 @@
+ALTER TABLE users
+    ADD COLUMN `locale` VARCHAR(35) NULL DEFAULT NULL AFTER `status`;
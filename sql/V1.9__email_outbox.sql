@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS email_outbox(
+    `id` BINARY(16) NOT NULL,
+    `type` VARCHAR(32) NOT NULL,
+    `sender_name` VARCHAR(128) NOT NULL,
+    `sender` VARCHAR(128) NOT NULL,
+    `recipient` VARCHAR(128) NOT NULL,
+    `subject` VARCHAR(255) NOT NULL,
+    `html` MEDIUMTEXT NOT NULL,
+    `text` MEDIUMTEXT NOT NULL,
+    `status` VARCHAR(16) NOT NULL,
+    `attempts` INT UNSIGNED NOT NULL DEFAULT 0,
+    `next_attempt_at` TIMESTAMP NOT NULL,
+    `claim_id` BINARY(16) NULL DEFAULT NULL,
+    `last_error` TEXT NULL DEFAULT NULL,
+    `sent_at` TIMESTAMP NULL DEFAULT NULL,
+    `ctime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `mtime` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY(`id`),
+    INDEX(`status`, `next_attempt_at`),
+    INDEX(`claim_id`),
+    INDEX(`sent_at`)
+) ENGINE InnoDB COLLATE 'utf8mb4_unicode_ci' CHARACTER SET 'utf8mb4';